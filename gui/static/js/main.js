@@ -603,6 +603,23 @@ $(function() {
         this.ws.onclose = logReconnect;
     }
 
+    // blocks whose out routes are named by their rule get their own copy of
+    // their type's routes, with the names of the rule's cases added.
+    function typeInfo(block) {
+        var info = library[block.Type];
+        if (!info.NamedOutRoutes || !block.Rule || !block.Rule.Cases) {
+            return info;
+        }
+        var named = $.extend({}, info);
+        named.OutRoutes = info.OutRoutes.slice();
+        block.Rule.Cases.forEach(function(c) {
+            if (named.OutRoutes.indexOf(c.Name) == -1) {
+                named.OutRoutes.push(c.Name);
+            }
+        });
+        return named;
+    }
+
     function uiReader() {
         _this = this;
         _this.handleMsg = null;
@@ -668,7 +685,7 @@ $(function() {
                         // so that we can load the correct route information
                         // for that block type
                         library[uiMsg.Data.Type].InRoutes.sort();
-                        uiMsg.Data.TypeInfo = library[uiMsg.Data.Type];
+                        uiMsg.Data.TypeInfo = typeInfo(uiMsg.Data);
                         blocks.push(uiMsg.Data);
                         update();
                         // we need to update the rule controller for the block.
@@ -704,6 +721,8 @@ $(function() {
                     }
                     if (block !== null) {
                         block.Rule = uiMsg.Data.Rule;
+                        block.TypeInfo = typeInfo(block);
+                        update();
                         d3.select('.controller[data-id=_' + block.Id + ']')[0][0].refresh();
                     }
                     break;
@@ -1158,10 +1177,10 @@ $(function() {
     function updateLinks() {
         link.attr('d', function(d) {
             return lineStyle([{
-                x: d.from.Position.X + HALF_ROUTE,
+                x: d.from.Position.X + (d.from.TypeInfo.OutRoutes.indexOf(d.FromRoute || 'out') * ROUTE_SPACE) + HALF_ROUTE,
                 y: (d.from.Position.Y + d.from.height * 2) - HALF_ROUTE
             }, {
-                x: d.from.Position.X + HALF_ROUTE,
+                x: d.from.Position.X + (d.from.TypeInfo.OutRoutes.indexOf(d.FromRoute || 'out') * ROUTE_SPACE) + HALF_ROUTE,
                 y: (d.from.Position.Y + d.from.height * 2) + ROUTE_SPACE
             }, {
                 x: d.to.Position.X + (d.to.TypeInfo.InRoutes.indexOf(d.ToRoute) * ROUTE_SPACE) + HALF_ROUTE,
@@ -1195,16 +1214,19 @@ $(function() {
 
         var connReq = {
             'FromId': null,
+            'FromRoute': null,
             'ToId': null,
             'ToRoute': null
         };
 
         if (newConn.startType == 'out') {
             connReq.FromId = newConn.start.Id;
+            connReq.FromRoute = newConn.startRoute;
             connReq.ToId = block.Id;
             connReq.ToRoute = route;
         } else {
             connReq.FromId = block.Id;
+            connReq.FromRoute = route;
             connReq.ToId = newConn.start.Id;
             connReq.ToRoute = newConn.startRoute;
         }
@@ -1223,10 +1245,10 @@ $(function() {
         newConnection.attr('d', function() {
             return lineStyle(newConn.startType == 'out' ?
                 [{
-                    x: newConn.start.Position.X + HALF_ROUTE,
+                    x: newConn.start.Position.X + (newConn.start.TypeInfo.OutRoutes.indexOf(newConn.startRoute) * ROUTE_SPACE) + HALF_ROUTE,
                     y: (newConn.start.Position.Y + newConn.start.height * 2) - HALF_ROUTE
                 }, {
-                    x: newConn.start.Position.X + HALF_ROUTE,
+                    x: newConn.start.Position.X + (newConn.start.TypeInfo.OutRoutes.indexOf(newConn.startRoute) * ROUTE_SPACE) + HALF_ROUTE,
                     y: (newConn.start.Position.Y + newConn.start.height * 2) + ROUTE_SPACE
                 }, {
                     x: mouse.x,
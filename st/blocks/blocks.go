@@ -4,10 +4,32 @@ import (
 	"fmt"
 	"github.com/nytlabs/streamtools/st/loghub"
 	"net/url"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
+// Overflow policies decide what happens to a message when the in route it is
+// destined for is full.
+const (
+	DROP_NEWEST  = "drop-newest" // discard the incoming message
+	DROP_OLDEST  = "drop-oldest" // discard the oldest buffered message
+	BACKPRESSURE = "block"       // stop accepting messages until there is room
+	SPILL        = "spill"       // buffer the overflow on disk
+)
+
+var OverflowPolicies = []string{DROP_NEWEST, DROP_OLDEST, BACKPRESSURE, SPILL}
+
+func ValidOverflow(policy string) bool {
+	for _, p := range OverflowPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
 type Msg struct {
 	Msg   interface{}
 	Route string
@@ -21,8 +43,9 @@ func (c MsgChan) MarshalJSON() ([]byte, error) {
 }
 
 type AddChanMsg struct {
-	Route   string
-	Channel chan *Msg
+	Route     string
+	FromRoute string
+	Channel   chan *Msg
 }
 
 type QueryMsg struct {
@@ -64,9 +87,24 @@ type Block struct {
 	inRoutes         map[string]MsgChan
 	queryRoutes      map[string]chan MsgChan
 	queryParamRoutes map[string]chan Query
-	broadcast        MsgChan
+	outRoutes        map[string]MsgChan
+	outRouteNames    []string
+	namedOut         chan *Msg
+	outbound         chan *Msg
+	errOut           MsgChan
+	done             chan bool
+	errMu            sync.Mutex
+	errors           int64
+	lastError        string
+	finite           bool
+	exhaustMu        sync.Mutex
+	exhausted        bool
+	snapshotRoute    chan MsgChan
+	restoreRoute     MsgChan
 	quit             MsgChan
-	doesBroadcast    bool
+	overflow         string
+	clock            Clock
+	looker           Looker
 	BlockChans
 	LogStreams
 }
@@ -78,6 +116,9 @@ type BlockDef struct {
 	QueryRoutes      []string
 	QueryParamRoutes []string
 	OutRoutes        []string
+	Package          string // where the block type comes from, filled in by the library
+	Finite           bool   // the block is a finite source, fed by polling it
+	NamedOutRoutes   bool   // the block emits on more out routes, named by its rule
 }
 
 type BlockInterface interface {
@@ -87,14 +128,27 @@ type BlockInterface interface {
 	Build(BlockChans)
 	Quit() MsgChan
 	Broadcast() MsgChan
+	OutRoute(string) MsgChan
 	InRoute(string) MsgChan
 	QueryRoute(string) chan MsgChan
 	QueryParamRoute(string) chan Query
+	SnapshotRoute() chan MsgChan
+	RestoreRoute() MsgChan
 	GetBlock() *Block
 	GetDef() *BlockDef
 	Log(interface{})
-	Error(interface{})
+	Error(interface{}, ...interface{})
 	SetId(string)
+	SetOverflow(string)
+	SetClock(Clock)
+	SetLooker(Looker)
+}
+
+// RuleChecker is implemented by blocks that can tell whether they would take a
+// rule without being run, so that patterns can be checked before they are
+// imported.
+type RuleChecker interface {
+	CheckRule(interface{}) error
 }
 
 func (b *Block) Build(c BlockChans) {
@@ -112,9 +166,20 @@ func (b *Block) Build(c BlockChans) {
 	b.inRoutes = make(map[string]MsgChan) // necessary to stop locking...
 	b.queryRoutes = make(map[string]chan MsgChan)
 	b.queryParamRoutes = make(map[string]chan Query)
+	b.outRoutes = make(map[string]MsgChan)
+	b.outRouteNames = nil
+	b.namedOut = nil
+	b.finite = false
+	b.snapshotRoute = nil
+	b.restoreRoute = nil
+
+	// everything a block emits on any of its out routes passes through here
+	b.outbound = make(chan *Msg, 10) // necessary to stop locking...
+	b.done = make(chan bool)
 
-	// broadcast channel
-	b.broadcast = make(MsgChan, 10) // necessary to stop locking...
+	// dead letters go out on the error route. it is never closed, as blocks
+	// may still report errors from their own goroutines after quitting.
+	b.errOut = make(MsgChan, 10)
 
 	// quit chan
 	b.quit = make(MsgChan)
@@ -127,6 +192,10 @@ func (b *Block) SetId(Id string) {
 	b.Id = Id
 }
 
+func (b *Block) SetOverflow(policy string) {
+	b.overflow = policy
+}
+
 func (b *Block) InRoute(routeName string) MsgChan {
 	route := make(MsgChan, 1000)
 	b.inRoutes[routeName] = route
@@ -145,9 +214,31 @@ func (b *Block) QueryParamRoute(routeName string) chan Query {
 	return route
 }
 
+// OutRoute returns the channel a block emits on for the named out route.
+// Connections choose which of a block's out routes they receive from.
+func (b *Block) OutRoute(routeName string) MsgChan {
+	if route, ok := b.outRoutes[routeName]; ok {
+		return route
+	}
+	route := make(MsgChan, 10) // necessary to stop locking...
+	b.outRoutes[routeName] = route
+	b.outRouteNames = append(b.outRouteNames, routeName)
+	return route
+}
+
+// NamedOutRoutes declares that the block emits on out routes it names at run
+// time, say from its rule, as well as on the ones it declares in Setup. Each
+// message sent on the channel it returns is emitted on the route it names.
+func (b *Block) NamedOutRoutes() chan *Msg {
+	if b.namedOut == nil {
+		b.namedOut = make(chan *Msg, 10) // necessary to stop locking...
+	}
+	return b.namedOut
+}
+
+// Broadcast returns the block's default out route.
 func (b *Block) Broadcast() MsgChan {
-	b.doesBroadcast = true
-	return b.broadcast
+	return b.OutRoute("out")
 }
 
 func (b *Block) Quit() MsgChan {
@@ -176,10 +267,14 @@ func (b *Block) GetDef() *BlockDef {
 		queryParamRoutes = append(queryParamRoutes, k)
 	}
 
-	if b.doesBroadcast {
-		outRoutes = []string{"out"}
+	queryRoutes = append(queryRoutes, "stats")
+	if b.snapshotRoute != nil {
+		queryRoutes = append(queryRoutes, "snapshot")
 	}
 
+	outRoutes = append(outRoutes, b.outRouteNames...)
+	outRoutes = append(outRoutes, "error")
+
 	return &BlockDef{
 		Type:             b.Kind,
 		Desc:             b.Desc,
@@ -187,6 +282,8 @@ func (b *Block) GetDef() *BlockDef {
 		QueryRoutes:      queryRoutes,
 		QueryParamRoutes: queryParamRoutes,
 		OutRoutes:        outRoutes,
+		Finite:           b.finite,
+		NamedOutRoutes:   b.namedOut != nil,
 	}
 }
 
@@ -204,8 +301,14 @@ func (b *Block) CleanUp() {
 	defer close(b.DelChan)
 	defer close(b.ErrChan)
 	defer close(b.QuitChan)
-	defer close(b.broadcast)
 	defer close(b.IdChan)
+	for route := range b.outRoutes {
+		defer close(b.outRoutes[route])
+	}
+	if b.namedOut != nil {
+		defer close(b.namedOut)
+	}
+	defer close(b.done)
 
 	go func(id string) {
 		loghub.Log <- &loghub.LogMsg{
@@ -216,14 +319,49 @@ func (b *Block) CleanUp() {
 	}(b.Id)
 }
 
-func (b *Block) Error(msg interface{}) {
+// ErrorRoute is the block's error route, for blocks that pass on the error
+// envelopes of other blocks, like composites do for the blocks inside them.
+func (b *Block) ErrorRoute() MsgChan {
+	return b.errOut
+}
+
+// Error logs err. If the message that caused the error is given, it is also
+// emitted on the block's error route, wrapped in an envelope along with the
+// error, the block id and the time the error occurred.
+func (b *Block) Error(err interface{}, msg ...interface{}) {
+	text := fmt.Sprint(err)
+	if e, ok := err.(error); ok {
+		text = e.Error()
+	}
+
+	b.errMu.Lock()
+	b.errors++
+	b.lastError = text
+	b.errMu.Unlock()
+
 	go func(id string) {
 		loghub.Log <- &loghub.LogMsg{
 			Type: loghub.ERROR,
-			Data: msg,
+			Data: err,
 			Id:   id,
 		}
 	}(b.Id)
+
+	if len(msg) == 0 {
+		return
+	}
+
+	env := map[string]interface{}{
+		"Msg":   msg[0],
+		"Error": text,
+		"Id":    b.Id,
+		"Time":  float64(time.Now().UnixNano()) / 1e6,
+	}
+
+	select {
+	case b.errOut <- env:
+	case <-b.done:
+	}
 }
 
 func (b *Block) Log(msg interface{}) {
@@ -236,18 +374,190 @@ func (b *Block) Log(msg interface{}) {
 	}(b.Id)
 }
 
+// forward moves messages a block emits on one of its out routes to the block
+// routine.
+func (b *Block) forward(routeName string, route MsgChan) {
+	for {
+		select {
+		case msg, ok := <-route:
+			if !ok {
+				return
+			}
+			select {
+			case b.outbound <- &Msg{
+				Msg:   msg,
+				Route: routeName,
+			}:
+			case <-b.done:
+				return
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// forwardNamed moves messages a block emits on the out routes it names to the
+// block routine.
+func (b *Block) forwardNamed() {
+	for {
+		select {
+		case msg, ok := <-b.namedOut:
+			if !ok {
+				return
+			}
+			select {
+			case b.outbound <- msg:
+			case <-b.done:
+				return
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
 func BlockRoutine(bi BlockInterface) {
 	var dropped int64
 	dropTicker := time.NewTicker(time.Duration(1 * time.Second))
 	dropTicker.Stop()
 
-	outChans := make(map[string]chan *Msg)
+	// when the block spills, overflowing messages are written to disk and fed
+	// back into the in route as it empties.
+	var spilling bool
+	spills := make(map[string]*spill)
+	spillTicker := time.NewTicker(50 * time.Millisecond)
+	spillTicker.Stop()
+
+	// the block's counters. to measure latency we remember when each message
+	// was put on an in route and look for it to leave again.
+	stats := newStats()
+	waits := make(map[string]*arrivals)
+	var measuring bool
+	measureTicker := time.NewTicker(100 * time.Millisecond)
+	measureTicker.Stop()
+
+	outChans := make(map[string]*AddChanMsg)
 	b := bi.GetBlock()
 	bi.Setup()
-	go bi.Run()
+	for routeName, route := range b.outRoutes {
+		go b.forward(routeName, route)
+	}
+	if b.namedOut != nil {
+		go b.forwardNamed()
+	}
+	go b.forward("error", b.errOut)
+
+	// Run is supervised: if it panics it is restarted, after a backoff, with
+	// the last rule it was sent. Connections live here, so they survive.
+	var lastRule interface{}
+	var restarts int
+	var failed bool
+	running := true
+	crashed := make(chan interface{})
+	restartTimer := time.NewTimer(0)
+	restartTimer.Stop()
+	go supervise(bi, crashed)
+
+	// blocks that keep state pick up where a block with the same id left off,
+	// and have their state written out every so often.
+	var saving bool
+	saved := make(chan error)
+	var snapshotTicker *time.Ticker
+	var snapshots <-chan time.Time
+	if b.snapshotRoute != nil && StateDir != "" {
+		if err := b.loadState(b.Id); err != nil {
+			b.Error(err)
+		}
+		snapshotTicker = time.NewTicker(SnapshotInterval)
+		snapshots = snapshotTicker.C
+	}
+
+	for route := range b.inRoutes {
+		waits[route] = &arrivals{}
+	}
+
+	// when the block applies backpressure a message that does not fit in its
+	// in route is held here and InChan is not read until it is delivered.
+	var pending interface{}
+	var pendingRoute MsgChan
+	var pendingName string
+	var pendingAt time.Time
+	inChan := b.InChan
+
+	drop := func() {
+		if dropped == 0 {
+			dropTicker.Stop()
+			dropTicker = time.NewTicker(1 * time.Second)
+		}
+
+		dropped++
+		stats.Dropped++
+	}
+
+	settle := func() {
+		now := time.Now()
+		waiting := false
+		for route, a := range waits {
+			a.settle(len(b.inRoutes[route]), now, &stats.Latency)
+			waiting = waiting || len(a.times) > 0
+		}
+
+		// keep looking while messages are waiting, even if nothing else
+		// happens in the meantime.
+		if waiting && !measuring {
+			measureTicker = time.NewTicker(100 * time.Millisecond)
+			measuring = true
+		}
+		if !waiting && measuring {
+			measureTicker.Stop()
+			measuring = false
+		}
+	}
 
 	for {
+		settle()
+
 		select {
+		case <-measureTicker.C:
+		case <-crashed:
+			running = false
+			restarts++
+			if MaxRestarts >= 0 && restarts > MaxRestarts {
+				failed = true
+				b.Error(fmt.Sprintf("Block failed: panicked %d times", restarts))
+				break
+			}
+
+			wait := backoff(restarts)
+			b.Log(fmt.Sprintf("Restarting block in %s", wait))
+			restartTimer.Reset(wait)
+		case <-snapshots:
+			if saving {
+				break
+			}
+			saving = true
+			go func(id string) {
+				err := b.saveState(id)
+				select {
+				case saved <- err:
+				case <-b.done:
+				}
+			}(b.Id)
+		case err := <-saved:
+			saving = false
+			if err != nil {
+				b.Error(err)
+			}
+		case <-restartTimer.C:
+			running = true
+			go supervise(bi, crashed)
+			if lastRule != nil {
+				select {
+				case b.inRoutes["rule"] <- lastRule:
+				default:
+				}
+			}
 		case <-dropTicker.C:
 			go func(id string, count int64) {
 				loghub.Log <- &loghub.LogMsg{
@@ -262,30 +572,130 @@ func BlockRoutine(bi BlockInterface) {
 			}
 
 			dropped = 0
-		case msg := <-b.InChan:
-			_, ok := b.inRoutes[msg.Route]
+		case pendingRoute <- pending:
+			waits[pendingName].push(pendingAt)
+			pending = nil
+			pendingRoute = nil
+			inChan = b.InChan
+		case <-spillTicker.C:
+			spilling = false
+			for route, s := range spills {
+				n, err := s.drain(b.inRoutes[route])
+				for i := 0; i < n; i++ {
+					waits[route].push(time.Now())
+				}
+				if err != nil {
+					b.Error(err)
+				}
+				spilling = spilling || s.count > 0
+			}
+
+			if !spilling {
+				spillTicker.Stop()
+			}
+		case msg := <-inChan:
+			route, ok := b.inRoutes[msg.Route]
 			if !ok {
 				break
 			}
 
-			// every in channel is buffered a 1000 messages.
-			// if we cannot immediately send to that in channel we place the msg
-			// in a go routine and notify the user that the block routine's
-			// buffer has overflowed. this still allows for unrecoverable
-			// overflows (for example: a stuck run() function), but at least it
-			// offloads to memory/cpu instead of blocking.
-			select {
-			case b.inRoutes[msg.Route] <- msg.Msg:
-			default:
-				if dropped == 0 {
-					dropTicker.Stop()
-					dropTicker = time.NewTicker(1 * time.Second)
+			stats.Received[msg.Route]++
+			now := time.Now()
+			wait := waits[msg.Route]
+
+			// every in channel is buffered a 1000 messages. what happens to
+			// a message that doesn't fit is decided by the block's overflow
+			// policy. by default we drop it and notify the user that the block
+			// routine's buffer has overflowed.
+			switch b.overflow {
+			case BACKPRESSURE:
+				select {
+				case route <- msg.Msg:
+					wait.push(now)
+				default:
+					pending = msg.Msg
+					pendingRoute = route
+					pendingName = msg.Route
+					pendingAt = now
+					inChan = nil
+				}
+			case DROP_OLDEST:
+				select {
+				case route <- msg.Msg:
+					wait.push(now)
+				default:
+					select {
+					case <-route:
+						wait.evict()
+					default:
+					}
+					select {
+					case route <- msg.Msg:
+						wait.push(now)
+					default:
+					}
+					drop()
+				}
+			case SPILL:
+				s := spills[msg.Route]
+				if s != nil {
+					n, err := s.drain(route)
+					for i := 0; i < n; i++ {
+						wait.push(now)
+					}
+					if err != nil {
+						b.Error(err)
+					}
+				}
+
+				// only skip the disk if nothing is waiting there, otherwise
+				// we would reorder the stream.
+				delivered := false
+				if s == nil || s.count == 0 {
+					select {
+					case route <- msg.Msg:
+						wait.push(now)
+						delivered = true
+					default:
+					}
+				}
+
+				if delivered {
+					break
+				}
+
+				if s == nil {
+					var err error
+					s, err = newSpill()
+					if err != nil {
+						b.Error(err)
+						drop()
+						break
+					}
+					spills[msg.Route] = s
+				}
+
+				if err := s.push(msg.Msg); err != nil {
+					b.Error(err)
+					drop()
+					break
 				}
 
-				dropped++
+				if !spilling {
+					spillTicker = time.NewTicker(50 * time.Millisecond)
+					spilling = true
+				}
+			default:
+				select {
+				case route <- msg.Msg:
+					wait.push(now)
+				default:
+					drop()
+				}
 			}
 
 			if msg.Route == "rule" {
+				lastRule = msg.Msg
 				go func(id string) {
 					loghub.UI <- &loghub.LogMsg{
 						Type: loghub.RULE_UPDATED,
@@ -298,10 +708,59 @@ func BlockRoutine(bi BlockInterface) {
 		case msg := <-b.QueryChan:
 
 			if msg.Route == "ping" {
+				if failed {
+					msg.MsgChan <- FAILED
+					continue
+				}
 				msg.MsgChan <- "OK"
 				continue
 			}
 
+			if msg.Route == "depth" {
+				depth := make(map[string]int)
+				for route, c := range b.inRoutes {
+					depth[route] = len(c)
+					if s, ok := spills[route]; ok {
+						depth[route] += s.count
+					}
+				}
+				if pendingRoute != nil {
+					for route, c := range b.inRoutes {
+						if c == pendingRoute {
+							depth[route]++
+						}
+					}
+				}
+				msg.MsgChan <- depth
+				continue
+			}
+
+			if msg.Route == "stats" {
+				b.errMu.Lock()
+				errors, lastError := b.errors, b.lastError
+				b.errMu.Unlock()
+				snapshot := stats.snapshot(errors, lastError)
+				snapshot.Exhausted = b.isExhausted()
+				msg.MsgChan <- snapshot
+				continue
+			}
+
+			if msg.Route == "snapshot" && b.snapshotRoute != nil {
+				go func(id string, c MsgChan) {
+					if err := b.saveState(id); err != nil {
+						b.Error(err)
+						c <- map[string]interface{}{
+							"Error": err.Error(),
+						}
+						return
+					}
+					c <- map[string]interface{}{
+						"Snapshot": statePath(id),
+					}
+				}(b.Id, msg.MsgChan)
+				continue
+			}
+
 			_, ok := b.queryRoutes[msg.Route]
 			if !ok {
 				break
@@ -317,6 +776,10 @@ func BlockRoutine(bi BlockInterface) {
 		case msg := <-b.QueryParamChan:
 
 			if msg.Route == "ping" {
+				if failed {
+					msg.RespChan <- FAILED
+					continue
+				}
 				msg.RespChan <- "OK"
 				continue
 			}
@@ -338,20 +801,49 @@ func BlockRoutine(bi BlockInterface) {
 				}()
 			}
 		case id := <-b.IdChan:
+			if b.snapshotRoute != nil && StateDir != "" {
+				err := os.Rename(statePath(b.Id), statePath(id))
+				if err != nil && !os.IsNotExist(err) {
+					b.Error(err)
+				}
+			}
 			b.SetId(id)
 		case msg := <-b.AddChan:
-			outChans[msg.Route] = msg.Channel
+			if msg.FromRoute == "" {
+				msg.FromRoute = "out"
+			}
+			outChans[msg.Route] = msg
 		case msg := <-b.DelChan:
 			delete(outChans, msg.Route)
-		case msg := <-b.broadcast:
+		case msg := <-b.outbound:
+			stats.Emitted[msg.Route]++
 			for _, v := range outChans {
-				v <- &Msg{
-					Msg:   msg,
+				if v.FromRoute != msg.Route {
+					continue
+				}
+				v.Channel <- &Msg{
+					Msg:   msg.Msg,
 					Route: "",
 				}
 			}
 		case <-b.QuitChan:
-			b.quit <- true
+			dropTicker.Stop()
+			spillTicker.Stop()
+			measureTicker.Stop()
+			restartTimer.Stop()
+			if snapshotTicker != nil {
+				snapshotTicker.Stop()
+			}
+			for _, s := range spills {
+				s.close()
+			}
+
+			if running {
+				select {
+				case b.quit <- true:
+				case <-crashed:
+				}
+			}
 			b.CleanUp()
 			return
 		}
@@ -0,0 +1,232 @@
+package blocks
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells blocks the time and wakes them up when they ask it to. Blocks
+// that work with time use their own clock, from b.Clock(), rather than the
+// time package, so that time can be made to pass on demand.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a time.Timer that comes from a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker that comes from a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// DefaultClock is the clock of blocks that haven't been given one.
+var DefaultClock Clock = RealClock{}
+
+// Clock returns the block's clock.
+func (b *Block) Clock() Clock {
+	if b.clock == nil {
+		return DefaultClock
+	}
+	return b.clock
+}
+
+// SetClock gives the block a clock other than the DefaultClock.
+func (b *Block) SetClock(c Clock) {
+	b.clock = c
+}
+
+// RealClock is the time as the time package tells it.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (RealClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// ManualClock is a clock that only moves when it is told to. Timers and
+// tickers fire as Advance takes the clock past their deadlines. Like their
+// counterparts in the time package, a ticker that falls behind drops ticks.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*manualWaiter
+}
+
+// NewManualClock returns a clock stopped at start.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (m *ManualClock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+// Set moves the clock to t, which may not be before the clock's time.
+func (m *ManualClock) Set(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t.Before(m.now) {
+		return
+	}
+
+	// fire everything that falls due on the way to t in order.
+	for {
+		sort.Stable(byDeadline(m.waiters))
+		if len(m.waiters) == 0 || m.waiters[0].deadline.After(t) {
+			break
+		}
+
+		w := m.waiters[0]
+		m.now = w.deadline
+		select {
+		case w.c <- w.deadline:
+		default:
+		}
+
+		// a ticker only fires once however many of its ticks are passed
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period * (t.Sub(w.deadline)/w.period + 1))
+		} else {
+			m.remove(w)
+		}
+	}
+
+	m.now = t
+}
+
+// Advance moves the clock on by d.
+func (m *ManualClock) Advance(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+func (m *ManualClock) NewTimer(d time.Duration) Timer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &manualWaiter{
+		clock: m,
+		c:     make(chan time.Time, 1),
+	}
+	m.add(w, d, 0)
+	return w
+}
+
+func (m *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &manualWaiter{
+		clock: m,
+		c:     make(chan time.Time, 1),
+	}
+	m.add(w, d, d)
+	return manualTicker{w}
+}
+
+// add schedules w to fire d from now, every period if it is a ticker. A timer
+// that is already due fires straight away.
+func (m *ManualClock) add(w *manualWaiter, d time.Duration, period time.Duration) {
+	w.deadline = m.now.Add(d)
+	w.period = period
+
+	if period == 0 && d <= 0 {
+		select {
+		case w.c <- m.now:
+		default:
+		}
+		return
+	}
+
+	m.waiters = append(m.waiters, w)
+}
+
+// remove unschedules w, reporting whether it was scheduled.
+func (m *ManualClock) remove(w *manualWaiter) bool {
+	for i, v := range m.waiters {
+		if v == w {
+			m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// manualWaiter is a timer or ticker on a ManualClock.
+type manualWaiter struct {
+	clock    *ManualClock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+func (w *manualWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *manualWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.remove(w)
+}
+
+func (w *manualWaiter) Reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	active := w.clock.remove(w)
+	w.clock.add(w, d, 0)
+	return active
+}
+
+// manualTicker is a ticker on a ManualClock.
+type manualTicker struct {
+	*manualWaiter
+}
+
+func (t manualTicker) Stop() {
+	t.manualWaiter.Stop()
+}
+
+type byDeadline []*manualWaiter
+
+func (s byDeadline) Len() int           { return len(s) }
+func (s byDeadline) Less(i, j int) bool { return s[i].deadline.Before(s[j].deadline) }
+func (s byDeadline) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
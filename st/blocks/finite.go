@@ -0,0 +1,22 @@
+package blocks
+
+// Finite declares that the block is a finite source, like a file. Every
+// message sent to its poll route makes it emit the next thing it has, and it
+// says when it has run out with SetExhausted. Patterns run in batch mode poll
+// such blocks until they are exhausted.
+func (b *Block) Finite() {
+	b.finite = true
+}
+
+// SetExhausted records whether a finite source has run out of things to emit.
+func (b *Block) SetExhausted(exhausted bool) {
+	b.exhaustMu.Lock()
+	b.exhausted = exhausted
+	b.exhaustMu.Unlock()
+}
+
+func (b *Block) isExhausted() bool {
+	b.exhaustMu.Lock()
+	defer b.exhaustMu.Unlock()
+	return b.exhausted
+}
@@ -0,0 +1,23 @@
+package blocks
+
+import (
+	"net/url"
+)
+
+// Looker answers a block that wants to know what another block in its
+// pattern knows, by asking that block on one of its query routes. The params
+// are for query routes that take them, and are nil otherwise.
+type Looker interface {
+	Lookup(id string, route string, params url.Values) (interface{}, error)
+}
+
+// Looker returns the block's looker, which is nil if the block isn't part of
+// a pattern.
+func (b *Block) Looker() Looker {
+	return b.looker
+}
+
+// SetLooker lets the block look things up in other blocks.
+func (b *Block) SetLooker(l Looker) {
+	b.looker = l
+}
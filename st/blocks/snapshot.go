@@ -0,0 +1,127 @@
+package blocks
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateDir is where blocks that can snapshot their state keep it, one file
+// per block id. Snapshots are disabled while it is empty.
+var StateDir = ""
+
+// SnapshotInterval is how often the state of such blocks is written out. It
+// must be positive.
+var SnapshotInterval = 1 * time.Minute
+
+// SnapshotRoute declares that the block keeps state worth saving. The block
+// routine sends a channel on it whenever it wants a snapshot, and the block
+// answers with its state encoded as JSON, or nil if it could not encode it.
+func (b *Block) SnapshotRoute() chan MsgChan {
+	if b.snapshotRoute == nil {
+		b.snapshotRoute = make(chan MsgChan)
+	}
+	return b.snapshotRoute
+}
+
+// RestoreRoute hands the block, as JSON, the state it answered on its
+// snapshot route when a block with the same id last ran.
+func (b *Block) RestoreRoute() MsgChan {
+	if b.restoreRoute == nil {
+		b.restoreRoute = make(MsgChan)
+	}
+	return b.restoreRoute
+}
+
+func statePath(id string) string {
+	return filepath.Join(StateDir, id+".json")
+}
+
+// saveState asks the block for its state and writes it to the state
+// directory. The file is replaced atomically, so a crash halfway through
+// leaves the previous snapshot intact.
+func (b *Block) saveState(id string) error {
+	if StateDir == "" {
+		return errors.New("no state directory set")
+	}
+
+	c := make(MsgChan)
+	select {
+	case b.snapshotRoute <- c:
+	case <-b.done:
+		return errors.New("block quit before taking a snapshot")
+	}
+
+	var state interface{}
+	select {
+	case state = <-c:
+	case <-b.done:
+		return errors.New("block quit before taking a snapshot")
+	}
+
+	data, ok := state.([]byte)
+	if !ok || data == nil {
+		return errors.New("block could not take a snapshot")
+	}
+
+	f, err := ioutil.TempFile(StateDir, id+".json.")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), statePath(id))
+}
+
+// loadState hands the block the last snapshot taken under id, if there is
+// one.
+func (b *Block) loadState(id string) error {
+	if StateDir == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(statePath(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		select {
+		case b.restoreRoute <- data:
+		case <-b.done:
+		}
+	}()
+
+	return nil
+}
+
+// RemoveState deletes the state saved under id. A block's state outlives the
+// block stopping, so it is up to whoever deletes the block for good to call
+// this.
+func RemoveState(id string) error {
+	if StateDir == "" {
+		return nil
+	}
+
+	err := os.Remove(statePath(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
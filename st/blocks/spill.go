@@ -0,0 +1,121 @@
+package blocks
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// SpillDir is where blocks using the SPILL overflow policy keep the messages
+// that do not fit in their in routes.
+var SpillDir = os.TempDir()
+
+// spill is a FIFO of messages stored on disk as lines of JSON. Messages are
+// appended by push and fed back into an in route by drain.
+type spill struct {
+	w       *os.File
+	r       *os.File
+	reader  *bufio.Reader
+	head    interface{}
+	hasHead bool
+	count   int
+	dirty   bool
+}
+
+func newSpill() (*spill, error) {
+	w, err := ioutil.TempFile(SpillDir, "streamtools-spill-")
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := os.Open(w.Name())
+	if err != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return nil, err
+	}
+
+	return &spill{
+		w:      w,
+		r:      r,
+		reader: bufio.NewReader(r),
+	}, nil
+}
+
+func (s *spill) push(msg interface{}) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.w.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	s.count++
+	s.dirty = true
+	return nil
+}
+
+// drain moves as many spilled messages into route as it can take without
+// blocking, and returns how many it moved.
+func (s *spill) drain(route MsgChan) (int, error) {
+	n := 0
+	for s.count > 0 {
+		if !s.hasHead {
+			line, err := s.reader.ReadBytes('\n')
+			if err != nil {
+				return n, err
+			}
+
+			var msg interface{}
+			err = json.Unmarshal(line, &msg)
+			if err != nil {
+				return n, err
+			}
+
+			s.head = msg
+			s.hasHead = true
+		}
+
+		select {
+		case route <- s.head:
+			s.head = nil
+			s.hasHead = false
+			s.count--
+			n++
+		default:
+			return n, nil
+		}
+	}
+
+	if !s.dirty {
+		return n, nil
+	}
+
+	// everything has been read back, start the file over so it doesn't grow
+	// forever.
+	s.dirty = false
+	if err := s.w.Truncate(0); err != nil {
+		return n, err
+	}
+
+	if _, err := s.w.Seek(0, 0); err != nil {
+		return n, err
+	}
+
+	if _, err := s.r.Seek(0, 0); err != nil {
+		return n, err
+	}
+
+	s.reader.Reset(s.r)
+	return n, nil
+}
+
+func (s *spill) close() {
+	s.r.Close()
+	s.w.Close()
+	os.Remove(s.w.Name())
+}
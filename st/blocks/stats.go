@@ -0,0 +1,82 @@
+package blocks
+
+import (
+	"time"
+)
+
+// Stats are the counters the block routine keeps for every block. They are
+// returned by the block's built-in stats query route.
+type Stats struct {
+	Received  map[string]int64 // messages that arrived on each in route
+	Emitted   map[string]int64 // messages emitted on each out route
+	Errors    int64            // errors the block has reported
+	LastError string           // the most recent of them
+	Dropped   int64            // messages lost to the overflow policy
+	Latency   Latency
+	Exhausted bool // a finite source has nothing left to emit
+}
+
+// Latency summarises how long messages waited on a block's in routes before
+// the block took them. As a block only takes its next message once it is done
+// with the last one, this includes the time the block spends processing.
+type Latency struct {
+	Count int64   // number of messages measured
+	Sum   float64 // total wait, in seconds
+	Max   float64 // longest wait, in seconds
+}
+
+func newStats() *Stats {
+	return &Stats{
+		Received: make(map[string]int64),
+		Emitted:  make(map[string]int64),
+	}
+}
+
+// snapshot copies the stats so they can be handed to another goroutine.
+func (s *Stats) snapshot(errors int64, lastError string) Stats {
+	c := *s
+	c.Errors = errors
+	c.LastError = lastError
+	c.Received = make(map[string]int64)
+	for k, v := range s.Received {
+		c.Received[k] = v
+	}
+	c.Emitted = make(map[string]int64)
+	for k, v := range s.Emitted {
+		c.Emitted[k] = v
+	}
+	return c
+}
+
+// arrivals remembers when each message sitting in an in route arrived.
+type arrivals struct {
+	times []time.Time
+}
+
+// push records a message that has been put on the in route.
+func (a *arrivals) push(t time.Time) {
+	a.times = append(a.times, t)
+}
+
+// evict forgets the oldest message, when it was removed from the in route by
+// something other than the block.
+func (a *arrivals) evict() {
+	if len(a.times) > 0 {
+		a.times = a.times[1:]
+	}
+}
+
+// settle measures the messages the block has taken off the in route since we
+// last looked, given that queued messages are still waiting there.
+func (a *arrivals) settle(queued int, now time.Time, l *Latency) {
+	for len(a.times) > queued {
+		wait := now.Sub(a.times[0]).Seconds()
+		a.times = a.times[1:]
+
+		l.Count++
+		l.Sum += wait
+		if wait > l.Max {
+			l.Max = wait
+		}
+	}
+}
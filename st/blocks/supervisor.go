@@ -0,0 +1,57 @@
+package blocks
+
+import (
+	"fmt"
+	"github.com/nytlabs/streamtools/st/loghub"
+	"runtime/debug"
+	"time"
+)
+
+// MaxRestarts is how many times a block that panics is restarted before it is
+// marked as failed. A negative value restarts blocks forever.
+var MaxRestarts = 5
+
+// RestartBackoff is how long we wait before restarting a block that panicked
+// for the first time. The wait doubles with every restart, up to
+// MaxRestartBackoff.
+var RestartBackoff = 100 * time.Millisecond
+var MaxRestartBackoff = 1 * time.Minute
+
+// FAILED is what a block answers to ping once it has run out of restarts.
+const FAILED = "FAILED"
+
+// supervise runs the block. If Run panics, the panic is logged along with its
+// stack trace and reported on crashed instead of taking the process down.
+func supervise(bi BlockInterface, crashed chan interface{}) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		stack := string(debug.Stack())
+		go func(id string) {
+			loghub.Log <- &loghub.LogMsg{
+				Type: loghub.ERROR,
+				Data: fmt.Sprintf("Block panicked: %v\n%s", r, stack),
+				Id:   id,
+			}
+		}(bi.GetBlock().Id)
+
+		crashed <- r
+	}()
+
+	bi.Run()
+}
+
+// backoff returns how long to wait before the nth restart of a block.
+func backoff(n int) time.Duration {
+	d := RestartBackoff
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= MaxRestartBackoff {
+			return MaxRestartBackoff
+		}
+	}
+	return d
+}
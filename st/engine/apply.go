@@ -0,0 +1,265 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/library"
+)
+
+// PatternDiff is what it takes to turn the running pattern into another one.
+// A block whose type or overflow policy changes is deleted and created again,
+// as are connections that are wired differently.
+type PatternDiff struct {
+	CreatedBlocks      []string
+	DeletedBlocks      []string
+	UpdatedRules       []string
+	MovedBlocks        []string
+	CreatedConnections []string
+	DeletedConnections []string
+}
+
+// normalize round-trips v through JSON, so that values held by blocks can be
+// compared with values that came in over the API.
+func normalize(v interface{}) interface{} {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	var n interface{}
+	err = json.Unmarshal(j, &n)
+	if err != nil {
+		return v
+	}
+
+	return n
+}
+
+// ruleChanged reports whether sending want to a block whose rule is have
+// would change anything. Rule parameters want leaves out are left alone by
+// blocks, so they don't count.
+func ruleChanged(have, want interface{}) bool {
+	if want == nil {
+		return false
+	}
+
+	have = normalize(have)
+	want = normalize(want)
+
+	wantMap, ok := want.(map[string]interface{})
+	if !ok {
+		return !reflect.DeepEqual(have, want)
+	}
+
+	haveMap, ok := have.(map[string]interface{})
+	if !ok {
+		return true
+	}
+
+	for k, v := range wantMap {
+		if !reflect.DeepEqual(haveMap[k], v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sameWiring(a, b *ConnectionInfo) bool {
+	aFrom, bFrom := a.FromRoute, b.FromRoute
+	if aFrom == "" {
+		aFrom = "out"
+	}
+	if bFrom == "" {
+		bFrom = "out"
+	}
+	return a.FromId == b.FromId && aFrom == bFrom && a.ToId == b.ToId && a.ToRoute == b.ToRoute
+}
+
+// diffPattern works out how to turn the running pattern into p.
+func (e *Engine) diffPattern(p *Pattern) *PatternDiff {
+	diff := &PatternDiff{
+		CreatedBlocks:      []string{},
+		DeletedBlocks:      []string{},
+		UpdatedRules:       []string{},
+		MovedBlocks:        []string{},
+		CreatedConnections: []string{},
+		DeletedConnections: []string{},
+	}
+
+	wanted := make(map[string]*BlockInfo)
+	for _, block := range p.Blocks {
+		wanted[block.Id] = block
+	}
+
+	// blocks that are deleted take their connections with them
+	gone := make(map[string]bool)
+
+	for _, running := range e.listBlocks() {
+		want, ok := wanted[running.Id]
+
+		overflow := blocks.DROP_NEWEST
+		if ok && want.Overflow != "" {
+			overflow = want.Overflow
+		}
+
+		if !ok || want.Type != running.Type || overflow != running.Overflow {
+			diff.DeletedBlocks = append(diff.DeletedBlocks, running.Id)
+			gone[running.Id] = true
+			continue
+		}
+
+		def := library.BlockDefs[running.Type]
+		if hasRoute(def.InRoutes, "rule") && ruleChanged(running.Rule, want.Rule) {
+			diff.UpdatedRules = append(diff.UpdatedRules, running.Id)
+		}
+
+		if want.Position != nil && (running.Position == nil || *want.Position != *running.Position) {
+			diff.MovedBlocks = append(diff.MovedBlocks, running.Id)
+		}
+	}
+
+	for _, block := range p.Blocks {
+		if !e.idExists(block.Id) || gone[block.Id] {
+			diff.CreatedBlocks = append(diff.CreatedBlocks, block.Id)
+		}
+	}
+
+	wantedConns := make(map[string]*ConnectionInfo)
+	for _, conn := range p.Connections {
+		wantedConns[conn.Id] = conn
+	}
+
+	kept := make(map[string]bool)
+	for _, running := range e.listConnections() {
+		want, ok := wantedConns[running.Id]
+		if ok && sameWiring(running, want) && !gone[running.FromId] && !gone[running.ToId] {
+			kept[running.Id] = true
+			continue
+		}
+		diff.DeletedConnections = append(diff.DeletedConnections, running.Id)
+	}
+
+	for _, conn := range p.Connections {
+		if !kept[conn.Id] {
+			diff.CreatedConnections = append(diff.CreatedConnections, conn.Id)
+		}
+	}
+
+	return diff
+}
+
+// Apply turns the running pattern into p, leaving alone anything that does
+// not change, and returns what it did, even if it fails part way. With dryrun
+// it only works out what it would do. Every block and connection in p must
+// have an id.
+func (e *Engine) Apply(p *Pattern, dryrun bool) (*PatternDiff, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	added, err := registerComposites(p.Composites)
+	if err != nil {
+		return nil, errors.New("Cannot apply pattern: " + err.Error())
+	}
+
+	problems := validatePattern(p)
+	for i, block := range p.Blocks {
+		if block != nil && block.Id == "" {
+			problems = append(problems, fmt.Sprintf("block %d: needs an id", i))
+		}
+	}
+	for i, conn := range p.Connections {
+		if conn != nil && conn.Id == "" {
+			problems = append(problems, fmt.Sprintf("connection %d: needs an id", i))
+		}
+	}
+	if len(problems) > 0 {
+		unregisterComposites(added)
+		return nil, errors.New("Cannot apply pattern: " + strings.Join(problems, "; "))
+	}
+
+	diff := e.diffPattern(p)
+	if dryrun {
+		unregisterComposites(added)
+		return diff, nil
+	}
+
+	wanted := make(map[string]*BlockInfo)
+	for _, block := range p.Blocks {
+		wanted[block.Id] = block
+	}
+
+	wantedConns := make(map[string]*ConnectionInfo)
+	for _, conn := range p.Connections {
+		wantedConns[conn.Id] = conn
+	}
+
+	// if something goes wrong part way, we report what was done so far, and
+	// drop the composite types we added unless blocks were made of them.
+	done := &PatternDiff{}
+	fail := func(err error) (*PatternDiff, error) {
+		var unused []string
+		for _, t := range added {
+			if !e.compositeInUse(t) {
+				unused = append(unused, t)
+			}
+		}
+		unregisterComposites(unused)
+		return done, err
+	}
+
+	for _, id := range diff.DeletedConnections {
+		if _, err := e.deleteConnection(id); err != nil {
+			return fail(err)
+		}
+		done.DeletedConnections = append(done.DeletedConnections, id)
+	}
+
+	for _, id := range diff.DeletedBlocks {
+		// only a block created again as the same type has a use for the
+		// old one's state.
+		recreated := wanted[id] != nil && wanted[id].Type == e.blockMap[id].Type
+		if _, err := e.deleteBlock(id); err != nil {
+			return fail(err)
+		}
+		if !recreated {
+			blocks.RemoveState(id)
+		}
+		done.DeletedBlocks = append(done.DeletedBlocks, id)
+	}
+
+	for _, id := range diff.UpdatedRules {
+		if err := e.send(id, "rule", wanted[id].Rule); err != nil {
+			return fail(err)
+		}
+		done.UpdatedRules = append(done.UpdatedRules, id)
+	}
+
+	for _, id := range diff.MovedBlocks {
+		if _, err := e.updateBlockPosition(id, wanted[id].Position); err != nil {
+			return fail(err)
+		}
+		done.MovedBlocks = append(done.MovedBlocks, id)
+	}
+
+	for _, id := range diff.CreatedBlocks {
+		if _, err := e.create(wanted[id]); err != nil {
+			return fail(err)
+		}
+		done.CreatedBlocks = append(done.CreatedBlocks, id)
+	}
+
+	for _, id := range diff.CreatedConnections {
+		if _, err := e.connect(wantedConns[id]); err != nil {
+			return fail(err)
+		}
+		done.CreatedConnections = append(done.CreatedConnections, id)
+	}
+
+	return diff, nil
+}
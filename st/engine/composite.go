@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/nytlabs/streamtools/st/library"
+)
+
+// compositeList returns the composites in the library, ordered by type.
+func compositeList() []*library.CompositeDef {
+	var types []string
+	for t := range library.Composites {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	composites := []*library.CompositeDef{}
+	for _, t := range types {
+		composites = append(composites, library.Composites[t])
+	}
+	return composites
+}
+
+// registerComposites adds the composites the library doesn't have yet and
+// returns their types. Composites the library already has must be defined the
+// same way. If any can't be added, none are.
+func registerComposites(defs []*library.CompositeDef) ([]string, error) {
+	var added []string
+	pending := defs
+
+	for len(pending) > 0 {
+		var next []*library.CompositeDef
+		var lastErr error
+
+		for _, d := range pending {
+			if d == nil {
+				continue
+			}
+
+			if have, ok := library.Composites[d.Type]; ok {
+				if !reflect.DeepEqual(normalize(have), normalize(d)) {
+					unregisterComposites(added)
+					return nil, errors.New(fmt.Sprintf("Cannot register composite %s: it is already defined differently", d.Type))
+				}
+				continue
+			}
+
+			if err := library.RegisterComposite(d); err != nil {
+				lastErr = err
+				next = append(next, d)
+				continue
+			}
+			added = append(added, d.Type)
+		}
+
+		// composites can be made with each other, so keep going for as long
+		// as we get anywhere.
+		if len(next) > 0 && len(next) == len(pending) {
+			unregisterComposites(added)
+			return nil, lastErr
+		}
+		pending = next
+	}
+
+	return added, nil
+}
+
+// unregisterComposites removes composites added by registerComposites.
+func unregisterComposites(types []string) {
+	for i := len(types) - 1; i >= 0; i-- {
+		library.UnregisterComposite(types[i])
+	}
+}
+
+// compositeInUse reports whether there are blocks of the composite type t.
+func (e *Engine) compositeInUse(t string) bool {
+	for _, block := range e.blockMap {
+		if block.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Composites returns the definitions of all composite block types.
+func (e *Engine) Composites() []*library.CompositeDef {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return compositeList()
+}
+
+// DefineComposite adds a composite block type to the library, or redefines
+// one there are no blocks of.
+func (e *Engine) DefineComposite(d *library.CompositeDef) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if d == nil {
+		return errors.New("Cannot register composite: no composite data")
+	}
+
+	old, exists := library.Composites[d.Type]
+	if exists {
+		if e.compositeInUse(d.Type) {
+			return errors.New(fmt.Sprintf("Cannot redefine composite %s: there are blocks of this type", d.Type))
+		}
+
+		err := library.UnregisterComposite(d.Type)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := library.RegisterComposite(d)
+	if err != nil {
+		if exists {
+			library.RegisterComposite(old)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RemoveComposite removes a composite block type from the library.
+func (e *Engine) RemoveComposite(t string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.compositeInUse(t) {
+		return errors.New(fmt.Sprintf("Cannot remove composite %s: there are blocks of this type", t))
+	}
+
+	return library.UnregisterComposite(t)
+}
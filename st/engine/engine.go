@@ -0,0 +1,827 @@
+// Package engine runs patterns of blocks. It does everything the streamtools
+// server does short of speaking HTTP, so that patterns can be run, and tested,
+// from Go programs. The library must be started before blocks are created.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/library"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type BlockInfo struct {
+	Id       string
+	Type     string
+	Rule     interface{}
+	Position *Coords
+	Overflow string
+	Depth    map[string]int
+	chans    blocks.BlockChans
+}
+
+type ConnectionInfo struct {
+	Id        string
+	FromId    string
+	FromRoute string
+	ToId      string
+	ToRoute   string
+	chans     blocks.BlockChans
+}
+
+type Coords struct {
+	X float64
+	Y float64
+}
+
+// Engine is a running pattern. It is safe to use from several goroutines.
+type Engine struct {
+	blockMap map[string]*BlockInfo
+	connMap  map[string]*ConnectionInfo
+	genId    chan string
+	clock    blocks.Clock
+	mu       sync.Mutex
+}
+
+func IDService(idChan chan string) {
+	i := 1
+	for {
+		id := strconv.Itoa(i)
+		idChan <- id
+		i += 1
+	}
+}
+
+func New() *Engine {
+	idChan := make(chan string)
+	go IDService(idChan)
+	return &Engine{
+		blockMap: make(map[string]*BlockInfo),
+		connMap:  make(map[string]*ConnectionInfo),
+		genId:    idChan,
+	}
+}
+
+// SetClock gives the blocks created from now on a clock other than the
+// blocks.DefaultClock, such as a blocks.ManualClock to run a pattern in
+// simulated time.
+func (e *Engine) SetClock(c blocks.Clock) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clock = c
+}
+
+func newChans() blocks.BlockChans {
+	return blocks.BlockChans{
+		InChan:         make(chan *blocks.Msg),
+		QueryChan:      make(chan *blocks.QueryMsg),
+		QueryParamChan: make(chan *blocks.QueryParamMsg),
+		AddChan:        make(chan *blocks.AddChanMsg),
+		DelChan:        make(chan *blocks.Msg),
+		ErrChan:        make(chan error),
+		IdChan:         make(chan string),
+		QuitChan:       make(chan bool),
+	}
+}
+
+func (e *Engine) getId() string {
+	id := <-e.genId
+	ok := e.idExists(id)
+	for ok {
+		id = <-e.genId
+		ok = e.idExists(id)
+	}
+	return id
+}
+
+func (e *Engine) idExists(id string) bool {
+	_, okB := e.blockMap[id]
+	_, okC := e.connMap[id]
+	return okB || okC
+}
+
+// IdExists reports whether there is a block or connection with the id.
+func (e *Engine) IdExists(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.idExists(id)
+}
+
+func IdSafe(id string) bool {
+	return url.QueryEscape(id) == id && id != "DAEMON"
+}
+
+// Create starts a block. Its id, position and overflow policy are filled in
+// if they are missing.
+func (e *Engine) Create(blockInfo *BlockInfo) (*BlockInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.create(blockInfo)
+}
+
+func (e *Engine) create(blockInfo *BlockInfo) (*BlockInfo, error) {
+	if blockInfo == nil {
+		return nil, errors.New("Cannot create block: no block data.")
+	}
+
+	// check to see if the ID is OK
+	if !IdSafe(blockInfo.Id) {
+		return nil, errors.New(fmt.Sprintf("Cannot create block %s: invalid id", blockInfo.Id))
+	}
+
+	// create ID if there is none
+	if blockInfo.Id == "" {
+		blockInfo.Id = e.getId()
+	}
+
+	// make sure ID doesn't already exist
+	if e.idExists(blockInfo.Id) {
+		return nil, errors.New(fmt.Sprintf("Cannot create block %s: id already exists", blockInfo.Id))
+	}
+
+	// give the block a position if it doesn't have one.
+	if blockInfo.Position == nil {
+		blockInfo.Position = &Coords{
+			X: 0,
+			Y: 0,
+		}
+	}
+
+	_, ok := library.Blocks[blockInfo.Type]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot create block %s: invalid block type %s", blockInfo.Id, blockInfo.Type))
+	}
+
+	// drop the newest messages on overflow unless told otherwise
+	if blockInfo.Overflow == "" {
+		blockInfo.Overflow = blocks.DROP_NEWEST
+	}
+
+	if !blocks.ValidOverflow(blockInfo.Overflow) {
+		return nil, errors.New(fmt.Sprintf("Cannot create block %s: invalid overflow policy %s", blockInfo.Id, blockInfo.Overflow))
+	}
+
+	// create the block
+	newBlock := library.Blocks[blockInfo.Type]()
+	newBlockChans := newChans()
+
+	newBlock.SetId(blockInfo.Id)
+	newBlock.SetOverflow(blockInfo.Overflow)
+	if e.clock != nil {
+		newBlock.SetClock(e.clock)
+	}
+	newBlock.SetLooker(e)
+	newBlock.Build(newBlockChans)
+	go blocks.BlockRoutine(newBlock)
+
+	// save state
+	blockInfo.chans = newBlockChans
+	e.blockMap[blockInfo.Id] = blockInfo
+
+	if blockInfo.Rule != nil {
+		err := e.send(blockInfo.Id, "rule", blockInfo.Rule)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		e.updateRule(blockInfo.Id)
+	}
+
+	return blockInfo, nil
+}
+
+func (e *Engine) UpdateBlockPosition(id string, coord *Coords) (*BlockInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.updateBlockPosition(id, coord)
+}
+
+func (e *Engine) updateBlockPosition(id string, coord *Coords) (*BlockInfo, error) {
+	block, ok := e.blockMap[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot update block %s: does not exist", id))
+	}
+
+	block.Position = coord
+
+	return block, nil
+}
+
+// Send puts msg on one of a block's in routes.
+func (e *Engine) Send(id string, route string, msg interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.send(id, route, msg)
+}
+
+func (e *Engine) send(id string, route string, msg interface{}) error {
+	_, ok := e.blockMap[id]
+	if !ok {
+		return errors.New(fmt.Sprintf("Cannot send to block %s: does not exist", id))
+	}
+	// send message to block here
+	e.blockMap[id].chans.InChan <- &blocks.Msg{
+		Msg:   msg,
+		Route: route,
+	}
+
+	return nil
+}
+
+// QueryBlock asks one of a block's query routes for its answer.
+func (e *Engine) QueryBlock(id string, route string) (interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.queryBlock(id, route)
+}
+
+func (e *Engine) queryBlock(id string, route string) (interface{}, error) {
+	_, ok := e.blockMap[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot query block %s: does not exist", id))
+	}
+	return query(id, e.blockMap[id].chans, route)
+}
+
+// query asks a block on one of its query routes, giving up if the block
+// doesn't take the query or answer it in time. The answer is buffered, so a
+// block that answers late isn't left waiting.
+func query(id string, chans blocks.BlockChans, route string) (interface{}, error) {
+	var returnToSender blocks.MsgChan
+	returnToSender = make(chan interface{}, 1)
+	timeout := time.NewTimer(1 * time.Second)
+	select {
+	case chans.QueryChan <- &blocks.QueryMsg{
+		Route:   route,
+		MsgChan: returnToSender,
+	}:
+	case <-timeout.C:
+		return nil, errors.New(fmt.Sprintf("Cannot query block %s: timeout", id))
+	}
+	select {
+	case q := <-returnToSender:
+		return q, nil
+	case <-timeout.C:
+		return nil, errors.New(fmt.Sprintf("Cannot query block %s: timeout", id))
+	}
+}
+
+// CheckRule says whether a block of the given type would take the rule, as
+// far as can be told without running one: rules are objects, and block types
+// that implement blocks.RuleChecker check the rest.
+func CheckRule(blockType string, rule interface{}) error {
+	newBlock, ok := library.Blocks[blockType]
+	if !ok {
+		return errors.New(fmt.Sprintf("invalid block type %s", blockType))
+	}
+
+	if _, ok := rule.(map[string]interface{}); !ok {
+		return errors.New("rule must be an object")
+	}
+
+	checker, ok := newBlock().(blocks.RuleChecker)
+	if !ok {
+		return nil
+	}
+	return checker.CheckRule(rule)
+}
+
+func (e *Engine) QueryParamBlock(id string, route string, params url.Values) (interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.blockMap[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot query block %s: does not exist", id))
+	}
+	return queryParam(id, e.blockMap[id].chans, route, params)
+}
+
+func queryParam(id string, chans blocks.BlockChans, route string, params url.Values) (interface{}, error) {
+	var returnToSender blocks.MsgChan
+	returnToSender = make(chan interface{}, 1)
+	timeout := time.NewTimer(1 * time.Second)
+	select {
+	case chans.QueryParamChan <- &blocks.QueryParamMsg{
+		Route:    route,
+		RespChan: returnToSender,
+		Params:   params,
+	}:
+	case <-timeout.C:
+		return nil, errors.New(fmt.Sprintf("Cannot query block %s: timeout", id))
+	}
+	select {
+	case q := <-returnToSender:
+		return q, nil
+	case <-timeout.C:
+		return nil, errors.New(fmt.Sprintf("Cannot query block %s: timeout", id))
+	}
+}
+
+// Lookup asks a block on one of its query routes, with params if they are
+// given. It is how blocks look things up in other blocks, from their own
+// goroutines, so it doesn't hold the engine while it waits for an answer.
+func (e *Engine) Lookup(id string, route string, params url.Values) (q interface{}, err error) {
+	e.mu.Lock()
+	block, ok := e.blockMap[id]
+	e.mu.Unlock()
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot look up in block %s: does not exist", id))
+	}
+
+	// the block may be deleted while we ask it.
+	defer func() {
+		if r := recover(); r != nil {
+			q, err = nil, errors.New(fmt.Sprintf("Cannot look up in block %s: does not exist", id))
+		}
+	}()
+
+	if params == nil {
+		return query(id, block.chans, route)
+	}
+	return queryParam(id, block.chans, route, params)
+}
+
+func (e *Engine) QueryConnection(id string, route string) (interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.connMap[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot query block %s: does not exist", id))
+	}
+
+	var returnToSender blocks.MsgChan
+	returnToSender = make(chan interface{})
+	msg := &blocks.QueryMsg{
+		Route:   route,
+		MsgChan: returnToSender,
+	}
+	e.connMap[id].chans.QueryChan <- msg
+	q := <-returnToSender
+
+	return q, nil
+}
+
+// Connect wires one of a block's out routes to another block's in route.
+func (e *Engine) Connect(connInfo *ConnectionInfo) (*ConnectionInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.connect(connInfo)
+}
+
+func (e *Engine) connect(connInfo *ConnectionInfo) (*ConnectionInfo, error) {
+	if connInfo == nil {
+		return nil, errors.New("Cannot create: no connection data.")
+	}
+
+	// check to see if the ID is OK
+	if !IdSafe(connInfo.Id) {
+		return nil, errors.New(fmt.Sprintf("Cannot create block %s: invalid id", connInfo.Id))
+	}
+
+	// create ID if there is none
+	if connInfo.Id == "" {
+		connInfo.Id = e.getId()
+	}
+
+	// make sure ID doesn't already exist
+	if e.idExists(connInfo.Id) {
+		return nil, errors.New(fmt.Sprintf("Cannot create connection %s: id already exists", connInfo.Id))
+	}
+
+	// check to see if the blocks that we are attaching to exist
+	fromBlock, fromExists := e.blockMap[connInfo.FromId]
+	if !fromExists {
+		return nil, errors.New(fmt.Sprintf("Cannot create connection %s: FromId block does not exist", connInfo.Id))
+	}
+
+	// connections come from a block's default out route unless told otherwise
+	if connInfo.FromRoute == "" {
+		connInfo.FromRoute = "out"
+	}
+
+	if !hasOutRoute(fromBlock.Type, connInfo.FromRoute) {
+		return nil, errors.New(fmt.Sprintf("Cannot create connection %s: block %s has no out route %s", connInfo.Id, connInfo.FromId, connInfo.FromRoute))
+	}
+
+	toExists := e.idExists(connInfo.ToId)
+	if !toExists {
+		return nil, errors.New(fmt.Sprintf("Cannot create connection %s: ToId ID does not exist", connInfo.Id))
+	}
+
+	// create connection info for server
+	// and create connection routine
+	newConn := &blocks.Connection{
+		ToRoute: connInfo.ToRoute,
+	}
+
+	newConnChans := newChans()
+	newConnChans.IdChan = nil
+
+	newConn.SetId(connInfo.Id)
+	newConn.Build(newConnChans)
+	go blocks.ConnectionRoutine(newConn)
+
+	connInfo.chans = newConnChans
+	e.connMap[connInfo.Id] = connInfo
+
+	// ask to connect the blocks together
+	e.blockMap[connInfo.FromId].chans.AddChan <- &blocks.AddChanMsg{
+		Route:     connInfo.Id,
+		FromRoute: connInfo.FromRoute,
+		Channel:   connInfo.chans.InChan,
+	}
+
+	e.connMap[connInfo.Id].chans.AddChan <- &blocks.AddChanMsg{
+		Route:   connInfo.ToId,
+		Channel: e.blockMap[connInfo.ToId].chans.InChan,
+	}
+
+	return connInfo, nil
+}
+
+// Subscribe returns a channel that gets every message the block emits on
+// fromRoute, and the id to unsubscribe with. The block waits for each
+// message to be read, so read them until you unsubscribe.
+func (e *Engine) Subscribe(fromId string, fromRoute string) (chan *blocks.Msg, string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	block, ok := e.blockMap[fromId]
+	if !ok {
+		return nil, "", errors.New(fmt.Sprintf("Cannot recieve from block %s: does not exist", fromId))
+	}
+
+	if fromRoute == "" {
+		fromRoute = "out"
+	}
+
+	if !hasOutRoute(block.Type, fromRoute) {
+		return nil, "", errors.New(fmt.Sprintf("Cannot recieve from block %s: no out route %s", fromId, fromRoute))
+	}
+
+	subChan := make(chan *blocks.Msg)
+	id := e.getId()
+
+	e.blockMap[fromId].chans.AddChan <- &blocks.AddChanMsg{
+		Route:     id,
+		FromRoute: fromRoute,
+		Channel:   subChan,
+	}
+
+	return subChan, id, nil
+}
+
+// Unsubscribe stops a subscription. Messages the block was in the middle of
+// sending on it are thrown away.
+func (e *Engine) Unsubscribe(blockId string, subId string, subChan chan *blocks.Msg) error {
+	// the block may be stuck sending to us, possibly while someone holding
+	// the lock waits for it, so keep reading until it lets go.
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-subChan:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	block, ok := e.blockMap[blockId]
+	if !ok {
+		return nil
+	}
+
+	block.chans.DelChan <- &blocks.Msg{
+		Route: subId,
+	}
+	return nil
+}
+
+func hasOutRoute(blockType string, route string) bool {
+	def, ok := library.BlockDefs[blockType]
+	if !ok {
+		return false
+	}
+	if def.NamedOutRoutes {
+		return true
+	}
+
+	for _, r := range def.OutRoutes {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) updateRule(id string) {
+	rule := false
+	block := e.blockMap[id]
+	for _, b := range library.BlockDefs[block.Type].QueryRoutes {
+		rule = b == "rule"
+		if rule {
+			break
+		}
+	}
+
+	if rule {
+		q, err := e.queryBlock(id, "rule")
+		if err != nil {
+			return
+		}
+
+		block.Rule = q
+	}
+}
+
+func (e *Engine) updateDepth(id string) {
+	q, err := e.queryBlock(id, "depth")
+	if err != nil {
+		return
+	}
+
+	depth, ok := q.(map[string]int)
+	if !ok {
+		return
+	}
+
+	e.blockMap[id].Depth = depth
+}
+
+// BlockStats returns the counters the block routine keeps for a block.
+func (e *Engine) BlockStats(id string) (blocks.Stats, error) {
+	q, err := e.QueryBlock(id, "stats")
+	if err != nil {
+		return blocks.Stats{}, err
+	}
+
+	stats, ok := q.(blocks.Stats)
+	if !ok {
+		return blocks.Stats{}, errors.New(fmt.Sprintf("Cannot get stats for block %s", id))
+	}
+
+	return stats, nil
+}
+
+func (e *Engine) GetBlock(id string) (*BlockInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.getBlock(id)
+}
+
+func (e *Engine) getBlock(id string) (*BlockInfo, error) {
+	block, ok := e.blockMap[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot get block %s: does not exist", id))
+	}
+
+	e.updateRule(id)
+	e.updateDepth(id)
+
+	return block, nil
+}
+
+func (e *Engine) GetConnection(id string) (*ConnectionInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.connMap[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot get connection %s: does not exist", id))
+	}
+	return e.connMap[id], nil
+}
+
+// DeleteBlock stops a block and deletes its connections and any state it has
+// saved. It returns the ids of everything it deleted.
+func (e *Engine) DeleteBlock(id string) ([]string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids, err := e.deleteBlock(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// blocks that are only stopped, say by Clear, keep their state for
+	// the next block with their id.
+	blocks.RemoveState(id)
+	return ids, nil
+}
+
+func (e *Engine) deleteBlock(id string) ([]string, error) {
+	var delIds []string
+
+	_, ok := e.blockMap[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot delete block %s: does not exist", id))
+	}
+
+	// delete connections that reference this block
+	for _, c := range e.connMap {
+		if c.FromId == id {
+			delFromId, err := e.deleteConnection(c.Id)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Cannot delete block %s: FromId %s does not exist", id, c.FromId))
+			}
+			delIds = append(delIds, delFromId)
+		}
+		if c.ToId == id {
+			delToId, err := e.deleteConnection(c.Id)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Cannot delete block %s: ToId %s does not exist", id, c.ToId))
+			}
+			delIds = append(delIds, delToId)
+		}
+	}
+
+	// turn off block here
+	// close channels, whatever.
+	e.blockMap[id].chans.QuitChan <- true
+
+	delete(e.blockMap, id)
+	delIds = append(delIds, id)
+
+	return delIds, nil
+}
+
+func (e *Engine) DeleteConnection(id string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.deleteConnection(id)
+}
+
+func (e *Engine) deleteConnection(id string) (string, error) {
+	_, ok := e.connMap[id]
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Cannot delete connection %s: does not exist", id))
+	}
+
+	e.blockMap[e.connMap[id].FromId].chans.DelChan <- &blocks.Msg{
+		Route: id,
+	}
+
+	e.connMap[id].chans.QuitChan <- true
+
+	// call disconnecting stuff here
+	// remove channel from FromBlock, etc
+	// turn off connection block
+	delete(e.connMap, id)
+
+	return id, nil
+}
+
+// Clear deletes every connection and then every block, and returns their ids.
+func (e *Engine) Clear() ([]string, []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.clear()
+}
+
+func (e *Engine) clear() ([]string, []string) {
+	var connIds, blockIds []string
+
+	for id := range e.connMap {
+		if _, err := e.deleteConnection(id); err == nil {
+			connIds = append(connIds, id)
+		}
+	}
+
+	for id := range e.blockMap {
+		if ids, err := e.deleteBlock(id); err == nil {
+			blockIds = append(blockIds, ids...)
+		}
+	}
+
+	return connIds, blockIds
+}
+
+// StatusBlocks pings every block and returns their answers by block id.
+func (e *Engine) StatusBlocks() map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	responses := make(map[string]string)
+	for k, _ := range e.blockMap {
+		wg.Add(1)
+		go func(id string, queryChan chan *blocks.QueryMsg) {
+			defer wg.Done()
+			timeout := time.NewTimer(time.Second * 5)
+			var returnToSender blocks.MsgChan
+			returnToSender = make(chan interface{})
+			queryChan <- &blocks.QueryMsg{
+				Route:   "ping",
+				MsgChan: returnToSender,
+			}
+			status := "TIMEOUT"
+			select {
+			case q := <-returnToSender:
+				status = q.(string)
+			case <-timeout.C:
+			}
+			mu.Lock()
+			responses[id] = status
+			mu.Unlock()
+		}(k, e.blockMap[k].chans.QueryChan)
+	}
+	wg.Wait()
+	return responses
+}
+
+// UpdateBlockId renames a block. It returns the block and the connections
+// that were rewired to the new id.
+func (e *Engine) UpdateBlockId(fromId string, toId string) (*BlockInfo, []*ConnectionInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.blockMap[fromId]
+	if !ok {
+		return nil, nil, errors.New("from block Id does not exist")
+	}
+
+	if !IdSafe(toId) {
+		return nil, nil, errors.New(fmt.Sprintf("Cannot create block %s: invalid id", toId))
+	}
+
+	// make sure ID doesn't already exist
+	if e.idExists(toId) {
+		return nil, nil, errors.New(fmt.Sprintf("Cannot create block %s: id already exists", toId))
+	}
+
+	select {
+	case e.blockMap[fromId].chans.IdChan <- toId:
+	default:
+		return nil, nil, errors.New(fmt.Sprintf("Could not set Id for block %s: timeout", fromId))
+	}
+
+	e.blockMap[toId] = e.blockMap[fromId]
+	e.blockMap[toId].Id = toId
+
+	delete(e.blockMap, fromId)
+
+	var updatedConns []*ConnectionInfo
+
+	for _, c := range e.connMap {
+		if c.FromId == fromId || c.ToId == fromId {
+			updatedConns = append(updatedConns, c)
+			if c.FromId == fromId {
+				c.FromId = toId
+			}
+			if c.ToId == fromId {
+				c.ToId = toId
+			}
+		}
+	}
+
+	return e.blockMap[toId], updatedConns, nil
+}
+
+func (e *Engine) ListBlocks() []*BlockInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.listBlocks()
+}
+
+func (e *Engine) listBlocks() []*BlockInfo {
+	i := 0
+	blocks := make([]*BlockInfo, len(e.blockMap), len(e.blockMap))
+	for k, _ := range e.blockMap {
+		v, err := e.getBlock(k)
+		if err != nil {
+			continue
+		}
+		blocks[i] = v
+		i++
+	}
+
+	return blocks
+}
+
+func (e *Engine) ListConnections() []*ConnectionInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.listConnections()
+}
+
+func (e *Engine) listConnections() []*ConnectionInfo {
+	i := 0
+	conns := make([]*ConnectionInfo, len(e.connMap), len(e.connMap))
+	for _, v := range e.connMap {
+		conns[i] = v
+		i++
+	}
+	return conns
+}
@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/library"
+)
+
+// Pattern is a set of blocks and the connections between them, as exported
+// by /export and accepted by /import. It carries along the composite block
+// types in the library, so that it can be imported elsewhere.
+type Pattern struct {
+	Blocks      []*BlockInfo
+	Connections []*ConnectionInfo
+	Composites  []*library.CompositeDef `json:",omitempty"`
+}
+
+func hasRoute(routes []string, route string) bool {
+	for _, r := range routes {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePattern lists everything that would stop the pattern from being
+// imported, including the rules that CheckRule turns down.
+func validatePattern(p *Pattern) []string {
+	problems := []string{}
+	types := make(map[string]string)
+
+	for i, block := range p.Blocks {
+		if block == nil {
+			problems = append(problems, fmt.Sprintf("block %d: no block data", i))
+			continue
+		}
+
+		name := block.Id
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+
+		if block.Id != "" {
+			if !IdSafe(block.Id) {
+				problems = append(problems, fmt.Sprintf("block %s: invalid id", name))
+			}
+			if _, ok := types[block.Id]; ok {
+				problems = append(problems, fmt.Sprintf("block %s: id is used more than once", name))
+			}
+			types[block.Id] = block.Type
+		}
+
+		def, ok := library.BlockDefs[block.Type]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("block %s: unknown block type %s", name, block.Type))
+			continue
+		}
+
+		if block.Overflow != "" && !blocks.ValidOverflow(block.Overflow) {
+			problems = append(problems, fmt.Sprintf("block %s: invalid overflow policy %s", name, block.Overflow))
+		}
+
+		if block.Rule != nil && hasRoute(def.InRoutes, "rule") {
+			if err := CheckRule(block.Type, block.Rule); err != nil {
+				problems = append(problems, fmt.Sprintf("block %s: bad rule: %s", name, err.Error()))
+			}
+		}
+	}
+
+	for i, conn := range p.Connections {
+		if conn == nil {
+			problems = append(problems, fmt.Sprintf("connection %d: no connection data", i))
+			continue
+		}
+
+		name := conn.Id
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+
+		fromType, fromOk := types[conn.FromId]
+		if !fromOk {
+			problems = append(problems, fmt.Sprintf("connection %s: FromId %s is not a block in the pattern", name, conn.FromId))
+		}
+
+		toType, toOk := types[conn.ToId]
+		if !toOk {
+			problems = append(problems, fmt.Sprintf("connection %s: ToId %s is not a block in the pattern", name, conn.ToId))
+		}
+
+		fromRoute := conn.FromRoute
+		if fromRoute == "" {
+			fromRoute = "out"
+		}
+
+		if def, ok := library.BlockDefs[fromType]; fromOk && ok && !def.NamedOutRoutes && !hasRoute(def.OutRoutes, fromRoute) {
+			problems = append(problems, fmt.Sprintf("connection %s: block %s has no outbound route %s", name, conn.FromId, fromRoute))
+		}
+
+		if def, ok := library.BlockDefs[toType]; toOk && ok && !hasRoute(def.InRoutes, conn.ToRoute) {
+			problems = append(problems, fmt.Sprintf("connection %s: block %s has no inbound route %s", name, conn.ToId, conn.ToRoute))
+		}
+	}
+
+	return problems
+}
+
+// Check lists everything wrong with the pattern without touching the running
+// pattern.
+func (e *Engine) Check(p *Pattern) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// the pattern's composites are only needed while we look at it
+	added, err := registerComposites(p.Composites)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer unregisterComposites(added)
+
+	return validatePattern(p)
+}
+
+// Import creates the pattern alongside whatever is already running, renaming
+// blocks and connections whose ids are taken. Either all of it is created or,
+// if anything goes wrong, none of it is. It returns what it created.
+func (e *Engine) Import(p *Pattern) ([]*BlockInfo, []*ConnectionInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.importPattern(p)
+}
+
+func (e *Engine) importPattern(export *Pattern) ([]*BlockInfo, []*ConnectionInfo, error) {
+	corrected := make(map[string]string)
+
+	added, err := registerComposites(export.Composites)
+	if err != nil {
+		return nil, nil, errors.New("Cannot import pattern: " + err.Error())
+	}
+
+	if problems := validatePattern(export); len(problems) > 0 {
+		unregisterComposites(added)
+		return nil, nil, errors.New("Cannot import pattern: " + strings.Join(problems, "; "))
+	}
+
+	for _, block := range export.Blocks {
+		corrected[block.Id] = block.Id
+		for e.idExists(corrected[block.Id]) {
+			corrected[block.Id] = block.Id + "_" + e.getId()
+		}
+	}
+
+	for _, conn := range export.Connections {
+		corrected[conn.Id] = conn.Id
+		for e.idExists(corrected[conn.Id]) {
+			corrected[conn.Id] = conn.Id + "_" + e.getId()
+		}
+	}
+
+	var createdBlocks []*BlockInfo
+	var createdConns []*ConnectionInfo
+
+	// tear down whatever we managed to create before failing
+	rollback := func(err error) error {
+		for _, conn := range createdConns {
+			e.deleteConnection(conn.Id)
+		}
+		for _, block := range createdBlocks {
+			e.deleteBlock(block.Id)
+		}
+		unregisterComposites(added)
+		return errors.New("Cannot import pattern: " + err.Error())
+	}
+
+	for _, block := range export.Blocks {
+		block.Id = corrected[block.Id]
+		eblock, err := e.create(block)
+		if err != nil {
+			return nil, nil, rollback(err)
+		}
+		createdBlocks = append(createdBlocks, eblock)
+	}
+
+	for _, conn := range export.Connections {
+		conn.Id = corrected[conn.Id]
+		conn.FromId = corrected[conn.FromId]
+		conn.ToId = corrected[conn.ToId]
+		econn, err := e.connect(conn)
+		if err != nil {
+			return nil, nil, rollback(err)
+		}
+		createdConns = append(createdConns, econn)
+	}
+
+	return createdBlocks, createdConns, nil
+}
+
+// Replace deletes everything that is running and imports the pattern in its
+// place. It returns the ids of the connections and blocks it deleted, and
+// what it created.
+func (e *Engine) Replace(p *Pattern) ([]string, []string, []*BlockInfo, []*ConnectionInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	connIds, blockIds := e.clear()
+	createdBlocks, createdConns, err := e.importPattern(p)
+	return connIds, blockIds, createdBlocks, createdConns, err
+}
+
+// Export describes the running pattern.
+func (e *Engine) Export() *Pattern {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return &Pattern{
+		Blocks:      e.listBlocks(),
+		Connections: e.listConnections(),
+		Composites:  compositeList(),
+	}
+}
+
+// Library returns the definitions of the block types in the library.
+func (e *Engine) Library() map[string]*blocks.BlockDef {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	defs := make(map[string]*blocks.BlockDef)
+	for k, v := range library.BlockDefs {
+		defs[k] = v
+	}
+	return defs
+}
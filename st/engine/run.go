@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/library"
+)
+
+// SettleTime is how long the pattern has to go without a message moving
+// before Run takes it to have drained.
+var SettleTime = 500 * time.Millisecond
+
+// pollBatch is how many polls a finite source is sent at a time.
+const pollBatch = 100
+
+// Run runs the pattern to completion, for patterns that only read from finite
+// sources like files. It polls the finite sources until they are exhausted,
+// waits for everything they emitted to drain through the pattern, then
+// flushes blocks that buffer messages, upstream blocks first. It returns the
+// number of errors the blocks reported.
+//
+// Blocks that emit on their own, like tickers, keep the pattern from ever
+// draining, so Run won't return for patterns that have them.
+func (e *Engine) Run() (int64, error) {
+	sources, flushes, ids := e.runOrder()
+
+	for {
+		exhausted := true
+		for _, id := range sources {
+			stats, err := e.BlockStats(id)
+			if err != nil {
+				return 0, err
+			}
+			if stats.Exhausted {
+				continue
+			}
+			exhausted = false
+
+			// only poll for more once the block has got through the last
+			// lot, so that we never poll faster than the pattern can cope.
+			depth, err := e.blockDepth(id)
+			if err != nil {
+				return 0, err
+			}
+			if depth["poll"] > 0 {
+				continue
+			}
+			for i := 0; i < pollBatch; i++ {
+				if err := e.Send(id, "poll", map[string]interface{}{}); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		if exhausted {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := e.drain(ids); err != nil {
+		return 0, err
+	}
+
+	for _, id := range flushes {
+		if err := e.Send(id, "flush", map[string]interface{}{}); err != nil {
+			return 0, err
+		}
+		if err := e.drain(ids); err != nil {
+			return 0, err
+		}
+	}
+
+	var errs int64
+	for _, id := range ids {
+		stats, err := e.BlockStats(id)
+		if err != nil {
+			return 0, err
+		}
+		errs += stats.Errors
+	}
+
+	return errs, nil
+}
+
+// runOrder returns the finite sources in the pattern, the blocks that can be
+// flushed, and all of its blocks. The blocks are ordered so that, apart from
+// those in loops, every block comes after the blocks that send to it.
+func (e *Engine) runOrder() ([]string, []string, []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var ids []string
+	for id := range e.blockMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	incoming := make(map[string]int)
+	downstream := make(map[string][]string)
+	for _, conn := range e.connMap {
+		incoming[conn.ToId]++
+		downstream[conn.FromId] = append(downstream[conn.FromId], conn.ToId)
+	}
+
+	var ordered []string
+	placed := make(map[string]bool)
+	var next []string
+	for _, id := range ids {
+		if incoming[id] == 0 {
+			next = append(next, id)
+		}
+	}
+
+	for len(next) > 0 {
+		id := next[0]
+		next = next[1:]
+		ordered = append(ordered, id)
+		placed[id] = true
+
+		to := downstream[id]
+		sort.Strings(to)
+		for _, t := range to {
+			incoming[t]--
+			if incoming[t] == 0 {
+				next = append(next, t)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if !placed[id] {
+			ordered = append(ordered, id)
+		}
+	}
+
+	var sources, flushes []string
+	for _, id := range ordered {
+		def, ok := library.BlockDefs[e.blockMap[id].Type]
+		if !ok {
+			continue
+		}
+		if def.Finite {
+			sources = append(sources, id)
+		}
+		if hasRoute(def.InRoutes, "flush") {
+			flushes = append(flushes, id)
+		}
+	}
+
+	return sources, flushes, ordered
+}
+
+// Drain waits until no messages are waiting on the blocks' in routes and none
+// have been received or emitted for SettleTime.
+func (e *Engine) Drain() error {
+	_, _, ids := e.runOrder()
+	return e.drain(ids)
+}
+
+// drain is Drain for the given blocks.
+func (e *Engine) drain(ids []string) error {
+	var last int64 = -1
+	quiet := time.Now()
+
+	for {
+		var moved int64
+		var queued int
+
+		for _, id := range ids {
+			stats, err := e.BlockStats(id)
+			if err != nil {
+				return err
+			}
+			for _, n := range stats.Received {
+				moved += n
+			}
+			for _, n := range stats.Emitted {
+				moved += n
+			}
+
+			depth, err := e.blockDepth(id)
+			if err != nil {
+				return err
+			}
+			for _, n := range depth {
+				queued += n
+			}
+		}
+
+		if queued > 0 || moved != last {
+			last = moved
+			quiet = time.Now()
+		} else if time.Since(quiet) >= SettleTime {
+			return nil
+		}
+
+		time.Sleep(SettleTime / 10)
+	}
+}
+
+// blockDepth returns how many messages are waiting on each of a block's in
+// routes.
+func (e *Engine) blockDepth(id string) (map[string]int, error) {
+	q, err := e.QueryBlock(id, "depth")
+	if err != nil {
+		return nil, err
+	}
+
+	depth, ok := q.(map[string]int)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot get depth for block %s", id))
+	}
+
+	return depth, nil
+}
@@ -0,0 +1,701 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/nytlabs/gojee"
+	"github.com/nytlabs/streamtools/st/blocks" // blocks
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// the aggregations an aggregate block can work out, and whether they need
+// numbers.
+var aggregateOps = map[string]bool{
+	"count":      false,
+	"sum":        true,
+	"min":        true,
+	"max":        true,
+	"mean":       true,
+	"stddev":     true,
+	"first":      false,
+	"last":       false,
+	"distinct":   false,
+	"percentile": true,
+}
+
+// aggregation is one of the values worked out for each group in a window.
+type aggregation struct {
+	name       string
+	op         string
+	path       string
+	tree       *jee.TokenTree
+	percentile float64
+}
+
+// accumulator gathers what an aggregation needs from the messages in a
+// window.
+type accumulator struct {
+	count         int
+	sum, sumSq    float64
+	min, max      float64
+	first, last   interface{}
+	firstT, lastT time.Time
+	distinct      map[string]bool
+	values        []float64
+}
+
+// aggWindow is a window of one group's messages.
+type aggWindow struct {
+	group      interface{}
+	key        string
+	start, end time.Time
+	accs       []*accumulator
+}
+
+// aggregator keeps the open windows of an aggregate block.
+type aggregator struct {
+	kind    string
+	size    time.Duration
+	slide   time.Duration
+	aggs    []*aggregation
+	windows map[string][]*aggWindow
+}
+
+// parseAggregations reads the Aggregates rule field, a list of objects each
+// with an Op, and a Path and Name where they are needed.
+func parseAggregations(rule interface{}) ([]*aggregation, error) {
+	specs, err := util.ParseArray(rule, "Aggregates")
+	if err != nil {
+		return nil, err
+	}
+
+	var aggs []*aggregation
+	names := map[string]bool{}
+	for i, specI := range specs {
+		spec, ok := specI.(map[string]interface{})
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("aggregate %d is not an object", i))
+		}
+
+		a := &aggregation{}
+		a.op, err = util.ParseRequiredString(spec, "Op")
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := aggregateOps[a.op]; !ok {
+			return nil, errors.New(fmt.Sprintf("unknown aggregate %s", a.op))
+		}
+
+		if util.KeyExists(spec, "Path") {
+			a.path, err = util.ParseString(spec, "Path")
+			if err != nil {
+				return nil, err
+			}
+		}
+		if a.path != "" {
+			a.tree, err = util.BuildTokenTree(a.path)
+			if err != nil {
+				return nil, err
+			}
+		} else if a.op != "count" {
+			return nil, errors.New(fmt.Sprintf("%s needs a Path", a.op))
+		}
+
+		if a.op == "percentile" {
+			a.percentile, err = util.ParseFloat(spec, "Percentile")
+			if err != nil {
+				return nil, err
+			}
+			if a.percentile < 0 || a.percentile > 100 {
+				return nil, errors.New("Percentile must be between 0 and 100")
+			}
+		}
+
+		a.name = a.op
+		if util.KeyExists(spec, "Name") {
+			a.name, err = util.ParseRequiredString(spec, "Name")
+			if err != nil {
+				return nil, err
+			}
+		}
+		if names[a.name] {
+			return nil, errors.New(fmt.Sprintf("more than one aggregate is called %s", a.name))
+		}
+		names[a.name] = true
+
+		aggs = append(aggs, a)
+	}
+
+	return aggs, nil
+}
+
+// rule is how the aggregation appears in the answer to a rule query.
+func (a *aggregation) rule() map[string]interface{} {
+	r := map[string]interface{}{
+		"Name": a.name,
+		"Op":   a.op,
+		"Path": a.path,
+	}
+	if a.op == "percentile" {
+		r["Percentile"] = a.percentile
+	}
+	return r
+}
+
+// add takes a value from a message that happened at t.
+func (acc *accumulator) add(a *aggregation, v interface{}, t time.Time) {
+	if acc.count == 0 || t.Before(acc.firstT) {
+		acc.first, acc.firstT = v, t
+	}
+	if acc.count == 0 || !t.Before(acc.lastT) {
+		acc.last, acc.lastT = v, t
+	}
+
+	if f, ok := v.(float64); ok {
+		if acc.count == 0 || f < acc.min {
+			acc.min = f
+		}
+		if acc.count == 0 || f > acc.max {
+			acc.max = f
+		}
+		acc.sum += f
+		acc.sumSq += f * f
+		if a.op == "percentile" {
+			acc.values = append(acc.values, f)
+		}
+	}
+
+	if a.op == "distinct" {
+		if acc.distinct == nil {
+			acc.distinct = map[string]bool{}
+		}
+		acc.distinct[groupKey(v)] = true
+	}
+
+	acc.count++
+}
+
+// merge takes everything another accumulator has gathered, for when two
+// sessions turn out to be one.
+func (acc *accumulator) merge(other *accumulator) {
+	if other.count == 0 {
+		return
+	}
+	if acc.count == 0 {
+		*acc = *other
+		return
+	}
+
+	if other.firstT.Before(acc.firstT) {
+		acc.first, acc.firstT = other.first, other.firstT
+	}
+	if !other.lastT.Before(acc.lastT) {
+		acc.last, acc.lastT = other.last, other.lastT
+	}
+	acc.min = math.Min(acc.min, other.min)
+	acc.max = math.Max(acc.max, other.max)
+	acc.sum += other.sum
+	acc.sumSq += other.sumSq
+	acc.values = append(acc.values, other.values...)
+	for k := range other.distinct {
+		if acc.distinct == nil {
+			acc.distinct = map[string]bool{}
+		}
+		acc.distinct[k] = true
+	}
+	acc.count += other.count
+}
+
+// value works out the aggregation. Aggregations of no values are null,
+// except for counts, which are zero.
+func (acc *accumulator) value(a *aggregation) interface{} {
+	n := float64(acc.count)
+	switch a.op {
+	case "count":
+		return n
+	case "distinct":
+		return float64(len(acc.distinct))
+	}
+
+	if acc.count == 0 {
+		return nil
+	}
+
+	switch a.op {
+	case "sum":
+		return acc.sum
+	case "min":
+		return acc.min
+	case "max":
+		return acc.max
+	case "mean":
+		return acc.sum / n
+	case "stddev":
+		mean := acc.sum / n
+		return math.Sqrt(math.Max(0, acc.sumSq/n-mean*mean))
+	case "first":
+		return acc.first
+	case "last":
+		return acc.last
+	case "percentile":
+		values := make([]float64, len(acc.values))
+		copy(values, acc.values)
+		sort.Float64s(values)
+
+		rank := a.percentile / 100 * float64(len(values)-1)
+		lower := int(math.Floor(rank))
+		upper := int(math.Ceil(rank))
+		return values[lower] + (rank-float64(lower))*(values[upper]-values[lower])
+	}
+
+	return nil
+}
+
+// groupKey turns a value into a string that is the same for equal values.
+func groupKey(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func newAggregator(kind string, size, slide time.Duration, aggs []*aggregation) *aggregator {
+	return &aggregator{
+		kind:    kind,
+		size:    size,
+		slide:   slide,
+		aggs:    aggs,
+		windows: map[string][]*aggWindow{},
+	}
+}
+
+func (g *aggregator) newWindow(group interface{}, key string, start, end time.Time) *aggWindow {
+	w := &aggWindow{
+		group: group,
+		key:   key,
+		start: start,
+		end:   end,
+		accs:  make([]*accumulator, len(g.aggs)),
+	}
+	for i := range w.accs {
+		w.accs[i] = &accumulator{}
+	}
+	g.windows[key] = append(g.windows[key], w)
+	return w
+}
+
+// window finds the group's window that starts at start, making it if there
+// isn't one.
+func (g *aggregator) window(group interface{}, key string, start time.Time) *aggWindow {
+	for _, w := range g.windows[key] {
+		if w.start.Equal(start) {
+			return w
+		}
+	}
+	return g.newWindow(group, key, start, start.Add(g.size))
+}
+
+// session finds the group's session that a message at t belongs to, making
+// one if there isn't one, and merging sessions that t joins together.
+func (g *aggregator) session(group interface{}, key string, t time.Time) *aggWindow {
+	var joined *aggWindow
+	var kept []*aggWindow
+	for _, w := range g.windows[key] {
+		if t.Before(w.start.Add(-g.size)) || !t.Before(w.end) {
+			kept = append(kept, w)
+			continue
+		}
+		if joined == nil {
+			joined = w
+			kept = append(kept, w)
+			continue
+		}
+		for i, acc := range joined.accs {
+			acc.merge(w.accs[i])
+		}
+		if w.start.Before(joined.start) {
+			joined.start = w.start
+		}
+		if w.end.After(joined.end) {
+			joined.end = w.end
+		}
+	}
+	g.windows[key] = kept
+
+	if joined == nil {
+		return g.newWindow(group, key, t, t.Add(g.size))
+	}
+	if t.Before(joined.start) {
+		joined.start = t
+	}
+	if end := t.Add(g.size); end.After(joined.end) {
+		joined.end = end
+	}
+	return joined
+}
+
+// add puts the values read from a message that happened at t into each of
+// the group's windows that the message falls in.
+func (g *aggregator) add(group interface{}, t time.Time, values []interface{}) {
+	key := groupKey(group)
+
+	var windows []*aggWindow
+	switch g.kind {
+	case "tumbling":
+		windows = append(windows, g.window(group, key, t.Truncate(g.size)))
+	case "sliding":
+		for start := t.Truncate(g.slide); start.Add(g.size).After(t); start = start.Add(-g.slide) {
+			windows = append(windows, g.window(group, key, start))
+		}
+	case "session":
+		windows = append(windows, g.session(group, key, t))
+	}
+
+	for _, w := range windows {
+		for i, a := range g.aggs {
+			if values[i] != nil {
+				w.accs[i].add(a, values[i], t)
+			}
+		}
+	}
+}
+
+// close takes out the windows that have ended by now, or every window if all
+// is set, in the order they end.
+func (g *aggregator) close(now time.Time, all bool) []*aggWindow {
+	var closed []*aggWindow
+	for key, windows := range g.windows {
+		var open []*aggWindow
+		for _, w := range windows {
+			if all || !w.end.After(now) {
+				closed = append(closed, w)
+			} else {
+				open = append(open, w)
+			}
+		}
+		if len(open) == 0 {
+			delete(g.windows, key)
+		} else {
+			g.windows[key] = open
+		}
+	}
+	sort.Sort(byEnd(closed))
+	return closed
+}
+
+// open lists the windows that haven't ended yet.
+func (g *aggregator) open() []*aggWindow {
+	var open []*aggWindow
+	for _, windows := range g.windows {
+		open = append(open, windows...)
+	}
+	sort.Sort(byEnd(open))
+	return open
+}
+
+// next is when the next window ends.
+func (g *aggregator) next() (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, windows := range g.windows {
+		for _, w := range windows {
+			if !found || w.end.Before(next) {
+				next = w.end
+				found = true
+			}
+		}
+	}
+	return next, found
+}
+
+// result is the message for a window.
+func (g *aggregator) result(w *aggWindow) map[string]interface{} {
+	values := map[string]interface{}{}
+	for i, a := range g.aggs {
+		values[a.name] = w.accs[i].value(a)
+	}
+	return map[string]interface{}{
+		"Group":  w.group,
+		"Start":  w.start.Format(time.RFC3339Nano),
+		"End":    w.end.Format(time.RFC3339Nano),
+		"Values": values,
+	}
+}
+
+// byEnd sorts windows by when they end, then by when they start, then by
+// group, so that they are emitted in the same order every time.
+type byEnd []*aggWindow
+
+func (w byEnd) Len() int      { return len(w) }
+func (w byEnd) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
+func (w byEnd) Less(i, j int) bool {
+	if !w[i].end.Equal(w[j].end) {
+		return w[i].end.Before(w[j].end)
+	}
+	if !w[i].start.Equal(w[j].start) {
+		return w[i].start.Before(w[j].start)
+	}
+	return w[i].key < w[j].key
+}
+
+// specify those channels we're going to use to communicate with streamtools
+type Aggregate struct {
+	blocks.Block
+	queryrule    chan blocks.MsgChan
+	queryresults chan blocks.MsgChan
+	inrule       blocks.MsgChan
+	clear        blocks.MsgChan
+	flush        blocks.MsgChan
+	in           blocks.MsgChan
+	out          blocks.MsgChan
+	late         blocks.MsgChan
+	quit         blocks.MsgChan
+}
+
+// we need to build a simple factory so that streamtools can make new blocks of this kind
+func NewAggregate() blocks.BlockInterface {
+	return &Aggregate{}
+}
+
+// Setup is called once before running the block. We build up the channels and specify what kind of block this is.
+func (b *Aggregate) Setup() {
+	b.Kind = "Stats"
+	b.Desc = "groups messages by a path and works out aggregations of each group over tumbling, sliding or session windows"
+	b.in = b.InRoute("in")
+	b.inrule = b.InRoute("rule")
+	b.queryrule = b.QueryRoute("rule")
+	b.queryresults = b.QueryRoute("results")
+	b.clear = b.InRoute("clear")
+	b.flush = b.InRoute("flush")
+	b.quit = b.Quit()
+	b.out = b.Broadcast()
+	b.late = b.OutRoute("late")
+}
+
+// Run is the block's main loop. Here we listen on the different channels we set up.
+func (b *Aggregate) Run() {
+	clock := b.Clock()
+	waitTimer := clock.NewTimer(100 * time.Millisecond)
+
+	kind := "tumbling"
+	size := time.Duration(1) * time.Minute
+	slide := time.Duration(0)
+	var groupBy string
+	var groupTree *jee.TokenTree
+	var events *eventTime
+	var g *aggregator
+
+	for {
+		select {
+		case ruleI := <-b.inrule:
+			tmpKind := "tumbling"
+			if util.KeyExists(ruleI, "WindowType") {
+				k, err := util.ParseString(ruleI, "WindowType")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if k != "" {
+					tmpKind = k
+				}
+			}
+			if tmpKind != "tumbling" && tmpKind != "sliding" && tmpKind != "session" {
+				b.Error(errors.New("WindowType must be tumbling, sliding or session"))
+				break
+			}
+
+			windowString, err := util.ParseString(ruleI, "Window")
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			tmpSize, err := time.ParseDuration(windowString)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			if tmpSize <= 0 {
+				b.Error(errors.New("Window must be positive"))
+				break
+			}
+
+			tmpSlide := time.Duration(0)
+			if tmpKind == "sliding" {
+				tmpSlide = tmpSize
+				if util.KeyExists(ruleI, "Slide") {
+					slideString, err := util.ParseString(ruleI, "Slide")
+					if err != nil {
+						b.Error(err)
+						break
+					}
+					if slideString != "" {
+						tmpSlide, err = time.ParseDuration(slideString)
+						if err != nil {
+							b.Error(err)
+							break
+						}
+					}
+				}
+				if tmpSlide <= 0 || tmpSlide > tmpSize {
+					b.Error(errors.New("Slide must be positive and no longer than the Window"))
+					break
+				}
+			}
+
+			var tmpGroupBy string
+			var tmpGroupTree *jee.TokenTree
+			if util.KeyExists(ruleI, "GroupBy") {
+				tmpGroupBy, err = util.ParseString(ruleI, "GroupBy")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpGroupBy != "" {
+				tmpGroupTree, err = util.BuildTokenTree(tmpGroupBy)
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+
+			aggs, err := parseAggregations(ruleI)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+
+			tmpEvents, err := parseEventTime(ruleI)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+
+			kind = tmpKind
+			size = tmpSize
+			slide = tmpSlide
+			groupBy = tmpGroupBy
+			groupTree = tmpGroupTree
+			events = tmpEvents
+			g = newAggregator(kind, size, slide, aggs)
+		case <-b.quit:
+			// quit the block
+			return
+		case msg := <-b.in:
+			if g == nil {
+				break
+			}
+
+			var group interface{}
+			if groupTree != nil {
+				var err error
+				group, err = jee.Eval(groupTree, msg)
+				if err != nil {
+					b.Error(err, msg)
+					break
+				}
+			}
+
+			values := make([]interface{}, len(g.aggs))
+			ok := true
+			for i, a := range g.aggs {
+				if a.tree == nil {
+					values[i] = true
+					continue
+				}
+				v, err := jee.Eval(a.tree, msg)
+				if err != nil {
+					b.Error(err, msg)
+					ok = false
+					break
+				}
+				if _, isNumber := v.(float64); v != nil && aggregateOps[a.op] && !isNumber {
+					b.Error(errors.New(fmt.Sprintf("%s is not a number", a.path)), msg)
+					ok = false
+					break
+				}
+				values[i] = v
+			}
+			if !ok {
+				break
+			}
+
+			t := clock.Now()
+			if events != nil {
+				var err error
+				t, err = events.time(msg)
+				if err != nil {
+					b.Error(err, msg)
+					break
+				}
+				if events.observe(t) {
+					b.late <- msg
+					break
+				}
+			}
+
+			g.add(group, t, values)
+		case <-waitTimer.C():
+		case <-b.clear:
+			if g != nil {
+				g = newAggregator(kind, size, slide, g.aggs)
+			}
+		case <-b.flush:
+			if g == nil {
+				break
+			}
+			for _, w := range g.close(time.Time{}, true) {
+				b.out <- g.result(w)
+			}
+		case c := <-b.queryrule:
+			aggs := []interface{}{}
+			if g != nil {
+				for _, a := range g.aggs {
+					aggs = append(aggs, a.rule())
+				}
+			}
+			slideString := ""
+			if slide > 0 {
+				slideString = slide.String()
+			}
+			c <- events.rule(map[string]interface{}{
+				"WindowType": kind,
+				"Window":     size.String(),
+				"Slide":      slideString,
+				"GroupBy":    groupBy,
+				"Aggregates": aggs,
+			})
+		case c := <-b.queryresults:
+			results := []interface{}{}
+			if g != nil {
+				for _, w := range g.open() {
+					results = append(results, g.result(w))
+				}
+			}
+			c <- map[string]interface{}{
+				"Results": results,
+			}
+		}
+
+		if g == nil {
+			continue
+		}
+
+		now := events.now(clock)
+		for _, w := range g.close(now, false) {
+			b.out <- g.result(w)
+		}
+
+		// going by event time, windows close as the watermark moves on rather
+		// than as the clock does.
+		if next, ok := g.next(); ok && events == nil {
+			waitTimer.Reset(next.Sub(now))
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package library
+
+import (
+	"math"
+	"time"
+)
+
+// bloomFilter remembers keys in a fixed number of bits. It can say it has seen
+// a key it hasn't, but never that it hasn't seen a key it has.
+type bloomFilter struct {
+	bits  []uint64
+	k     uint
+	count int
+	start time.Time
+}
+
+// bloomSize works out how many bits, and how many hashes of each key, a
+// filter needs to hold capacity keys and wrongly say it has seen a key at
+// most errorRate of the time.
+func bloomSize(capacity int, errorRate float64) (uint, uint) {
+	m := math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	k := math.Ceil(m / float64(capacity) * math.Ln2)
+	return uint(m), uint(math.Max(k, 1))
+}
+
+func newBloomFilter(m, k uint, start time.Time) *bloomFilter {
+	return &bloomFilter{
+		bits:  make([]uint64, (m+63)/64),
+		k:     k,
+		start: start,
+	}
+}
+
+// locations are the bits that are set for a key.
+func (f *bloomFilter) locations(key string) []uint64 {
+	x := hashValue(key)
+	h1 := x & 0xffffffff
+	h2 := x >> 32
+	m := uint64(len(f.bits) * 64)
+
+	locations := make([]uint64, f.k)
+	for i := range locations {
+		locations[i] = (h1 + uint64(i)*h2) % m
+	}
+	return locations
+}
+
+func (f *bloomFilter) has(key string) bool {
+	for _, l := range f.locations(key) {
+		if f.bits[l/64]&(1<<(l%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(key string) {
+	for _, l := range f.locations(key) {
+		f.bits[l/64] |= 1 << (l % 64)
+	}
+	f.count++
+}
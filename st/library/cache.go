@@ -22,6 +22,8 @@ type Cache struct {
 	keys        chan blocks.MsgChan
 	values      chan blocks.MsgChan
 	dump        chan blocks.MsgChan
+	snapshot    chan blocks.MsgChan
+	restore     blocks.MsgChan
 	out         blocks.MsgChan
 	quit        blocks.MsgChan
 }
@@ -40,6 +42,29 @@ func (i item) MarshalJSON() ([]byte, error) {
 	return json.Marshal(i.value)
 }
 
+// cachedItem is how an item is written to a snapshot.
+type cachedItem struct {
+	Value    interface{}
+	LastSeen time.Time
+}
+
+// restoreCache rebuilds the cache and its expiry queue from a snapshot.
+func restoreCache(items map[string]cachedItem) (map[string]item, *PriorityQueue) {
+	cache := make(map[string]item)
+	ttlQueue := &PriorityQueue{}
+	for k, i := range items {
+		cache[k] = item{
+			value:    i.Value,
+			lastSeen: i.LastSeen,
+		}
+		heap.Push(ttlQueue, &PQMessage{
+			val: k,
+			t:   i.LastSeen,
+		})
+	}
+	return cache, ttlQueue
+}
+
 // Cacheup is called once before running the block. We build up the channels and specify what kind of block this is.
 func (b *Cache) Setup() {
 	b.Kind = "Core"
@@ -55,14 +80,15 @@ func (b *Cache) Setup() {
 	b.keys = b.QueryRoute("keys")
 	b.values = b.QueryRoute("values")
 	b.dump = b.QueryRoute("dump")
+	b.snapshot = b.SnapshotRoute()
+	b.restore = b.RestoreRoute()
 }
 
-func extractAndUpdate(k string, values map[string]item, ttlQueue *PriorityQueue) (map[string]interface{}, error) {
+func extractAndUpdate(k string, values map[string]item, ttlQueue *PriorityQueue, now time.Time) (map[string]interface{}, error) {
 	i, ok := values[k]
 	var v interface{}
 	if ok {
 		v = i.value
-		now := time.Now()
 		i.lastSeen = now
 		queueMessage := &PQMessage{
 			val: k,
@@ -80,17 +106,22 @@ func extractAndUpdate(k string, values map[string]item, ttlQueue *PriorityQueue)
 
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *Cache) Run() {
+	clock := b.Clock()
 	var keyPath, valuePath, ttlString string
 	var ttl time.Duration
 	cache := make(map[string]item)
 	ttlQueue := &PriorityQueue{}
 
+	// restored state waits here until a rule sets the time to live,
+	// otherwise it would all expire at once.
+	var restored map[string]cachedItem
+
 	var keyTree, valueTree *jee.TokenTree
 	var err error
-	emitTick := time.NewTimer(500 * time.Millisecond)
+	emitTick := clock.NewTimer(500 * time.Millisecond)
 	for {
 		select {
-		case <-emitTick.C:
+		case <-emitTick.C():
 
 		case ruleI := <-b.inrule:
 			keyPath, err = util.ParseString(ruleI, "KeyPath")
@@ -115,6 +146,10 @@ func (b *Cache) Run() {
 				b.Error(err)
 				break
 			}
+			if restored != nil {
+				cache, ttlQueue = restoreCache(restored)
+				restored = nil
+			}
 		case <-b.quit:
 			return
 
@@ -124,17 +159,17 @@ func (b *Cache) Run() {
 			}
 			kI, err := jee.Eval(keyTree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			k, ok := kI.(string)
 			if !ok {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
-			out, err := extractAndUpdate(k, cache, ttlQueue)
+			out, err := extractAndUpdate(k, cache, ttlQueue, clock.Now())
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			b.out <- out
@@ -144,7 +179,7 @@ func (b *Cache) Run() {
 				b.Error(errors.New("Must specify a key to lookup"))
 			}
 			for _, ki := range k {
-				out, err := extractAndUpdate(ki, cache, ttlQueue)
+				out, err := extractAndUpdate(ki, cache, ttlQueue, clock.Now())
 				if err != nil {
 					b.Error(err)
 					continue
@@ -180,6 +215,33 @@ func (b *Cache) Run() {
 				"dump": cache,
 			}
 
+		case c := <-b.snapshot:
+			items := make(map[string]cachedItem)
+			for k, i := range cache {
+				items[k] = cachedItem{
+					Value:    i.value,
+					LastSeen: i.lastSeen,
+				}
+			}
+			state, err := json.Marshal(items)
+			if err != nil {
+				b.Error(err)
+			}
+			c <- state
+
+		case data := <-b.restore:
+			var items map[string]cachedItem
+			err := json.Unmarshal(data.([]byte), &items)
+			if err != nil {
+				b.Error(err)
+				continue
+			}
+			if ttl == 0 {
+				restored = items
+				continue
+			}
+			cache, ttlQueue = restoreCache(items)
+
 		case msg := <-b.in:
 			if keyTree == nil {
 				continue
@@ -189,20 +251,20 @@ func (b *Cache) Run() {
 			}
 			kI, err := jee.Eval(keyTree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 			k, ok := kI.(string)
 			if !ok {
-				b.Error(errors.New("key must be a string"))
+				b.Error(errors.New("key must be a string"), msg)
 				continue
 			}
 			v, err := jee.Eval(valueTree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
-			now := time.Now()
+			now := clock.Now()
 			cache[k] = item{
 				value:    v,
 				lastSeen: now,
@@ -221,7 +283,7 @@ func (b *Cache) Run() {
 				"TimeToLive": ttlString,
 			}
 		}
-		now := time.Now()
+		now := clock.Now()
 		for {
 			itemI, diff := ttlQueue.PeekAndShift(now, ttl)
 			if itemI == nil {
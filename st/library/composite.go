@@ -0,0 +1,558 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+)
+
+// InnerBlock is one of the blocks a composite is made of.
+type InnerBlock struct {
+	Id       string
+	Type     string
+	Rule     interface{}
+	Overflow string
+}
+
+// InnerConnection connects two of the blocks a composite is made of.
+type InnerConnection struct {
+	FromId    string
+	FromRoute string
+	ToId      string
+	ToRoute   string
+}
+
+// InnerRoute names a route of one of the blocks a composite is made of.
+type InnerRoute struct {
+	Block string
+	Route string
+}
+
+// InnerParam names a rule parameter of one of the blocks a composite is made
+// of.
+type InnerParam struct {
+	Block string
+	Key   string
+}
+
+// CompositeDef describes a block type that is made out of other blocks. Its
+// in and out routes are routes of the blocks inside it, and each of its rule
+// parameters is handed on to the inner blocks it lists.
+type CompositeDef struct {
+	Type        string
+	Desc        string
+	Blocks      []*InnerBlock
+	Connections []*InnerConnection
+	InRoutes    map[string]*InnerRoute
+	OutRoutes   map[string]*InnerRoute
+	Params      map[string][]*InnerParam
+}
+
+// Composites holds the definitions of the composite block types in Blocks.
+var Composites = map[string]*CompositeDef{}
+
+func hasRoute(routes []string, route string) bool {
+	for _, r := range routes {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// check lists everything wrong with the definition.
+func (d *CompositeDef) check() []string {
+	problems := []string{}
+	types := make(map[string]string)
+
+	if d.Type == "" {
+		problems = append(problems, "composite needs a type")
+	}
+
+	for i, ib := range d.Blocks {
+		if ib == nil || ib.Id == "" {
+			problems = append(problems, fmt.Sprintf("inner block %d: needs an id", i))
+			continue
+		}
+		if _, ok := types[ib.Id]; ok {
+			problems = append(problems, fmt.Sprintf("inner block %s: id is used more than once", ib.Id))
+		}
+		if _, ok := BlockDefs[ib.Type]; !ok {
+			problems = append(problems, fmt.Sprintf("inner block %s: unknown block type %s", ib.Id, ib.Type))
+		}
+		if ib.Overflow != "" && !blocks.ValidOverflow(ib.Overflow) {
+			problems = append(problems, fmt.Sprintf("inner block %s: invalid overflow policy %s", ib.Id, ib.Overflow))
+		}
+		types[ib.Id] = ib.Type
+	}
+
+	// inRoute and outRoute check that a route exists on an inner block. They
+	// say nothing about blocks of unknown type, which are reported above.
+	inRoute := func(id, route string) bool {
+		def, ok := BlockDefs[types[id]]
+		return !ok || hasRoute(def.InRoutes, route)
+	}
+	outRoute := func(id, route string) bool {
+		def, ok := BlockDefs[types[id]]
+		return !ok || def.NamedOutRoutes || hasRoute(def.OutRoutes, route)
+	}
+
+	for i, ic := range d.Connections {
+		if ic == nil {
+			problems = append(problems, fmt.Sprintf("inner connection %d: no connection data", i))
+			continue
+		}
+		fromRoute := ic.FromRoute
+		if fromRoute == "" {
+			fromRoute = "out"
+		}
+		if _, ok := types[ic.FromId]; !ok {
+			problems = append(problems, fmt.Sprintf("inner connection %d: FromId %s is not an inner block", i, ic.FromId))
+		} else if !outRoute(ic.FromId, fromRoute) {
+			problems = append(problems, fmt.Sprintf("inner connection %d: block %s has no outbound route %s", i, ic.FromId, fromRoute))
+		}
+		if _, ok := types[ic.ToId]; !ok {
+			problems = append(problems, fmt.Sprintf("inner connection %d: ToId %s is not an inner block", i, ic.ToId))
+		} else if !inRoute(ic.ToId, ic.ToRoute) {
+			problems = append(problems, fmt.Sprintf("inner connection %d: block %s has no inbound route %s", i, ic.ToId, ic.ToRoute))
+		}
+	}
+
+	for name, r := range d.InRoutes {
+		switch {
+		case name == "rule" && len(d.Params) > 0:
+			problems = append(problems, "in route rule: is taken by the composite's rule")
+		case r == nil:
+			problems = append(problems, fmt.Sprintf("in route %s: no route data", name))
+		case types[r.Block] == "":
+			problems = append(problems, fmt.Sprintf("in route %s: %s is not an inner block", name, r.Block))
+		case !inRoute(r.Block, r.Route):
+			problems = append(problems, fmt.Sprintf("in route %s: block %s has no inbound route %s", name, r.Block, r.Route))
+		}
+	}
+
+	for name, r := range d.OutRoutes {
+		switch {
+		case name == "error":
+			problems = append(problems, "out route error: is taken by the composite's error route")
+		case r == nil:
+			problems = append(problems, fmt.Sprintf("out route %s: no route data", name))
+		case types[r.Block] == "":
+			problems = append(problems, fmt.Sprintf("out route %s: %s is not an inner block", name, r.Block))
+		case !outRoute(r.Block, r.Route):
+			problems = append(problems, fmt.Sprintf("out route %s: block %s has no outbound route %s", name, r.Block, r.Route))
+		}
+	}
+
+	for name, targets := range d.Params {
+		for _, p := range targets {
+			switch {
+			case p == nil:
+				problems = append(problems, fmt.Sprintf("param %s: no param data", name))
+			case types[p.Block] == "":
+				problems = append(problems, fmt.Sprintf("param %s: %s is not an inner block", name, p.Block))
+			case !inRoute(p.Block, "rule"):
+				problems = append(problems, fmt.Sprintf("param %s: block %s has no rule", name, p.Block))
+			}
+		}
+	}
+
+	return problems
+}
+
+// uses reports whether the composite is made with blocks of type t.
+func (d *CompositeDef) uses(t string) bool {
+	for _, ib := range d.Blocks {
+		if ib.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterComposite adds a composite block type to the library. Its inner
+// blocks must be of types that are already there, which may be composites
+// themselves.
+func RegisterComposite(d *CompositeDef) error {
+	if d == nil {
+		return errors.New("Cannot register composite: no composite data")
+	}
+
+	if _, ok := Blocks[d.Type]; ok {
+		return errors.New(fmt.Sprintf("Cannot register composite %s: block type already exists", d.Type))
+	}
+
+	if problems := d.check(); len(problems) > 0 {
+		return errors.New(fmt.Sprintf("Cannot register composite %s: %s", d.Type, strings.Join(problems, "; ")))
+	}
+
+	newBlock := func() blocks.BlockInterface {
+		return newComposite(d)
+	}
+
+	Blocks[d.Type] = newBlock
+	BlockDefs[d.Type] = define(d.Type, newBlock)
+	Composites[d.Type] = d
+	return nil
+}
+
+// UnregisterComposite removes a composite block type from the library. Block
+// types that are only ever compiled in cannot be removed.
+func UnregisterComposite(t string) error {
+	if _, ok := Composites[t]; !ok {
+		return errors.New(fmt.Sprintf("Cannot remove %s: not a composite", t))
+	}
+
+	for name, d := range Composites {
+		if d.uses(t) {
+			return errors.New(fmt.Sprintf("Cannot remove %s: composite %s is made with it", t, name))
+		}
+	}
+
+	delete(Blocks, t)
+	delete(BlockDefs, t)
+	delete(Composites, t)
+	return nil
+}
+
+// link is a route added to one of a composite's inner blocks.
+type link struct {
+	block string
+	route string
+}
+
+// Composite is an instance of a composite block type. The blocks it is made
+// of run alongside it and go when it goes.
+type Composite struct {
+	blocks.Block
+	def       *CompositeDef
+	inner     map[string]blocks.BlockInterface
+	chans     map[string]blocks.BlockChans
+	links     []link
+	params    map[string]interface{}
+	ins       map[string]blocks.MsgChan
+	outs      map[string]blocks.MsgChan
+	inrule    blocks.MsgChan
+	queryrule chan blocks.MsgChan
+	quit      blocks.MsgChan
+	started   bool
+	feed      chan *blocks.Msg // messages for inner blocks
+	emit      chan *blocks.Msg // messages from inner blocks
+	stop      chan bool        // closed when the composite stops taking messages
+	gone      chan bool        // closed when the inner blocks stop talking
+	wg        sync.WaitGroup
+}
+
+func newComposite(d *CompositeDef) *Composite {
+	b := &Composite{
+		def:    d,
+		inner:  make(map[string]blocks.BlockInterface),
+		chans:  make(map[string]blocks.BlockChans),
+		params: make(map[string]interface{}),
+	}
+
+	// the inner blocks are made now, while the library can't change under us
+	for _, ib := range d.Blocks {
+		b.inner[ib.Id] = Blocks[ib.Type]()
+	}
+
+	// params start out with the values in the inner blocks' rules
+	for name, targets := range d.Params {
+		for _, p := range targets {
+			for _, ib := range d.Blocks {
+				rule, ok := ib.Rule.(map[string]interface{})
+				if ib.Id != p.Block || !ok {
+					continue
+				}
+				if v, ok := rule[p.Key]; ok {
+					b.params[name] = v
+				}
+			}
+			if _, ok := b.params[name]; ok {
+				break
+			}
+		}
+	}
+
+	return b
+}
+
+func (b *Composite) Setup() {
+	b.Kind = "Composite"
+	b.Desc = b.def.Desc
+	b.ins = make(map[string]blocks.MsgChan)
+	b.outs = make(map[string]blocks.MsgChan)
+	for name := range b.def.InRoutes {
+		b.ins[name] = b.InRoute(name)
+	}
+	for name := range b.def.OutRoutes {
+		b.outs[name] = b.OutRoute(name)
+	}
+	b.outs["error"] = b.ErrorRoute()
+	if len(b.def.Params) > 0 {
+		b.inrule = b.InRoute("rule")
+		b.queryrule = b.QueryRoute("rule")
+	}
+	b.quit = b.Quit()
+}
+
+// start sets the inner blocks running and wires them up.
+func (b *Composite) start() {
+	b.feed = make(chan *blocks.Msg)
+	b.emit = make(chan *blocks.Msg)
+	b.stop = make(chan bool)
+	b.gone = make(chan bool)
+
+	for _, ib := range b.def.Blocks {
+		chans := blocks.BlockChans{
+			InChan:         make(chan *blocks.Msg),
+			QueryChan:      make(chan *blocks.QueryMsg),
+			QueryParamChan: make(chan *blocks.QueryParamMsg),
+			AddChan:        make(chan *blocks.AddChanMsg),
+			DelChan:        make(chan *blocks.Msg),
+			ErrChan:        make(chan error),
+			IdChan:         make(chan string),
+			QuitChan:       make(chan bool),
+		}
+
+		overflow := ib.Overflow
+		if overflow == "" {
+			overflow = blocks.DROP_NEWEST
+		}
+
+		block := b.inner[ib.Id]
+		block.SetId(b.Id + "." + ib.Id)
+		block.SetOverflow(overflow)
+		block.SetClock(b.Clock())
+		block.SetLooker(b.Looker())
+		block.Build(chans)
+		go blocks.BlockRoutine(block)
+		b.chans[ib.Id] = chans
+	}
+
+	for i, ic := range b.def.Connections {
+		c := make(chan *blocks.Msg)
+		b.link(ic.FromId, fmt.Sprintf("%s.%d", b.Id, i), ic.FromRoute, c)
+		b.wg.Add(1)
+		go b.relay(c, b.chans[ic.ToId].InChan, ic.ToRoute)
+	}
+
+	for name, r := range b.def.OutRoutes {
+		c := make(chan *blocks.Msg)
+		b.link(r.Block, b.Id+"."+name, r.Route, c)
+		b.wg.Add(1)
+		go b.collect(c, name)
+	}
+
+	// the inner blocks' errors are the composite's own.
+	for _, ib := range b.def.Blocks {
+		c := make(chan *blocks.Msg)
+		b.link(ib.Id, b.Id+".error", "error", c)
+		b.wg.Add(1)
+		go b.collect(c, "error")
+	}
+
+	for name, in := range b.ins {
+		go b.take(in, name)
+	}
+
+	for _, ib := range b.def.Blocks {
+		if rule := b.ruleFor(ib.Id); rule != nil {
+			b.deliver(ib.Id, "rule", rule)
+		}
+	}
+}
+
+// link adds a route to an inner block's out route.
+func (b *Composite) link(id string, route string, fromRoute string, c chan *blocks.Msg) {
+	if fromRoute == "" {
+		fromRoute = "out"
+	}
+	b.chans[id].AddChan <- &blocks.AddChanMsg{
+		Route:     route,
+		FromRoute: fromRoute,
+		Channel:   c,
+	}
+	b.links = append(b.links, link{id, route})
+}
+
+// relay moves messages from one inner block to another.
+func (b *Composite) relay(c chan *blocks.Msg, to chan *blocks.Msg, route string) {
+	defer b.wg.Done()
+	for {
+		select {
+		case msg := <-c:
+			select {
+			case to <- &blocks.Msg{Msg: msg.Msg, Route: route}:
+			case <-b.gone:
+				return
+			}
+		case <-b.gone:
+			return
+		}
+	}
+}
+
+// collect moves messages an inner block emits to one of the composite's out
+// routes. Once the composite stops they are thrown away, so that inner blocks
+// never get stuck.
+func (b *Composite) collect(c chan *blocks.Msg, name string) {
+	defer b.wg.Done()
+	for {
+		select {
+		case msg := <-c:
+			select {
+			case b.emit <- &blocks.Msg{Msg: msg.Msg, Route: name}:
+			case <-b.stop:
+			}
+		case <-b.gone:
+			return
+		}
+	}
+}
+
+// take moves messages from one of the composite's in routes to Run.
+func (b *Composite) take(in blocks.MsgChan, name string) {
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				return
+			}
+			select {
+			case b.feed <- &blocks.Msg{Msg: msg, Route: name}:
+			case <-b.stop:
+				return
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// deliver hands a message to an inner block, emitting whatever the inner
+// blocks emit while it waits.
+func (b *Composite) deliver(id string, route string, msg interface{}) {
+	m := &blocks.Msg{
+		Msg:   msg,
+		Route: route,
+	}
+	for {
+		select {
+		case b.chans[id].InChan <- m:
+			return
+		case out := <-b.emit:
+			b.outs[out.Route] <- out.Msg
+		}
+	}
+}
+
+// ruleFor is the inner block's own rule with the composite's params filled in.
+func (b *Composite) ruleFor(id string) interface{} {
+	var rule interface{}
+	for _, ib := range b.def.Blocks {
+		if ib.Id == id {
+			rule = ib.Rule
+		}
+	}
+
+	var merged map[string]interface{}
+	for name, targets := range b.def.Params {
+		v, ok := b.params[name]
+		if !ok {
+			continue
+		}
+		for _, p := range targets {
+			if p.Block != id {
+				continue
+			}
+			if merged == nil {
+				merged = make(map[string]interface{})
+				if r, ok := rule.(map[string]interface{}); ok {
+					for k, v := range r {
+						merged[k] = v
+					}
+				}
+			}
+			merged[p.Key] = v
+		}
+	}
+
+	if merged == nil {
+		return rule
+	}
+	return merged
+}
+
+// shutdown stops the inner blocks.
+func (b *Composite) shutdown() {
+	close(b.stop)
+	for _, l := range b.links {
+		b.chans[l.block].DelChan <- &blocks.Msg{
+			Route: l.route,
+		}
+	}
+	close(b.gone)
+	b.wg.Wait()
+	for _, chans := range b.chans {
+		chans.QuitChan <- true
+	}
+}
+
+func (b *Composite) Run() {
+	if !b.started {
+		b.start()
+		b.started = true
+	}
+
+	for {
+		select {
+		case msg := <-b.feed:
+			r := b.def.InRoutes[msg.Route]
+			b.deliver(r.Block, r.Route, msg.Msg)
+		case msg := <-b.emit:
+			b.outs[msg.Route] <- msg.Msg
+		case ruleI := <-b.inrule:
+			rule, ok := ruleI.(map[string]interface{})
+			if !ok {
+				b.Error(errors.New("rule must be an object"))
+				break
+			}
+
+			var unknown []string
+			for k := range rule {
+				if _, ok := b.def.Params[k]; !ok {
+					unknown = append(unknown, k)
+				}
+			}
+			if len(unknown) > 0 {
+				b.Error(errors.New(fmt.Sprintf("unknown params %v", unknown)))
+				break
+			}
+
+			changed := make(map[string]bool)
+			for k, v := range rule {
+				b.params[k] = v
+				for _, p := range b.def.Params[k] {
+					changed[p.Block] = true
+				}
+			}
+
+			for id := range changed {
+				b.deliver(id, "rule", b.ruleFor(id))
+			}
+		case c := <-b.queryrule:
+			params := make(map[string]interface{})
+			for k, v := range b.params {
+				params[k] = v
+			}
+			c <- params
+		case <-b.quit:
+			b.shutdown()
+			return
+		}
+	}
+}
@@ -2,6 +2,7 @@ package library
 
 import (
 	"container/heap"
+	"encoding/json"
 	"time"
 
 	"github.com/nytlabs/streamtools/st/blocks" // blocks
@@ -15,8 +16,11 @@ type Count struct {
 	inrule     blocks.MsgChan
 	inpoll     blocks.MsgChan
 	clear      blocks.MsgChan
+	snapshot   chan blocks.MsgChan
+	restore    blocks.MsgChan
 	in         blocks.MsgChan
 	out        blocks.MsgChan
+	late       blocks.MsgChan
 	quit       blocks.MsgChan
 }
 
@@ -34,19 +38,28 @@ func (b *Count) Setup() {
 	b.clear = b.InRoute("clear")
 	b.queryrule = b.QueryRoute("rule")
 	b.querycount = b.QueryRoute("count")
+	b.snapshot = b.SnapshotRoute()
+	b.restore = b.RestoreRoute()
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
+	b.late = b.OutRoute("late")
 }
 
 func (b *Count) Run() {
-	waitTimer := time.NewTimer(100 * time.Millisecond)
+	clock := b.Clock()
+	waitTimer := clock.NewTimer(100 * time.Millisecond)
 	pq := &PriorityQueue{}
 	heap.Init(pq)
 	window := time.Duration(0)
+	var events *eventTime
+
+	// restored state waits here until a rule sets the window, otherwise it
+	// would all expire at once.
+	var restored *PriorityQueue
 
 	for {
 		select {
-		case <-waitTimer.C:
+		case <-waitTimer.C():
 		case rule := <-b.inrule:
 
 			tmpDurStr, err := util.ParseString(rule, "Window")
@@ -61,14 +74,39 @@ func (b *Count) Run() {
 				continue
 			}
 
+			tmpEvents, err := parseEventTime(rule)
+			if err != nil {
+				b.Error(err)
+				continue
+			}
+
 			window = tmpWindow
+			events = tmpEvents
+			if restored != nil {
+				pq = restored
+				restored = nil
+			}
 		case <-b.quit:
 			return
-		case <-b.in:
+		case msg := <-b.in:
+			t := clock.Now()
+			if events != nil {
+				var err error
+				t, err = events.time(msg)
+				if err != nil {
+					b.Error(err, msg)
+					continue
+				}
+				if events.observe(t) {
+					b.late <- msg
+					continue
+				}
+			}
+
 			empty := make([]byte, 0)
 			queueMessage := &PQMessage{
 				val: &empty,
-				t:   time.Now(),
+				t:   t,
 			}
 			heap.Push(pq, queueMessage)
 		case <-b.clear:
@@ -80,16 +118,34 @@ func (b *Count) Run() {
 				"Count": float64(len(*pq)),
 			}
 		case c := <-b.queryrule:
-			c <- map[string]interface{}{
+			c <- events.rule(map[string]interface{}{
 				"Window": window.String(),
-			}
+			})
 		case c := <-b.querycount:
 			c <- map[string]interface{}{
 				"Count": float64(len(*pq)),
 			}
+		case c := <-b.snapshot:
+			state, err := json.Marshal(snapshotPQ(pq))
+			if err != nil {
+				b.Error(err)
+			}
+			c <- state
+		case data := <-b.restore:
+			var items []pqItem
+			err := json.Unmarshal(data.([]byte), &items)
+			if err != nil {
+				b.Error(err)
+				continue
+			}
+			if window == 0 {
+				restored = restorePQ(items)
+				continue
+			}
+			pq = restorePQ(items)
 		}
 		for {
-			pqMsg, diff := pq.PeekAndShift(time.Now(), window)
+			pqMsg, diff := pq.PeekAndShift(events.now(clock), window)
 			if pqMsg == nil {
 				// either the queue is empty, or it"s not time to emit
 				if diff == 0 {
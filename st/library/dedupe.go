@@ -1,18 +1,322 @@
 package library
 
 import (
+	"container/list"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/nytlabs/gojee"                 // jee
 	"github.com/nytlabs/streamtools/st/blocks" // blocks
 	"github.com/nytlabs/streamtools/st/util"   // util
 )
 
+// dedupeEntry is a value dedupe remembers, and when it forgets it. Expires is
+// zero for values it remembers until they are evicted.
+type dedupeEntry struct {
+	Key     string
+	Expires time.Time
+}
+
+// bloomState is a bloom filter as it is saved.
+type bloomState struct {
+	Bits  []uint64
+	K     uint
+	Count int
+	Start time.Time
+}
+
+// dedupeState is what dedupe saves: the values it remembers, the next to be
+// forgotten first, or its bloom filters, newest first.
+type dedupeState struct {
+	Entries []dedupeEntry `json:",omitempty"`
+	Filters []bloomState  `json:",omitempty"`
+}
+
+// seenSet remembers which values dedupe has seen.
+type seenSet interface {
+	// seen says whether key has been seen, and remembers that it has.
+	seen(key string, now time.Time) bool
+	// expire forgets the values that are due to be forgotten by now.
+	expire(now time.Time)
+	// next is when a value is next due to be forgotten, or zero.
+	next() time.Time
+	size() int
+	state() dedupeState
+	restore(state dedupeState, now time.Time) error
+}
+
+// exactSet remembers every value, up to maxEntries of them if that is set.
+// They are kept in the order they are due to be forgotten, the next to go at
+// the back: by when they were last seen or, if they expire a while after
+// they were first seen, by when that was.
+type exactSet struct {
+	ttl        time.Duration
+	sliding    bool
+	maxEntries int
+	order      *list.List
+	items      map[string]*list.Element
+}
+
+func newExactSet(ttl time.Duration, sliding bool, maxEntries int) *exactSet {
+	return &exactSet{
+		ttl:        ttl,
+		sliding:    sliding,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+func (s *exactSet) push(e *dedupeEntry) {
+	if el, ok := s.items[e.Key]; ok {
+		s.remove(el)
+	}
+	s.items[e.Key] = s.order.PushFront(e)
+	for s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		s.remove(s.order.Back())
+	}
+}
+
+func (s *exactSet) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.items, el.Value.(*dedupeEntry).Key)
+}
+
+func (s *exactSet) seen(key string, now time.Time) bool {
+	s.expire(now)
+	el, ok := s.items[key]
+	if !ok {
+		e := &dedupeEntry{Key: key}
+		if s.ttl > 0 {
+			e.Expires = now.Add(s.ttl)
+		}
+		s.push(e)
+		return false
+	}
+
+	// a value that expires a while after it was first seen stays where it
+	// is, so that the values are still in the order they expire.
+	switch {
+	case s.ttl == 0:
+		s.order.MoveToFront(el)
+	case s.sliding:
+		el.Value.(*dedupeEntry).Expires = now.Add(s.ttl)
+		s.order.MoveToFront(el)
+	}
+	return true
+}
+
+func (s *exactSet) expire(now time.Time) {
+	for s.order.Len() > 0 {
+		el := s.order.Back()
+		e := el.Value.(*dedupeEntry)
+		if e.Expires.IsZero() || now.Before(e.Expires) {
+			return
+		}
+		s.remove(el)
+	}
+}
+
+func (s *exactSet) next() time.Time {
+	if s.order.Len() == 0 {
+		return time.Time{}
+	}
+	return s.order.Back().Value.(*dedupeEntry).Expires
+}
+
+func (s *exactSet) size() int {
+	return s.order.Len()
+}
+
+func (s *exactSet) state() dedupeState {
+	entries := make([]dedupeEntry, 0, s.order.Len())
+	for el := s.order.Back(); el != nil; el = el.Prev() {
+		entries = append(entries, *el.Value.(*dedupeEntry))
+	}
+	return dedupeState{
+		Entries: entries,
+	}
+}
+
+func (s *exactSet) restore(state dedupeState, now time.Time) error {
+	if len(state.Filters) > 0 {
+		return errors.New("can't dedupe exactly from values saved in bloom filters")
+	}
+	for _, e := range state.Entries {
+		// the TTL may have changed since the values were saved.
+		e := e
+		switch {
+		case s.ttl == 0:
+			e.Expires = time.Time{}
+		case e.Expires.IsZero() || e.Expires.After(now.Add(s.ttl)):
+			e.Expires = now.Add(s.ttl)
+		}
+		s.push(&e)
+	}
+	s.expire(now)
+	return nil
+}
+
+// bloomSet remembers values in bloom filters, which take the same memory
+// however many values there are, but sometimes wrongly say a value has been
+// seen. Values go into the newest filter. Once it has been going for ttl, or
+// holds capacity values, a new filter is started and the one before it is
+// forgotten, so values are remembered for between one and two ttls.
+type bloomSet struct {
+	capacity int
+	ttl      time.Duration
+	sliding  bool
+	m, k     uint
+	filters  []*bloomFilter
+}
+
+func newBloomSet(capacity int, errorRate float64, ttl time.Duration, sliding bool, now time.Time) *bloomSet {
+	m, k := bloomSize(capacity, errorRate)
+	return &bloomSet{
+		capacity: capacity,
+		ttl:      ttl,
+		sliding:  sliding,
+		m:        m,
+		k:        k,
+		filters:  []*bloomFilter{newBloomFilter(m, k, now)},
+	}
+}
+
+func (s *bloomSet) rotate(start time.Time) {
+	s.filters = []*bloomFilter{newBloomFilter(s.m, s.k, start), s.filters[0]}
+}
+
+func (s *bloomSet) add(key string, now time.Time) {
+	if s.filters[0].count >= s.capacity {
+		s.rotate(now)
+	}
+	s.filters[0].add(key)
+}
+
+func (s *bloomSet) seen(key string, now time.Time) bool {
+	s.expire(now)
+	if s.filters[0].has(key) {
+		return true
+	}
+	if len(s.filters) > 1 && s.filters[1].has(key) {
+		// a sliding value seen again is kept from being forgotten with the
+		// old filter.
+		if s.sliding {
+			s.add(key, now)
+		}
+		return true
+	}
+	s.add(key, now)
+	return false
+}
+
+func (s *bloomSet) expire(now time.Time) {
+	if s.ttl == 0 {
+		return
+	}
+	start := s.filters[0].start
+	switch {
+	case !now.Before(start.Add(2 * s.ttl)):
+		s.filters = []*bloomFilter{newBloomFilter(s.m, s.k, now)}
+	case !now.Before(start.Add(s.ttl)):
+		s.rotate(start.Add(s.ttl))
+	}
+}
+
+func (s *bloomSet) next() time.Time {
+	if s.ttl == 0 {
+		return time.Time{}
+	}
+	return s.filters[0].start.Add(s.ttl)
+}
+
+// size is how many values went into the filters, which is more than how many
+// there are if some were seen in both.
+func (s *bloomSet) size() int {
+	n := 0
+	for _, f := range s.filters {
+		n += f.count
+	}
+	return n
+}
+
+func (s *bloomSet) state() dedupeState {
+	filters := make([]bloomState, len(s.filters))
+	for i, f := range s.filters {
+		filters[i] = bloomState{
+			Bits:  f.bits,
+			K:     f.k,
+			Count: f.count,
+			Start: f.start,
+		}
+	}
+	return dedupeState{
+		Filters: filters,
+	}
+}
+
+func (s *bloomSet) restore(state dedupeState, now time.Time) error {
+	for _, e := range state.Entries {
+		if s.filters[0].has(e.Key) {
+			continue
+		}
+		s.add(e.Key, now)
+	}
+	if len(state.Filters) == 0 {
+		return nil
+	}
+
+	if len(state.Filters) > 2 {
+		return errors.New("can't restore more than two bloom filters")
+	}
+	var filters []*bloomFilter
+	for _, saved := range state.Filters {
+		f := newBloomFilter(s.m, s.k, saved.Start)
+		if len(saved.Bits) != len(f.bits) || saved.K != f.k {
+			return errors.New("the saved bloom filters are a different size to the ones the rule asks for")
+		}
+		copy(f.bits, saved.Bits)
+		f.count = saved.Count
+		filters = append(filters, f)
+	}
+	s.filters = filters
+	s.expire(now)
+	return nil
+}
+
+// readDedupeState reads what dedupe saved. Before dedupe could forget values,
+// it saved a list of them.
+func readDedupeState(data []byte) (*dedupeState, error) {
+	var members []interface{}
+	if json.Unmarshal(data, &members) == nil {
+		state := &dedupeState{}
+		for _, v := range members {
+			state.Entries = append(state.Entries, dedupeEntry{
+				Key: groupKey(v),
+			})
+		}
+		return state, nil
+	}
+
+	state := &dedupeState{}
+	err := json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
 // specify those channels we're going to use to communicate with streamtools
 type DeDupe struct {
 	blocks.Block
+	snapshot  chan blocks.MsgChan
+	restore   blocks.MsgChan
 	queryrule chan blocks.MsgChan
+	querysize chan blocks.MsgChan
 	inrule    blocks.MsgChan
+	clear     blocks.MsgChan
 	in        blocks.MsgChan
 	out       blocks.MsgChan
 	quit      blocks.MsgChan
@@ -26,61 +330,293 @@ func NewDeDupe() blocks.BlockInterface {
 // Setup is called once before running the block. We build up the channels and specify what kind of block this is.
 func (b *DeDupe) Setup() {
 	b.Kind = "Core"
-	b.Desc = "stores a set of messages as specified by Path, emiting only those it hasn't seen before."
+	b.Desc = "emits only the messages whose value at Path, or values at Paths, it hasn't seen before, remembering them for a TTL or up to MaxEntries of them."
 	b.in = b.InRoute("in")
 	b.inrule = b.InRoute("rule")
+	b.clear = b.InRoute("clear")
 	b.queryrule = b.QueryRoute("rule")
+	b.querysize = b.QueryRoute("size")
+	b.snapshot = b.SnapshotRoute()
+	b.restore = b.RestoreRoute()
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
 }
 
+// dedupeKey is what a message is deduped on: the value at its one path, or
+// the list of values at its paths.
+func dedupeKey(trees []*jee.TokenTree, msg interface{}) (string, error) {
+	values := make([]interface{}, len(trees))
+	found := false
+	for i, tree := range trees {
+		v, err := jee.Eval(tree, msg)
+		if err != nil {
+			return "", err
+		}
+		values[i] = v
+		found = found || v != nil
+	}
+	if !found {
+		return "", errors.New("the message has no value to dedupe on")
+	}
+	if len(values) == 1 {
+		return groupKey(values[0]), nil
+	}
+	return groupKey(values), nil
+}
+
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *DeDupe) Run() {
-	var path string
-	set := make(map[interface{}]bool)
-	var tree *jee.TokenTree
-	var err error
+	clock := b.Clock()
+	var path, ttlString, mode string
+	var paths []string
+	var trees []*jee.TokenTree
+	var sliding bool
+	var maxEntries, capacity int
+	var errorRate float64
+	var newSet func(now time.Time) seenSet
+	var set seenSet
+
+	// restored state waits here until a rule says how to remember values.
+	var restored *dedupeState
+
+	expireTimer := clock.NewTimer(time.Second)
 	for {
 		select {
+		case <-expireTimer.C():
 		case ruleI := <-b.inrule:
 			// set a parameter of the block
-			path, err = util.ParseString(ruleI, "Path")
-			tree, err = util.BuildTokenTree(path)
+			var tmpPath string
+			tmpPaths := []string{}
+			var err error
+			if util.KeyExists(ruleI, "Path") {
+				tmpPath, err = util.ParseString(ruleI, "Path")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if util.KeyExists(ruleI, "Paths") {
+				tmpPaths, err = util.ParseArrayString(ruleI, "Paths")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			all := tmpPaths
+			if tmpPath != "" {
+				all = append([]string{tmpPath}, tmpPaths...)
+			}
+			if len(all) == 0 {
+				b.Error(errors.New("dedupe needs a Path or Paths"))
+				break
+			}
+			var tmpTrees []*jee.TokenTree
+			for _, p := range all {
+				tree, err := util.BuildTokenTree(p)
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				tmpTrees = append(tmpTrees, tree)
+			}
+			if len(tmpTrees) != len(all) {
+				break
+			}
+
+			var tmpTTLString string
+			var ttl time.Duration
+			if util.KeyExists(ruleI, "TTL") {
+				tmpTTLString, err = util.ParseString(ruleI, "TTL")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpTTLString != "" {
+				ttl, err = time.ParseDuration(tmpTTLString)
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if ttl < 0 {
+					b.Error(errors.New("TTL must not be negative"))
+					break
+				}
+			}
+
+			tmpSliding := false
+			if util.KeyExists(ruleI, "Sliding") {
+				tmpSliding, err = util.ParseBool(ruleI, "Sliding")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+
+			tmpMaxEntries := 0
+			if util.KeyExists(ruleI, "MaxEntries") {
+				tmpMaxEntries, err = util.ParseInt(ruleI, "MaxEntries")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpMaxEntries < 0 {
+					b.Error(errors.New("MaxEntries must not be negative"))
+					break
+				}
+			}
+
+			tmpMode := "exact"
+			if util.KeyExists(ruleI, "Mode") {
+				tmpMode, err = util.ParseString(ruleI, "Mode")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpMode != "exact" && tmpMode != "bloom" {
+				b.Error(errors.New(fmt.Sprintf("Mode must be exact or bloom, not %s", tmpMode)))
+				break
+			}
+
+			tmpCapacity := 1000000
+			if util.KeyExists(ruleI, "Capacity") {
+				tmpCapacity, err = util.ParseInt(ruleI, "Capacity")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpCapacity <= 0 {
+					b.Error(errors.New("Capacity must be positive"))
+					break
+				}
+			}
+
+			tmpErrorRate := 0.01
+			if util.KeyExists(ruleI, "ErrorRate") {
+				tmpErrorRate, err = util.ParseFloat(ruleI, "ErrorRate")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpErrorRate <= 0 || tmpErrorRate >= 1 {
+					b.Error(errors.New("ErrorRate must be between 0 and 1"))
+					break
+				}
+			}
+
+			path, paths, trees = tmpPath, tmpPaths, tmpTrees
+			ttlString, sliding, maxEntries = tmpTTLString, tmpSliding, tmpMaxEntries
+			mode, capacity, errorRate = tmpMode, tmpCapacity, tmpErrorRate
+			newSet = func(now time.Time) seenSet {
+				if tmpMode == "bloom" {
+					return newBloomSet(tmpCapacity, tmpErrorRate, ttl, tmpSliding, now)
+				}
+				return newExactSet(ttl, tmpSliding, tmpMaxEntries)
+			}
+
+			// the values seen so far are kept, as far as the new rule can
+			// keep them.
+			now := clock.Now()
+			old := set
+			set = newSet(now)
+			switch {
+			case restored != nil:
+				err = set.restore(*restored, now)
+				restored = nil
+			case old != nil:
+				err = set.restore(old.state(), now)
+			}
 			if err != nil {
 				b.Error(err)
-				break
 			}
 		case <-b.quit:
 			// quit the block
 			return
 			// deal with inbound data
 		case msg := <-b.in:
-			if tree == nil {
+			if set == nil {
 				continue
 			}
-			v, err := jee.Eval(tree, msg)
+			key, err := dedupeKey(trees, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
-
-			if _, ok := v.(string); !ok {
-				b.Error(errors.New("can only dedupe sets of strings"))
-				continue
-			}
-
-			_, ok := set[v]
-			// emit the incoming message if it isn't found in the set
-			if !ok {
+			// emit the incoming message if it hasn't been seen
+			if !set.seen(key, clock.Now()) {
 				b.out <- msg
-				set[v] = true // and add it to the set
 			}
+		case <-b.clear:
+			if set != nil {
+				set = newSet(clock.Now())
+			}
+			restored = nil
 		case c := <-b.queryrule:
 			// deal with a query request
+			ps := make([]interface{}, len(paths))
+			for i, p := range paths {
+				ps[i] = p
+			}
 			c <- map[string]interface{}{
-				"Path": path,
+				"Path":       path,
+				"Paths":      ps,
+				"TTL":        ttlString,
+				"Sliding":    sliding,
+				"MaxEntries": float64(maxEntries),
+				"Mode":       mode,
+				"Capacity":   float64(capacity),
+				"ErrorRate":  errorRate,
 			}
+		case c := <-b.querysize:
+			size := 0
+			if set != nil {
+				set.expire(clock.Now())
+				size = set.size()
+			}
+			c <- map[string]interface{}{
+				"Size": float64(size),
+			}
+		case c := <-b.snapshot:
+			state := &dedupeState{}
+			if set != nil {
+				s := set.state()
+				state = &s
+			} else if restored != nil {
+				state = restored
+			}
+			data, err := json.Marshal(state)
+			if err != nil {
+				b.Error(err)
+			}
+			c <- data
+		case data := <-b.restore:
+			state, err := readDedupeState(data.([]byte))
+			if err != nil {
+				b.Error(err)
+				continue
+			}
+			if set == nil {
+				restored = state
+				continue
+			}
+			now := clock.Now()
+			set = newSet(now)
+			err = set.restore(*state, now)
+			if err != nil {
+				b.Error(err)
+			}
+		}
 
+		// forget what should be forgotten, and wake up when more should be.
+		wait := time.Second
+		if set != nil {
+			now := clock.Now()
+			set.expire(now)
+			if next := set.next(); !next.IsZero() && next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
 		}
+		expireTimer.Reset(wait)
 	}
 }
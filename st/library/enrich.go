@@ -0,0 +1,516 @@
+package library
+
+import (
+	"container/list"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/nytlabs/gojee"
+	"github.com/nytlabs/streamtools/st/blocks" // blocks
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// lruEntry is a looked up value, or the lack of one.
+type lruEntry struct {
+	key   string
+	value interface{}
+	found bool
+	t     time.Time
+}
+
+// lru remembers the most recently used lookups, up to size of them, for up
+// to ttl if it is set.
+type lru struct {
+	size  int
+	ttl   time.Duration
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newLRU(size int, ttl time.Duration) *lru {
+	return &lru{
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+func (c *lru) get(key string, now time.Time) (*lruEntry, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if c.ttl > 0 && !now.Before(e.t.Add(c.ttl)) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e, true
+}
+
+func (c *lru) put(e *lruEntry) {
+	if c.size <= 0 {
+		return
+	}
+	if el, ok := c.items[e.key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[e.key] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.items, el.Value.(*lruEntry).key)
+	}
+}
+
+// readTable reads a file of reference data into a table of values by key.
+// JSON files hold an object of values by key. CSV files have a header row,
+// and each row is a value, keyed by the column called keyColumn, or by the
+// first column.
+func readTable(filename string, keyColumn string) (map[string]interface{}, error) {
+	if strings.ToLower(filepath.Ext(filename)) != ".csv" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var table map[string]interface{}
+		err = json.Unmarshal(data, &table)
+		if err != nil {
+			return nil, err
+		}
+		return table, nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New(fmt.Sprintf("%s has no header row", filename))
+	}
+
+	header := rows[0]
+	keyIndex := 0
+	if keyColumn != "" {
+		keyIndex = -1
+		for i, h := range header {
+			if h == keyColumn {
+				keyIndex = i
+			}
+		}
+		if keyIndex < 0 {
+			return nil, errors.New(fmt.Sprintf("%s has no column called %s", filename, keyColumn))
+		}
+	}
+
+	table := map[string]interface{}{}
+	for _, row := range rows[1:] {
+		if keyIndex >= len(row) {
+			continue
+		}
+		value := map[string]interface{}{}
+		for i, h := range header {
+			if i < len(row) {
+				value[h] = row[i]
+			}
+		}
+		table[row[keyIndex]] = value
+	}
+	return table, nil
+}
+
+// lookupKey turns a key read from a message into a string.
+func lookupKey(k interface{}) (string, error) {
+	switch k := k.(type) {
+	case string:
+		return k, nil
+	case float64:
+		return strconv.FormatFloat(k, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(k), nil
+	}
+	return "", errors.New("the key must be a string, a number or a bool")
+}
+
+// enrichSource is where an enrich block looks keys up.
+type enrichSource struct {
+	source    string
+	blockId   string
+	route     string
+	file      string
+	keyColumn string
+	server    string
+	password  string
+	hash      string
+	table     map[string]interface{}
+	pool      *redis.Pool
+}
+
+// parseEnrichSource reads the Source rule field, and the fields that go with
+// the source it names, for the enrich block with the given id.
+func parseEnrichSource(rule interface{}, id string) (*enrichSource, error) {
+	source, err := util.ParseRequiredString(rule, "Source")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &enrichSource{
+		source: source,
+	}
+	switch source {
+	case "block":
+		s.blockId, err = util.ParseRequiredString(rule, "Block")
+		if err != nil {
+			return nil, err
+		}
+		// the block would wait on itself for every lookup.
+		if s.blockId == id {
+			return nil, errors.New("an enrich block cannot look up in itself")
+		}
+		s.route = "lookup"
+		if util.KeyExists(rule, "Route") {
+			s.route, err = util.ParseRequiredString(rule, "Route")
+			if err != nil {
+				return nil, err
+			}
+		}
+	case "file":
+		s.file, err = util.ParseRequiredString(rule, "File")
+		if err != nil {
+			return nil, err
+		}
+		if util.KeyExists(rule, "KeyColumn") {
+			s.keyColumn, err = util.ParseString(rule, "KeyColumn")
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.table, err = readTable(s.file, s.keyColumn)
+		if err != nil {
+			return nil, err
+		}
+	case "redis":
+		s.server, err = util.ParseRequiredString(rule, "Server")
+		if err != nil {
+			return nil, err
+		}
+		if util.KeyExists(rule, "Password") {
+			s.password, err = util.ParseString(rule, "Password")
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.hash, err = util.ParseRequiredString(rule, "Hash")
+		if err != nil {
+			return nil, err
+		}
+		s.pool = newPool(s.server, s.password)
+	default:
+		return nil, errors.New("Source must be block, file or redis")
+	}
+
+	return s, nil
+}
+
+// fetch looks a key up, and reports whether it was found.
+func (s *enrichSource) fetch(looker blocks.Looker, k string) (interface{}, bool, error) {
+	switch s.source {
+	case "block":
+		if looker == nil {
+			return nil, false, errors.New("the block isn't in a pattern it can look things up in")
+		}
+		answer, err := looker.Lookup(s.blockId, s.route, url.Values{"key": []string{k}})
+		if err != nil {
+			return nil, false, err
+		}
+		// blocks like cache answer with the key and its value.
+		if m, ok := answer.(map[string]interface{}); ok {
+			if v, ok := m["value"]; ok {
+				return v, v != nil, nil
+			}
+		}
+		return answer, answer != nil, nil
+	case "file":
+		v, ok := s.table[k]
+		return v, ok, nil
+	case "redis":
+		conn := s.pool.Get()
+		defer conn.Close()
+		reply, err := conn.Do("HGET", s.hash, k)
+		if err != nil {
+			return nil, false, err
+		}
+		if reply == nil {
+			return nil, false, nil
+		}
+		data, ok := reply.([]byte)
+		if !ok {
+			return nil, false, errors.New("unexpected reply from redis")
+		}
+		// values that are JSON are decoded, anything else is a string.
+		var v interface{}
+		if json.Unmarshal(data, &v) != nil {
+			v = string(data)
+		}
+		return v, true, nil
+	}
+	return nil, false, nil
+}
+
+func (s *enrichSource) close() {
+	if s != nil && s.pool != nil {
+		s.pool.Close()
+	}
+}
+
+// rule adds the source's fields to the answer to a rule query.
+func (s *enrichSource) rule(r map[string]interface{}) map[string]interface{} {
+	if s == nil {
+		s = &enrichSource{}
+	}
+	r["Source"] = s.source
+	r["Block"] = s.blockId
+	r["Route"] = s.route
+	r["File"] = s.file
+	r["KeyColumn"] = s.keyColumn
+	r["Server"] = s.server
+	r["Password"] = s.password
+	r["Hash"] = s.hash
+	return r
+}
+
+// specify those channels we're going to use to communicate with streamtools
+type Enrich struct {
+	blocks.Block
+	queryrule chan blocks.MsgChan
+	inrule    blocks.MsgChan
+	clear     blocks.MsgChan
+	in        blocks.MsgChan
+	out       blocks.MsgChan
+	miss      blocks.MsgChan
+	quit      blocks.MsgChan
+}
+
+// we need to build a simple factory so that streamtools can make new blocks of this kind
+func NewEnrich() blocks.BlockInterface {
+	return &Enrich{}
+}
+
+// Setup is called once before running the block. We build up the channels and specify what kind of block this is.
+func (b *Enrich) Setup() {
+	b.Kind = "Core"
+	b.Desc = "looks up a key from each message in another block, a file or redis, and adds what it finds to the message"
+	b.in = b.InRoute("in")
+	b.inrule = b.InRoute("rule")
+	b.queryrule = b.QueryRoute("rule")
+	b.clear = b.InRoute("clear")
+	b.quit = b.Quit()
+	b.out = b.Broadcast()
+	b.miss = b.OutRoute("miss")
+}
+
+// Run is the block's main loop. Here we listen on the different channels we set up.
+func (b *Enrich) Run() {
+	clock := b.Clock()
+
+	var keyPath, into string
+	onMiss := "pass"
+	cacheSize := 1000
+	var cacheTTL time.Duration
+	var keyTree *jee.TokenTree
+	var source *enrichSource
+	cache := newLRU(cacheSize, cacheTTL)
+
+	for {
+		select {
+		case ruleI := <-b.inrule:
+			tmpKeyPath, err := util.ParseRequiredString(ruleI, "KeyPath")
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			tmpKeyTree, err := util.BuildTokenTree(tmpKeyPath)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+
+			var tmpInto string
+			if util.KeyExists(ruleI, "Into") {
+				tmpInto, err = util.ParseString(ruleI, "Into")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+
+			tmpOnMiss := "pass"
+			if util.KeyExists(ruleI, "OnMiss") {
+				tmpOnMiss, err = util.ParseString(ruleI, "OnMiss")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpOnMiss != "drop" && tmpOnMiss != "pass" && tmpOnMiss != "route" {
+				b.Error(errors.New("OnMiss must be drop, pass or route"))
+				break
+			}
+
+			tmpCacheSize := 1000
+			if util.KeyExists(ruleI, "CacheSize") {
+				tmpCacheSize, err = util.ParseInt(ruleI, "CacheSize")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+
+			var tmpCacheTTL time.Duration
+			if util.KeyExists(ruleI, "CacheTTL") {
+				ttlString, err := util.ParseString(ruleI, "CacheTTL")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if ttlString != "" {
+					tmpCacheTTL, err = time.ParseDuration(ttlString)
+					if err != nil {
+						b.Error(err)
+						break
+					}
+				}
+			}
+
+			// the source comes last, as it may read a file or connect to redis.
+			tmpSource, err := parseEnrichSource(ruleI, b.Id)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+
+			source.close()
+			keyPath, keyTree = tmpKeyPath, tmpKeyTree
+			source = tmpSource
+			into = tmpInto
+			onMiss = tmpOnMiss
+			cacheSize, cacheTTL = tmpCacheSize, tmpCacheTTL
+			cache = newLRU(cacheSize, cacheTTL)
+		case <-b.quit:
+			source.close()
+			// quit the block
+			return
+		case <-b.clear:
+			cache = newLRU(cacheSize, cacheTTL)
+		case msg := <-b.in:
+			if keyTree == nil {
+				break
+			}
+
+			m, ok := msg.(map[string]interface{})
+			if !ok {
+				b.Error(errors.New("can only enrich objects"), msg)
+				break
+			}
+
+			kI, err := jee.Eval(keyTree, msg)
+			if err != nil {
+				b.Error(err, msg)
+				break
+			}
+			k, err := lookupKey(kI)
+			if err != nil {
+				b.Error(err, msg)
+				break
+			}
+
+			now := clock.Now()
+			e, ok := cache.get(k, now)
+			if !ok {
+				v, found, err := source.fetch(b.Looker(), k)
+				if err != nil {
+					b.Error(err, msg)
+					break
+				}
+				e = &lruEntry{
+					key:   k,
+					value: v,
+					found: found,
+					t:     now,
+				}
+				cache.put(e)
+			}
+
+			if !e.found {
+				switch onMiss {
+				case "pass":
+					b.out <- msg
+				case "route":
+					b.miss <- msg
+				}
+				break
+			}
+
+			var fields map[string]interface{}
+			if into == "" {
+				fields, ok = e.value.(map[string]interface{})
+				if !ok {
+					b.Error(errors.New("can only merge objects into a message without Into"), msg)
+					break
+				}
+			} else {
+				fields = map[string]interface{}{
+					into: e.value,
+				}
+			}
+
+			// the message may be on its way to other blocks too, so it is
+			// copied rather than changed.
+			enriched := make(map[string]interface{}, len(m)+len(fields))
+			for k, v := range m {
+				enriched[k] = v
+			}
+			for k, v := range fields {
+				enriched[k] = v
+			}
+			b.out <- enriched
+		case c := <-b.queryrule:
+			c <- source.rule(map[string]interface{}{
+				"KeyPath":   keyPath,
+				"Into":      into,
+				"OnMiss":    onMiss,
+				"CacheSize": float64(cacheSize),
+				"CacheTTL":  durationString(cacheTTL),
+			})
+		}
+	}
+}
+
+// durationString is how an optional duration appears in a rule.
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
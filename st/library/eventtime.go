@@ -0,0 +1,115 @@
+package library
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nytlabs/gojee"
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// eventTime is for windowed blocks that go by when messages happened, read
+// from a path in the messages, rather than when they arrived. Time moves on
+// with the watermark: the latest time seen, less the allowed lateness. A
+// message from before the watermark is late, and is left out of the window.
+type eventTime struct {
+	path      string
+	tree      *jee.TokenTree
+	lateness  time.Duration
+	watermark time.Time
+}
+
+// parseEventTime reads the optional TimePath and AllowedLateness rule
+// fields. It returns nil if the rule has no TimePath, when the block should
+// go by arrival time.
+func parseEventTime(rule interface{}) (*eventTime, error) {
+	if !util.KeyExists(rule, "TimePath") {
+		return nil, nil
+	}
+
+	path, err := util.ParseString(rule, "TimePath")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	tree, err := util.BuildTokenTree(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lateness time.Duration
+	if util.KeyExists(rule, "AllowedLateness") {
+		latenessString, err := util.ParseString(rule, "AllowedLateness")
+		if err != nil {
+			return nil, err
+		}
+		lateness, err = time.ParseDuration(latenessString)
+		if err != nil {
+			return nil, err
+		}
+		if lateness < 0 {
+			return nil, errors.New("AllowedLateness must not be negative")
+		}
+	}
+
+	return &eventTime{
+		path:     path,
+		tree:     tree,
+		lateness: lateness,
+	}, nil
+}
+
+// rule adds the event time fields to the answer to a rule query, if the
+// block is going by event time.
+func (e *eventTime) rule(r map[string]interface{}) map[string]interface{} {
+	if e == nil {
+		return r
+	}
+	r["TimePath"] = e.path
+	r["AllowedLateness"] = e.lateness.String()
+	return r
+}
+
+// time reads when a message happened. Times are either milliseconds since
+// the epoch, as in sync, or RFC3339 strings.
+func (e *eventTime) time(msg interface{}) (time.Time, error) {
+	v, err := jee.Eval(e.tree, msg)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	switch v := v.(type) {
+	case float64:
+		return time.Unix(0, int64(v*1000000)), nil
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	}
+
+	return time.Time{}, errors.New("time must be a number of milliseconds or an RFC3339 string")
+}
+
+// observe moves the watermark on for a message that happened at t, and
+// reports whether the message is late.
+func (e *eventTime) observe(t time.Time) bool {
+	if !e.watermark.IsZero() && t.Before(e.watermark) {
+		return true
+	}
+
+	if mark := t.Add(-e.lateness); mark.After(e.watermark) {
+		e.watermark = mark
+	}
+	return false
+}
+
+// now is the time windows end at: the watermark when going by event time,
+// and the clock's time otherwise.
+func (e *eventTime) now(clock blocks.Clock) time.Time {
+	if e == nil {
+		return clock.Now()
+	}
+	return e.watermark
+}
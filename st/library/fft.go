@@ -79,34 +79,34 @@ func (b *FFT) Run() {
 			}
 			vI, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			v, ok := vI.([]interface{})
 			if !ok {
-				b.Error(errors.New("could not assert timeseries to an array"))
+				b.Error(errors.New("could not assert timeseries to an array"), msg)
 				continue
 			}
 			values := make([]tsDataPoint, len(v))
 			for i, vi := range v {
 				value, ok := vi.(map[string]interface{})
 				if !ok {
-					b.Error(errors.New("could not assert value to map"))
+					b.Error(errors.New("could not assert value to map"), msg)
 					continue
 				}
 				tI, ok := value["timestamp"]
 				if !ok {
-					b.Error(errors.New("could not find timestamp in value"))
+					b.Error(errors.New("could not find timestamp in value"), msg)
 					continue
 				}
 				t, ok := tI.(float64)
 				if !ok {
-					b.Error(errors.New("could not assert timestamp to float"))
+					b.Error(errors.New("could not assert timestamp to float"), msg)
 					continue
 				}
 				yI, ok := value["value"]
 				if !ok {
-					b.Error(errors.New("could not assert timeseries value to float"))
+					b.Error(errors.New("could not assert timeseries value to float"), msg)
 					continue
 				}
 				y, ok := yI.(float64)
@@ -1,6 +1,8 @@
 package library
 
 import (
+	"errors"
+
 	"github.com/nytlabs/gojee"
 	"github.com/nytlabs/streamtools/st/blocks" // blocks
 	"github.com/nytlabs/streamtools/st/util"
@@ -12,6 +14,7 @@ type Filter struct {
 	inrule    blocks.MsgChan
 	in        blocks.MsgChan
 	out       blocks.MsgChan
+	nomatch   blocks.MsgChan
 	quit      blocks.MsgChan
 }
 
@@ -22,12 +25,33 @@ func NewFilter() blocks.BlockInterface {
 
 func (b *Filter) Setup() {
 	b.Kind = "Core"
-	b.Desc = "selectively emits messages based on criteria defined in this block's rule"
+	b.Desc = "selectively emits messages based on criteria defined in this block's rule, sending those that don't match to nomatch"
 	b.in = b.InRoute("in")
 	b.inrule = b.InRoute("rule")
 	b.queryrule = b.QueryRoute("rule")
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
+	b.nomatch = b.OutRoute("nomatch")
+}
+
+// parseFilter reads the rule's Filter expression.
+func parseFilter(ruleI interface{}) (string, *jee.TokenTree, error) {
+	filterS, err := util.ParseString(ruleI, "Filter")
+	if err != nil {
+		return "", nil, errors.New("bad filter")
+	}
+
+	tree, err := util.BuildTokenTree(filterS)
+	if err != nil {
+		return "", nil, err
+	}
+	return filterS, tree, nil
+}
+
+// CheckRule makes sure the rule's Filter is an expression.
+func (b *Filter) CheckRule(ruleI interface{}) error {
+	_, _, err := parseFilter(ruleI)
+	return err
 }
 
 func (b *Filter) Run() {
@@ -39,13 +63,13 @@ func (b *Filter) Run() {
 		select {
 		case msg := <-b.in:
 			if parsed == nil {
-				b.Error("no filter set")
+				b.Error("no filter set", msg)
 				break
 			}
 
 			e, err := jee.Eval(parsed, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 
@@ -56,22 +80,12 @@ func (b *Filter) Run() {
 
 			if eval == true {
 				b.out <- msg
+			} else {
+				b.nomatch <- msg
 			}
 
 		case ruleI := <-b.inrule:
-			filterS, err := util.ParseString(ruleI, "Filter")
-			if err != nil {
-				b.Error("bad filter")
-				break
-			}
-
-			lexed, err := jee.Lexer(filterS)
-			if err != nil {
-				b.Error(err)
-				break
-			}
-
-			tree, err := jee.Parser(lexed)
+			filterS, tree, err := parseFilter(ruleI)
 			if err != nil {
 				b.Error(err)
 				break
@@ -36,6 +36,7 @@ func (b *FromFile) Setup() {
 	b.queryrule = b.QueryRoute("rule")
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
+	b.Finite()
 }
 
 // Run is the block's main loop. Here we listen on the different channels we set up.
@@ -62,6 +63,7 @@ func (b *FromFile) Run() {
 			}
 
 			reader = bufio.NewReader(file)
+			b.SetExhausted(false)
 
 		case c := <-b.queryrule:
 			c <- map[string]interface{}{
@@ -69,7 +71,8 @@ func (b *FromFile) Run() {
 			}
 
 		case <-b.inpoll:
-			if reader == nil && filename == "" {
+			if reader == nil {
+				b.SetExhausted(true)
 				b.Error("you must configure a filename before polling this block.")
 				break
 			}
@@ -82,6 +85,13 @@ func (b *FromFile) Run() {
 				continue
 			}
 
+			// at the end of the file there is nothing to emit until more is
+			// written to it.
+			b.SetExhausted(err == io.EOF)
+			if len(line) == 0 {
+				continue
+			}
+
 			err = json.Unmarshal(line, &outMsg)
 			// if the json parsing fails, store data unparsed as "data"
 			if err != nil {
@@ -72,25 +72,25 @@ func (b *GetHTTP) Run() {
 			}
 			urlInterface, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			urlString, ok := urlInterface.(string)
 			if !ok {
-				b.Error(errors.New("couldn't assert url to a string"))
+				b.Error(errors.New("couldn't assert url to a string"), msg)
 				continue
 			}
 
 			resp, err := client.Get(urlString)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			defer resp.Body.Close()
 
 			body, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			var outMsg interface{}
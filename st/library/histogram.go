@@ -2,6 +2,7 @@ package library
 
 import (
 	"container/heap"
+	"encoding/json"
 	"errors"
 	"strconv"
 	"time"
@@ -18,8 +19,11 @@ type Histogram struct {
 	historule chan blocks.MsgChan
 	inrule    blocks.MsgChan
 	inpoll    blocks.MsgChan
+	snapshot  chan blocks.MsgChan
+	restore   blocks.MsgChan
 	in        blocks.MsgChan
 	out       blocks.MsgChan
+	late      blocks.MsgChan
 	quit      blocks.MsgChan
 }
 
@@ -58,19 +62,28 @@ func (b *Histogram) Setup() {
 	b.inrule = b.InRoute("rule")
 	b.queryrule = b.QueryRoute("rule")
 	b.historule = b.QueryRoute("histogram")
+	b.snapshot = b.SnapshotRoute()
+	b.restore = b.RestoreRoute()
 	b.inpoll = b.InRoute("poll")
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
+	b.late = b.OutRoute("late")
 }
 
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *Histogram) Run() {
+	clock := b.Clock()
 	var tree *jee.TokenTree
 	var path string
-	waitTimer := time.NewTimer(100 * time.Millisecond)
+	waitTimer := clock.NewTimer(100 * time.Millisecond)
 	window := time.Duration(0)
+	var events *eventTime
 
 	histogram := map[string]*PriorityQueue{}
+
+	// restored state waits here until a rule sets the window, otherwise it
+	// would all expire at once.
+	var restored map[string]*PriorityQueue
 	emptyByte := make([]byte, 0)
 MainLoop:
 	for {
@@ -80,18 +93,37 @@ MainLoop:
 			windowString, err := util.ParseString(ruleI, "Window")
 			if err != nil {
 				b.Error(err)
+				break
+			}
+			tmpWindow, err := time.ParseDuration(windowString)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			tmpPath, err := util.ParseString(ruleI, "Path")
+			if err != nil {
+				b.Error(err)
+				break
 			}
-			window, err = time.ParseDuration(windowString)
+			tmpTree, err := util.BuildTokenTree(tmpPath)
 			if err != nil {
 				b.Error(err)
+				break
 			}
-			path, err = util.ParseString(ruleI, "Path")
-			tree, err = util.BuildTokenTree(path)
+			tmpEvents, err := parseEventTime(ruleI)
 			if err != nil {
 				b.Error(err)
 				break
 			}
 
+			window = tmpWindow
+			path, tree = tmpPath, tmpTree
+			events = tmpEvents
+			if restored != nil && window != 0 {
+				histogram = restored
+				restored = nil
+			}
+
 		case <-b.quit:
 			// quit the block
 			return
@@ -101,15 +133,28 @@ MainLoop:
 			}
 			v, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 
+			t := clock.Now()
+			if events != nil {
+				t, err = events.time(msg)
+				if err != nil {
+					b.Error(err, msg)
+					break
+				}
+				if events.observe(t) {
+					b.late <- msg
+					break
+				}
+			}
+
 			var valueString string
 
 			switch v := v.(type) {
 			default:
-				b.Error(errors.New("unexpected value type"))
+				b.Error(errors.New("unexpected value type"), msg)
 				continue MainLoop
 			case string:
 				valueString = v
@@ -124,7 +169,7 @@ MainLoop:
 			if pq, ok := histogram[valueString]; ok {
 				queueMessage := &PQMessage{
 					val: &emptyByte,
-					t:   time.Now(),
+					t:   t,
 				}
 				heap.Push(pq, queueMessage)
 			} else {
@@ -133,11 +178,11 @@ MainLoop:
 				histogram[valueString] = pq
 				queueMessage := &PQMessage{
 					val: &emptyByte,
-					t:   time.Now(),
+					t:   t,
 				}
 				heap.Push(pq, queueMessage)
 			}
-		case <-waitTimer.C:
+		case <-waitTimer.C():
 
 		case <-b.inpoll:
 			// deal with a poll request
@@ -145,18 +190,43 @@ MainLoop:
 			b.out <- data
 		case MsgChan := <-b.queryrule:
 			// deal with a query request
-			out := map[string]interface{}{
+			out := events.rule(map[string]interface{}{
 				"Window": window.String(),
 				"Path":   path,
-			}
+			})
 			MsgChan <- out
 		case MsgChan := <-b.historule:
 			data := buildHistogram(histogram)
 			MsgChan <- data
+		case c := <-b.snapshot:
+			buckets := make(map[string][]pqItem)
+			for k, pq := range histogram {
+				buckets[k] = snapshotPQ(pq)
+			}
+			state, err := json.Marshal(buckets)
+			if err != nil {
+				b.Error(err)
+			}
+			c <- state
+		case data := <-b.restore:
+			var buckets map[string][]pqItem
+			err := json.Unmarshal(data.([]byte), &buckets)
+			if err != nil {
+				b.Error(err)
+				continue
+			}
+			restored = map[string]*PriorityQueue{}
+			for k, items := range buckets {
+				restored[k] = restorePQ(items)
+			}
+			if window != 0 {
+				histogram = restored
+				restored = nil
+			}
 		}
 		for _, pq := range histogram {
 			for {
-				pqMsg, diff := pq.PeekAndShift(time.Now(), window)
+				pqMsg, diff := pq.PeekAndShift(events.now(clock), window)
 				if pqMsg == nil {
 					// either the queue is empty, or it's not time to emit
 					if diff == 0 {
@@ -0,0 +1,150 @@
+package library
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/nytlabs/streamtools/st/blocks" // blocks
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// hyperLogLog estimates how many distinct values it has seen, to within about
+// 1.04/sqrt(2^precision).
+type hyperLogLog struct {
+	precision uint
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint) *hyperLogLog {
+	return &hyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+func (h *hyperLogLog) add(v interface{}) error {
+	x := hashValue(v)
+	i := x >> (64 - h.precision)
+
+	// the rank is where the first 1 is in the rest of the hash.
+	rank := uint8(1)
+	for bit := uint64(1) << (63 - h.precision); bit > 0 && x&bit == 0; bit >>= 1 {
+		rank++
+	}
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+	return nil
+}
+
+func (h *hyperLogLog) merge(other sketch) error {
+	o, ok := other.(*hyperLogLog)
+	if !ok || o.precision != h.precision {
+		return errors.New("can only merge a HyperLogLog with the same Precision")
+	}
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+func (h *hyperLogLog) count() float64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	e := alpha * m * m / sum
+
+	// small counts are better estimated from how many registers are empty.
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return math.Floor(e + 0.5)
+}
+
+func (h *hyperLogLog) estimate() interface{} {
+	return map[string]interface{}{
+		"Count": h.count(),
+	}
+}
+
+func (h *hyperLogLog) state() interface{} {
+	return map[string]interface{}{
+		"Precision": float64(h.precision),
+		"Registers": base64.StdEncoding.EncodeToString(h.registers),
+	}
+}
+
+type hyperLogLogKind struct{}
+
+// we need to build a simple factory so that streamtools can make new blocks of this kind
+func NewHyperLogLog() blocks.BlockInterface {
+	return &Sketch{kind: hyperLogLogKind{}}
+}
+
+func (hyperLogLogKind) desc() string {
+	return "estimates the number of distinct values at a path, in bounded memory, with a HyperLogLog"
+}
+
+func parsePrecision(rule interface{}) (uint, error) {
+	precision := 14
+	if util.KeyExists(rule, "Precision") {
+		var err error
+		precision, err = util.ParseInt(rule, "Precision")
+		if err != nil {
+			return 0, err
+		}
+	}
+	if precision < 4 || precision > 18 {
+		return 0, errors.New(fmt.Sprintf("Precision must be between 4 and 18, not %d", precision))
+	}
+	return uint(precision), nil
+}
+
+func (hyperLogLogKind) parseRule(rule interface{}) (func() sketch, map[string]interface{}, error) {
+	precision, err := parsePrecision(rule)
+	if err != nil {
+		return nil, nil, err
+	}
+	newSketch := func() sketch {
+		return newHyperLogLog(precision)
+	}
+	return newSketch, map[string]interface{}{
+		"Precision": float64(precision),
+	}, nil
+}
+
+func (hyperLogLogKind) parseState(state interface{}) (sketch, error) {
+	if _, ok := state.(map[string]interface{}); !ok {
+		return nil, errors.New("the Sketch is not an object")
+	}
+	precision, err := parsePrecision(state)
+	if err != nil {
+		return nil, err
+	}
+	registers, err := util.ParseString(state, "Registers")
+	if err != nil {
+		return nil, err
+	}
+
+	h := newHyperLogLog(precision)
+	h.registers, err = base64.StdEncoding.DecodeString(registers)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.registers) != 1<<precision {
+		return nil, errors.New("the Sketch has the wrong number of Registers")
+	}
+	return h, nil
+}
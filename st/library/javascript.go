@@ -85,30 +85,30 @@ func (b *Javascript) Run() {
 
 			err := vm.Set(messageOut, map[string]interface{}{})
 			if err != nil {
-				b.Error(err)
+				b.Error(err, m)
 				break
 			}
 
 			err = vm.Set(messageIn, m)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, m)
 				break
 			}
 
 			_, err = vm.Run(program)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, m)
 				break
 			}
 
 			g, err := vm.Get(messageOut)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, m)
 				break
 			}
 			o, err := g.Export()
 			if err != nil {
-				b.Error(err)
+				b.Error(err, m)
 				break
 			}
 
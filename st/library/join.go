@@ -1,16 +1,80 @@
 package library
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nytlabs/gojee"
 	"github.com/nytlabs/streamtools/st/blocks" // blocks
+	"github.com/nytlabs/streamtools/st/util"
 )
 
+// joinEntry is a message waiting in a keyed join for a match from the other
+// input.
+type joinEntry struct {
+	key     string
+	msg     interface{}
+	t       time.Time
+	matched bool
+}
+
+// joinSide is the messages one input of a keyed join is holding on to, in the
+// order they arrived and by key.
+type joinSide struct {
+	entries []*joinEntry
+	byKey   map[string][]*joinEntry
+}
+
+func newJoinSide() *joinSide {
+	return &joinSide{
+		byKey: map[string][]*joinEntry{},
+	}
+}
+
+func (s *joinSide) add(e *joinEntry) {
+	s.entries = append(s.entries, e)
+	s.byKey[e.key] = append(s.byKey[e.key], e)
+}
+
+// expire takes out the messages that arrived by the cutoff.
+func (s *joinSide) expire(cutoff time.Time) []*joinEntry {
+	var expired []*joinEntry
+	for len(s.entries) > 0 && !s.entries[0].t.After(cutoff) {
+		e := s.entries[0]
+		s.entries = s.entries[1:]
+
+		// messages with a key arrive in the same order as all of them, so
+		// this one is first for its key too.
+		s.byKey[e.key] = s.byKey[e.key][1:]
+		if len(s.byKey[e.key]) == 0 {
+			delete(s.byKey, e.key)
+		}
+		expired = append(expired, e)
+	}
+	return expired
+}
+
+// endOfTime is later than any message can arrive.
+var endOfTime = time.Unix(1<<62, 0)
+
+// next is when the oldest message arrived.
+func (s *joinSide) next() (time.Time, bool) {
+	if len(s.entries) == 0 {
+		return time.Time{}, false
+	}
+	return s.entries[0].t, true
+}
+
 type Join struct {
 	blocks.Block
-	inA   blocks.MsgChan
-	inB   blocks.MsgChan
-	clear blocks.MsgChan
-	out   blocks.MsgChan
-	quit  blocks.MsgChan
+	queryrule chan blocks.MsgChan
+	inrule    blocks.MsgChan
+	inA       blocks.MsgChan
+	inB       blocks.MsgChan
+	clear     blocks.MsgChan
+	out       blocks.MsgChan
+	quit      blocks.MsgChan
 }
 
 func NewJoin() blocks.BlockInterface {
@@ -22,41 +86,267 @@ func (b *Join) Setup() {
 	b.Desc = "joins two streams together, emitting the joined message once it's been seen on both inputs"
 	b.inA = b.InRoute("inA")
 	b.inB = b.InRoute("inB")
+	b.inrule = b.InRoute("rule")
+	b.queryrule = b.QueryRoute("rule")
 	b.clear = b.InRoute("clear")
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
 }
 
+// keyed joins a message from one input with every message from the other
+// that has the same key, then holds on to it for messages yet to come.
+func (b *Join) keyed(tree *jee.TokenTree, msg interface{}, t time.Time, side, other *joinSide, maxBuffer int, fromA bool) {
+	k, err := jee.Eval(tree, msg)
+	if err != nil {
+		b.Error(err, msg)
+		return
+	}
+	if k == nil {
+		b.Error("the message has no key", msg)
+		return
+	}
+
+	if len(side.entries) >= maxBuffer {
+		if fromA {
+			b.Error("the A buffer is overflowing", msg)
+		} else {
+			b.Error("the B buffer is overflowing", msg)
+		}
+		return
+	}
+
+	e := &joinEntry{
+		key: groupKey(k),
+		msg: msg,
+		t:   t,
+	}
+
+	for _, match := range other.byKey[e.key] {
+		match.matched = true
+		e.matched = true
+		if fromA {
+			b.out <- map[string]interface{}{
+				"A": msg,
+				"B": match.msg,
+			}
+		} else {
+			b.out <- map[string]interface{}{
+				"A": match.msg,
+				"B": msg,
+			}
+		}
+	}
+
+	side.add(e)
+}
+
 func (b *Join) Run() {
+	clock := b.Clock()
+	waitTimer := clock.NewTimer(100 * time.Millisecond)
+
 	A := make(blocks.MsgChan, 1000)
 	B := make(blocks.MsgChan, 1000)
+
+	mode := "fifo"
+	joinType := "inner"
+	var keyA, keyB string
+	var treeA, treeB *jee.TokenTree
+	window := time.Duration(10) * time.Second
+	maxBuffer := 1000
+	sideA := newJoinSide()
+	sideB := newJoinSide()
+
+	// messages that arrived by the cutoff without a match are emitted on
+	// their own if the join type keeps them.
+	expire := func(cutoff time.Time) {
+		for _, e := range sideA.expire(cutoff) {
+			if !e.matched && joinType != "inner" {
+				b.out <- map[string]interface{}{
+					"A": e.msg,
+					"B": nil,
+				}
+			}
+		}
+		for _, e := range sideB.expire(cutoff) {
+			if !e.matched && joinType == "outer" {
+				b.out <- map[string]interface{}{
+					"A": nil,
+					"B": e.msg,
+				}
+			}
+		}
+	}
+
+	// drain empties the fifo queues.
+	drain := func() {
+		for {
+			select {
+			case <-A:
+			case <-B:
+			default:
+				return
+			}
+		}
+	}
+
+	// a new rule starts the join afresh, but first emits what the old one
+	// was holding on to, as if its window had run out.
+	reset := func() {
+		expire(endOfTime)
+		sideA = newJoinSide()
+		sideB = newJoinSide()
+		drain()
+	}
+
 	for {
 		select {
 		case <-b.quit:
 			return
+		case ruleI := <-b.inrule:
+			tmpMode := "fifo"
+			if util.KeyExists(ruleI, "Mode") {
+				m, err := util.ParseString(ruleI, "Mode")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if m != "" {
+					tmpMode = m
+				}
+			}
+			if tmpMode != "fifo" && tmpMode != "keyed" {
+				b.Error(errors.New("Mode must be fifo or keyed"))
+				break
+			}
+
+			if tmpMode == "fifo" {
+				reset()
+				mode = tmpMode
+				break
+			}
+
+			tmpKeyA, err := util.ParseRequiredString(ruleI, "KeyA")
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			tmpTreeA, err := util.BuildTokenTree(tmpKeyA)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			tmpKeyB, err := util.ParseRequiredString(ruleI, "KeyB")
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			tmpTreeB, err := util.BuildTokenTree(tmpKeyB)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+
+			windowString, err := util.ParseString(ruleI, "Window")
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			tmpWindow, err := time.ParseDuration(windowString)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			if tmpWindow <= 0 {
+				b.Error(errors.New("Window must be positive"))
+				break
+			}
+
+			tmpJoinType := "inner"
+			if util.KeyExists(ruleI, "JoinType") {
+				j, err := util.ParseString(ruleI, "JoinType")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if j != "" {
+					tmpJoinType = j
+				}
+			}
+			if tmpJoinType != "inner" && tmpJoinType != "left" && tmpJoinType != "outer" {
+				b.Error(errors.New("JoinType must be inner, left or outer"))
+				break
+			}
+
+			tmpMaxBuffer := 1000
+			if util.KeyExists(ruleI, "MaxBuffer") {
+				tmpMaxBuffer, err = util.ParseInt(ruleI, "MaxBuffer")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpMaxBuffer <= 0 {
+					b.Error(errors.New(fmt.Sprintf("MaxBuffer must be positive, not %d", tmpMaxBuffer)))
+					break
+				}
+			}
+
+			reset()
+			mode = tmpMode
+			keyA, treeA = tmpKeyA, tmpTreeA
+			keyB, treeB = tmpKeyB, tmpTreeB
+			window = tmpWindow
+			joinType = tmpJoinType
+			maxBuffer = tmpMaxBuffer
 		case msg := <-b.inA:
+			if mode == "keyed" {
+				expire(clock.Now().Add(-window))
+				b.keyed(treeA, msg, clock.Now(), sideA, sideB, maxBuffer, true)
+				break
+			}
 			select {
 			case A <- msg:
 			default:
-				b.Error("the A queue is overflowing")
+				b.Error("the A queue is overflowing", msg)
 			}
 		case msg := <-b.inB:
+			if mode == "keyed" {
+				expire(clock.Now().Add(-window))
+				b.keyed(treeB, msg, clock.Now(), sideB, sideA, maxBuffer, false)
+				break
+			}
 			select {
 			case B <- msg:
 			default:
-				b.Error("the B queue is overflowing")
+				b.Error("the B queue is overflowing", msg)
 			}
+		case <-waitTimer.C():
 		case <-b.clear:
-		Clear:
-			for {
-				select {
-				case <-A:
-				case <-B:
-				default:
-					break Clear
-				}
+			sideA = newJoinSide()
+			sideB = newJoinSide()
+			drain()
+		case c := <-b.queryrule:
+			c <- map[string]interface{}{
+				"Mode":      mode,
+				"KeyA":      keyA,
+				"KeyB":      keyB,
+				"Window":    window.String(),
+				"JoinType":  joinType,
+				"MaxBuffer": float64(maxBuffer),
 			}
 		}
+
+		if mode == "keyed" {
+			expire(clock.Now().Add(-window))
+			next, ok := sideA.next()
+			if t, okB := sideB.next(); okB && (!ok || t.Before(next)) {
+				next, ok = t, true
+			}
+			if ok {
+				waitTimer.Reset(next.Add(window).Sub(clock.Now()))
+			}
+			continue
+		}
+
 		for len(A) > 0 && len(B) > 0 {
 			b.out <- map[string]interface{}{
 				"A": <-A,
@@ -144,22 +144,22 @@ func (b *KullbackLeibler) Run() {
 			}
 			pI, err := jee.Eval(ptree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 			qI, err := jee.Eval(qtree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 			p, ok := newHistogram(pI)
 			if !ok {
-				b.Error(errors.New("p is not a Histogram"))
+				b.Error(errors.New("p is not a Histogram"), msg)
 				continue
 			}
 			q, ok := newHistogram(qI)
 			if !ok {
-				b.Error(errors.New("q is not a Histogram"))
+				b.Error(errors.New("q is not a Histogram"), msg)
 				continue
 			}
 			q.normalise(p)
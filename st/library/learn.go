@@ -1,6 +1,7 @@
 package library
 
 import (
+	"encoding/json"
 	"errors"
 
 	"github.com/jasoncapehart/go-sgd"          //sgd
@@ -14,6 +15,8 @@ type Learn struct {
 	queryrule chan blocks.MsgChan
 	inrule    blocks.MsgChan
 	inpoll    blocks.MsgChan
+	snapshot  chan blocks.MsgChan
+	restore   blocks.MsgChan
 	in        blocks.MsgChan
 	out       blocks.MsgChan
 	quit      blocks.MsgChan
@@ -31,6 +34,8 @@ func (b *Learn) Setup() {
 	b.inrule = b.InRoute("rule")
 	b.inpoll = b.InRoute("poll")
 	b.queryrule = b.QueryRoute("rule")
+	b.snapshot = b.SnapshotRoute()
+	b.restore = b.RestoreRoute()
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
 }
@@ -56,6 +61,10 @@ func (b *Learn) Run() {
 	var responsePath, lossfuncString, stepfuncString string
 	var featurePaths []string
 	var θ_0 []float64
+	var grad sgd.LossFunc
+	var step sgd.StepFunc
+	// learned weights from a snapshot wait here until a rule starts the kernel
+	var restored []float64
 	var featureTrees []*jee.TokenTree
 	var responseTree *jee.TokenTree
 	var err error
@@ -114,11 +123,12 @@ func (b *Learn) Run() {
 				b.Error(err)
 				break
 			}
-			grad, ok := lossfuncs[lossfuncString]
+			var ok bool
+			grad, ok = lossfuncs[lossfuncString]
 			if !ok {
 				b.Error(errors.New("Unknown loss function: " + lossfuncString))
 			}
-			step, ok := stepfuncs[stepfuncString]
+			step, ok = stepfuncs[stepfuncString]
 			if !ok {
 				b.Error(errors.New("Unknown step function: " + stepfuncString))
 			}
@@ -127,7 +137,16 @@ func (b *Learn) Run() {
 				b.Error(err)
 				break
 			}
-			go sgd.SgdKernel(dataChan, paramChan, stateChan, kernelQuitChan, grad, step, θ_0)
+			θ := θ_0
+			if restored != nil {
+				if len(restored) == len(θ_0) {
+					θ = restored
+				} else {
+					b.Error(errors.New("restored state does not match InitialState, discarding it"))
+				}
+				restored = nil
+			}
+			go sgd.SgdKernel(dataChan, paramChan, stateChan, kernelQuitChan, grad, step, θ)
 			kernelStarted = true
 
 		case <-b.quit:
@@ -144,24 +163,24 @@ func (b *Learn) Run() {
 			for i, tree := range featureTrees {
 				feature, err := jee.Eval(tree, msg)
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					break Loop
 				}
 				fi, ok := feature.(float64)
 				if !ok {
-					b.Error(errors.New("features must be float64"))
+					b.Error(errors.New("features must be float64"), msg)
 					break Loop
 				}
 				x[i] = fi
 			}
 			responseI, err := jee.Eval(responseTree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 			y, ok := responseI.(float64)
 			if !ok {
-				b.Error(errors.New("response must be float64"))
+				b.Error(errors.New("response must be float64"), msg)
 				break
 			}
 			d := sgd.Obs{
@@ -184,6 +203,39 @@ func (b *Learn) Run() {
 			b.out <- map[string]interface{}{
 				"params": params,
 			}
+		case c := <-b.snapshot:
+			var model []float64
+			if kernelStarted {
+				kernelMsgChan := make(chan []float64)
+				stateChan <- kernelMsgChan
+				model = <-kernelMsgChan
+			}
+			state, err := json.Marshal(model)
+			if err != nil {
+				b.Error(err)
+			}
+			c <- state
+		case data := <-b.restore:
+			var model []float64
+			err := json.Unmarshal(data.([]byte), &model)
+			if err != nil {
+				b.Error(err)
+				continue
+			}
+			if model == nil {
+				continue
+			}
+			if !kernelStarted {
+				restored = model
+				continue
+			}
+			if len(model) != len(θ_0) {
+				b.Error(errors.New("restored state does not match InitialState, discarding it"))
+				continue
+			}
+			// carry on learning from the restored weights
+			kernelQuitChan <- true
+			go sgd.SgdKernel(dataChan, paramChan, stateChan, kernelQuitChan, grad, step, model)
 		case c := <-b.queryrule:
 			c <- map[string]interface{}{
 				"Lossfunc":     lossfuncString,
@@ -7,11 +7,13 @@ import (
 )
 
 var Blocks = map[string]func() blocks.BlockInterface{
+	"aggregate":          NewAggregate,
 	"bang":               NewBang,
 	"cache":              NewCache,
 	"categorical":        NewCategorical,
 	"count":              NewCount,
 	"dedupe":             NewDeDupe,
+	"enrich":             NewEnrich,
 	"fft":                NewFFT,
 	"filter":             NewFilter,
 	"fromamqp":           NewFromAMQP,
@@ -28,6 +30,7 @@ var Blocks = map[string]func() blocks.BlockInterface{
 	"gaussian":           NewGaussian,
 	"gethttp":            NewGetHTTP,
 	"histogram":          NewHistogram,
+	"hyperloglog":        NewHyperLogLog,
 	"join":               NewJoin,
 	"kullbackleibler":    NewKullbackLeibler,
 	"learn":              NewLearn,
@@ -45,8 +48,12 @@ var Blocks = map[string]func() blocks.BlockInterface{
 	"javascript":         NewJavascript,
 	"queue":              NewQueue,
 	"redis":              NewRedis,
+	"sample":             NewSample,
 	"set":                NewSet,
+	"switch":             NewSwitch,
 	"sync":               NewSync,
+	"tdigest":            NewTDigest,
+	"throttle":           NewThrottle,
 	"ticker":             NewTicker,
 	"timeseries":         NewTimeseries,
 	"toamqp":             NewToAMQP,
@@ -61,19 +68,9 @@ var Blocks = map[string]func() blocks.BlockInterface{
 	"tomongodb":          NewToMongoDB,
 	"tonsq":              NewToNSQ,
 	"tonsqmulti":         NewToNSQMulti,
+	"topk":               NewTopK,
 	"unpack":             NewUnpack,
 	"webRequest":         NewWebRequest,
 	"zipf":               NewZipf,
 	"exponential":        NewExponential,
 }
-
-var BlockDefs = map[string]*blocks.BlockDef{}
-
-func Start() {
-	for k, newBlock := range Blocks {
-		b := newBlock()
-		b.Build(blocks.BlockChans{nil, nil, nil, nil, nil, nil, nil, nil})
-		b.Setup()
-		BlockDefs[k] = b.GetDef()
-	}
-}
@@ -8,11 +8,13 @@ var Blocks = map[string]func() blocks.BlockInterface{
 	"analogPin":          NewAnalogPin,
 	"digitalpin":         NewDigitalPin,
 	"todigitalpin":       NewToDigitalPin,
+	"aggregate":          NewAggregate,
 	"bang":               NewBang,
 	"cache":              NewCache,
 	"categorical":        NewCategorical,
 	"count":              NewCount,
 	"dedupe":             NewDeDupe,
+	"enrich":             NewEnrich,
 	"fft":                NewFFT,
 	"filter":             NewFilter,
 	"fromamqp":           NewFromAMQP,
@@ -29,6 +31,7 @@ var Blocks = map[string]func() blocks.BlockInterface{
 	"gaussian":           NewGaussian,
 	"gethttp":            NewGetHTTP,
 	"histogram":          NewHistogram,
+	"hyperloglog":        NewHyperLogLog,
 	"join":               NewJoin,
 	"kullbackleibler":    NewKullbackLeibler,
 	"learn":              NewLearn,
@@ -46,8 +49,12 @@ var Blocks = map[string]func() blocks.BlockInterface{
 	"javascript":         NewJavascript,
 	"queue":              NewQueue,
 	"redis":              NewRedis,
+	"sample":             NewSample,
 	"set":                NewSet,
+	"switch":             NewSwitch,
 	"sync":               NewSync,
+	"tdigest":            NewTDigest,
+	"throttle":           NewThrottle,
 	"ticker":             NewTicker,
 	"timeseries":         NewTimeseries,
 	"toamqp":             NewToAMQP,
@@ -62,19 +69,9 @@ var Blocks = map[string]func() blocks.BlockInterface{
 	"tomongodb":          NewToMongoDB,
 	"tonsq":              NewToNSQ,
 	"tonsqmulti":         NewToNSQMulti,
+	"topk":               NewTopK,
 	"unpack":             NewUnpack,
 	"webRequest":         NewWebRequest,
 	"zipf":               NewZipf,
 	"exponential":        NewExponential,
 }
-
-var BlockDefs = map[string]*blocks.BlockDef{}
-
-func Start() {
-	for k, newBlock := range Blocks {
-		b := newBlock()
-		b.Build(blocks.BlockChans{nil, nil, nil, nil, nil, nil, nil, nil})
-		b.Setup()
-		BlockDefs[k] = b.GetDef()
-	}
-}
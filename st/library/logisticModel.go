@@ -86,12 +86,12 @@ func (b *LogisticModel) Run() {
 			for i, tree := range featureTrees {
 				feature, err := jee.Eval(tree, msg)
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					break Loop
 				}
 				fi, ok := feature.(float64)
 				if !ok {
-					b.Error(errors.New("features must be float64"))
+					b.Error(errors.New("features must be float64"), msg)
 					break Loop
 				}
 				x[i] = fi
@@ -107,6 +107,24 @@ func (b *Map) Setup() {
 	b.out = b.Broadcast()
 }
 
+// CheckRule makes sure the rule has a Map whose values are expressions.
+func (b *Map) CheckRule(ruleI interface{}) error {
+	rule, ok := ruleI.(map[string]interface{})
+	if !ok {
+		return errors.New("could not assert rule to map[string]interface{}")
+	}
+	mapRuleI, ok := rule["Map"]
+	if !ok {
+		return errors.New("could not find Map in rule")
+	}
+	mapRule, ok := mapRuleI.(map[string]interface{})
+	if !ok {
+		return errors.New("Map must be an object")
+	}
+	_, err := parseKeys(mapRule)
+	return err
+}
+
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *Map) Run() {
 	additive := true
@@ -155,7 +173,7 @@ func (b *Map) Run() {
 			in := msg.(map[string]interface{})
 			evaled, err := evalMap(parsed.(map[string]interface{}), in)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 			}
 
 			for k, _ := range evaled {
@@ -19,6 +19,7 @@ type MovingAverage struct {
 	inpoll    blocks.MsgChan
 	in        blocks.MsgChan
 	out       blocks.MsgChan
+	late      blocks.MsgChan
 	quit      blocks.MsgChan
 }
 
@@ -38,6 +39,7 @@ func (b *MovingAverage) Setup() {
 	b.inpoll = b.InRoute("poll")
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
+	b.late = b.OutRoute("late")
 }
 
 func pqAverage(pq *PriorityQueue) float64 {
@@ -59,9 +61,10 @@ func pqAverage(pq *PriorityQueue) float64 {
 func (b *MovingAverage) Run() {
 	var tree *jee.TokenTree
 	var path, windowString string
-	var err error
 	window := time.Duration(0)
-	waitTimer := time.NewTimer(100 * time.Millisecond)
+	var events *eventTime
+	clock := b.Clock()
+	waitTimer := clock.NewTimer(100 * time.Millisecond)
 
 	pq := &PriorityQueue{}
 	heap.Init(pq)
@@ -70,23 +73,35 @@ func (b *MovingAverage) Run() {
 		select {
 		case ruleI := <-b.inrule:
 			// set a parameter of the block
-			path, err = util.ParseString(ruleI, "Path")
+			tmpPath, err := util.ParseString(ruleI, "Path")
 			if err != nil {
 				b.Error(err)
+				break
 			}
-			tree, err = util.BuildTokenTree(path)
+			tmpTree, err := util.BuildTokenTree(tmpPath)
 			if err != nil {
 				b.Error(err)
 				break
 			}
-			windowString, err = util.ParseString(ruleI, "Window")
+			tmpWindowString, err := util.ParseString(ruleI, "Window")
 			if err != nil {
 				b.Error(err)
+				break
 			}
-			window, err = time.ParseDuration(windowString)
+			tmpWindow, err := time.ParseDuration(tmpWindowString)
 			if err != nil {
 				b.Error(err)
+				break
+			}
+			tmpEvents, err := parseEventTime(ruleI)
+			if err != nil {
+				b.Error(err)
+				break
 			}
+
+			path, tree = tmpPath, tmpTree
+			windowString, window = tmpWindowString, tmpWindow
+			events = tmpEvents
 		case <-b.quit:
 			// quit the block
 			return
@@ -97,19 +112,31 @@ func (b *MovingAverage) Run() {
 			}
 			val, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 			// TODO make this a type swtich and convert anything we can to a
 			// float
 			val, ok := val.(float64)
 			if !ok {
-				b.Error(errors.New("trying to put a non-float into the moving average"))
+				b.Error(errors.New("trying to put a non-float into the moving average"), msg)
 				continue
 			}
+			t := clock.Now()
+			if events != nil {
+				t, err = events.time(msg)
+				if err != nil {
+					b.Error(err, msg)
+					continue
+				}
+				if events.observe(t) {
+					b.late <- msg
+					continue
+				}
+			}
 			queueMessage := &PQMessage{
 				val: val,
-				t:   time.Now(),
+				t:   t,
 			}
 			heap.Push(pq, queueMessage)
 		case <-b.inpoll:
@@ -125,14 +152,14 @@ func (b *MovingAverage) Run() {
 			c <- outMsg
 		case c := <-b.queryrule:
 			// deal with a query request
-			c <- map[string]interface{}{
+			c <- events.rule(map[string]interface{}{
 				"Path":   path,
 				"Window": windowString,
-			}
-		case <-waitTimer.C:
+			})
+		case <-waitTimer.C():
 		}
 		for {
-			pqMsg, diff := pq.PeekAndShift(time.Now(), window)
+			pqMsg, diff := pq.PeekAndShift(events.now(clock), window)
 			if pqMsg == nil {
 				// either the queue is empty, or it's not time to emit
 				if diff == 0 {
@@ -1,6 +1,7 @@
 package library
 
 import (
+	"container/heap"
 	"time"
 
 	"github.com/nytlabs/streamtools/st/blocks" // blocks
@@ -16,6 +17,7 @@ type PackByInterval struct {
 	flush     blocks.MsgChan
 	in        blocks.MsgChan
 	out       blocks.MsgChan
+	late      blocks.MsgChan
 	quit      blocks.MsgChan
 }
 
@@ -35,17 +37,48 @@ func (b *PackByInterval) Setup() {
 	b.flush = b.InRoute("flush")
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
+	b.late = b.OutRoute("late")
+}
+
+// emitWindows emits a pack for each interval of event time, in order, that
+// ends by until. If all is set it emits every interval there is a message in.
+func (b *PackByInterval) emitWindows(pq *PriorityQueue, interval time.Duration, until time.Time, all bool) {
+	for pq.Len() > 0 {
+		end := (*pq)[0].t.Truncate(interval).Add(interval)
+		if !all && end.After(until) {
+			return
+		}
+
+		var pack []interface{}
+		for pq.Len() > 0 && (*pq)[0].t.Before(end) {
+			pack = append(pack, heap.Pop(pq).(*PQMessage).val)
+		}
+
+		b.out <- map[string]interface{}{
+			"Pack": pack,
+		}
+	}
 }
 
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *PackByInterval) Run() {
 	var batch []interface{}
 
+	// going by event time, messages wait here until the watermark passes the
+	// end of their interval.
+	var events *eventTime
+	pq := &PriorityQueue{}
+	heap.Init(pq)
+
 	interval := time.Duration(1) * time.Second
-	ticker := time.NewTicker(interval)
+	clock := b.Clock()
+	ticker := clock.NewTicker(interval)
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
+			if events != nil {
+				break
+			}
 			b.out <- map[string]interface{}{
 				"Pack": batch,
 			}
@@ -69,26 +102,58 @@ func (b *PackByInterval) Run() {
 				break
 			}
 
+			tmpEvents, err := parseEventTime(ruleI)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+
 			interval = dur
+			events = tmpEvents
+			pq = &PriorityQueue{}
 			ticker.Stop()
-			ticker = time.NewTicker(interval)
+			ticker = clock.NewTicker(interval)
 			batch = nil
 		case <-b.quit:
 			// quit the block
 			return
 		case m := <-b.in:
-			batch = append(batch, m)
+			if events == nil {
+				batch = append(batch, m)
+				break
+			}
+
+			t, err := events.time(m)
+			if err != nil {
+				b.Error(err, m)
+				break
+			}
+			if events.observe(t) {
+				b.late <- m
+				break
+			}
+
+			heap.Push(pq, &PQMessage{
+				val: m,
+				t:   t,
+			})
+			b.emitWindows(pq, interval, events.watermark, false)
 		case <-b.clear:
 			batch = nil
+			pq = &PriorityQueue{}
 		case <-b.flush:
+			if events != nil {
+				b.emitWindows(pq, interval, events.watermark, true)
+				break
+			}
 			b.out <- map[string]interface{}{
 				"Pack": batch,
 			}
 			batch = nil
 		case r := <-b.queryrule:
-			r <- map[string]interface{}{
+			r <- events.rule(map[string]interface{}{
 				"Interval": interval.String(),
-			}
+			})
 		}
 	}
 }
@@ -38,19 +38,20 @@ func (b *PackByValue) Setup() {
 
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *PackByValue) Run() {
+	clock := b.Clock()
 	var tree *jee.TokenTree
 	var emitAfter, path string
 	var err error
 
 	afterDuration := time.Duration(0)
-	waitTimer := time.NewTimer(100 * time.Millisecond)
+	waitTimer := clock.NewTimer(100 * time.Millisecond)
 	bunches := make(map[string][]interface{})
 	pq := &PriorityQueue{}
 	heap.Init(pq)
 
 	for {
 		select {
-		case <-waitTimer.C:
+		case <-waitTimer.C():
 		case ruleI := <-b.inrule:
 			// set a parameter of the block
 			rule, ok := ruleI.(map[string]interface{})
@@ -95,12 +96,12 @@ func (b *PackByValue) Run() {
 
 			id, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			idStr, ok := id.(string)
 			if !ok {
-				b.Error(errors.New("could not assert id to string"))
+				b.Error(errors.New("could not assert id to string"), msg)
 				break
 			}
 			if len(bunches[idStr]) > 0 {
@@ -116,7 +117,7 @@ func (b *PackByValue) Run() {
 
 			queueMessage := &PQMessage{
 				val: val,
-				t:   time.Now(),
+				t:   clock.Now(),
 			}
 			heap.Push(pq, queueMessage)
 		case c := <-b.queryrule:
@@ -127,7 +128,7 @@ func (b *PackByValue) Run() {
 			}
 		}
 		for {
-			pqMsg, diff := pq.PeekAndShift(time.Now(), afterDuration)
+			pqMsg, diff := pq.PeekAndShift(clock.Now(), afterDuration)
 			if pqMsg == nil {
 				// either the queue is empty, or it's not time to emit
 				waitTimer.Reset(diff)
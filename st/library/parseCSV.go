@@ -85,7 +85,7 @@ func (b *ParseCSV) Run() {
 
 			dataI, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 
@@ -97,7 +97,7 @@ func (b *ParseCSV) Run() {
 				data = value
 
 			default:
-				b.Error("data should be a string or a []byte")
+				b.Error("data should be a string or a []byte", msg)
 				continue
 			}
 
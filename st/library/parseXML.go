@@ -71,7 +71,7 @@ func (b *ParseXML) Run() {
 			}
 			dataI, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 
@@ -83,7 +83,7 @@ func (b *ParseXML) Run() {
 				xmlData = []byte(v)
 
 			default:
-				b.Error("data should be a string or a []byte")
+				b.Error("data should be a string or a []byte", msg)
 				continue
 			}
 
@@ -91,21 +91,21 @@ func (b *ParseXML) Run() {
 			// http://godoc.org/github.com/clbanning/mxj#NewMapXml
 			mapVal, err := mxj.NewMapXml(xmlData)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 
 			// TODO: replace this json.Marshal / Unmarshal dance with Nik's recursive map copy from the map block
 			outMsg, err := json.Marshal(mapVal)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 
 			var newMsg interface{}
 			err = json.Unmarshal(outMsg, &newMsg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 
@@ -0,0 +1,386 @@
+package library
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+)
+
+// PluginDef is what a plugin says about itself on the first line it writes.
+type PluginDef struct {
+	Type        string
+	Desc        string
+	InRoutes    []string
+	QueryRoutes []string
+	OutRoutes   []string
+}
+
+// PluginMsg is a line of the plugin protocol. streamtools sends messages that
+// arrive on an in route as {"Route": "in", "Msg": ...} and queries as
+// {"Query": "rule", "Id": 1}. Plugins emit messages as {"Route": "out", "Msg":
+// ...}, answer queries as {"Id": 1, "Msg": ...}, report errors as {"Error":
+// "...", "Msg": ...} and log with {"Log": ...}.
+type PluginMsg struct {
+	Route string      `json:",omitempty"`
+	Query string      `json:",omitempty"`
+	Id    int64       `json:",omitempty"`
+	Msg   interface{} `json:",omitempty"`
+	Error string      `json:",omitempty"`
+	Log   interface{} `json:",omitempty"`
+}
+
+// Plugins maps the type of each plugin block to its executable.
+var Plugins = map[string]string{}
+
+// PluginTimeout is how long a plugin has to describe itself, and to exit once
+// its stdin is closed.
+var PluginTimeout = 5 * time.Second
+
+// readLine reads a line of the plugin protocol.
+func readLine(r *bufio.Reader, v interface{}) error {
+	line, err := r.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
+
+// describePlugin starts the executable at path just long enough to read its
+// definition.
+func describePlugin(path string) (*PluginDef, error) {
+	cmd := exec.Command(path)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	described := make(chan error, 1)
+	def := &PluginDef{}
+	go func() {
+		described <- readLine(bufio.NewReader(stdout), def)
+	}()
+
+	select {
+	case err = <-described:
+	case <-time.After(PluginTimeout):
+		err = errors.New("timed out waiting for its definition")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if def.Type == "" {
+		return nil, errors.New("its definition has no type")
+	}
+	return def, nil
+}
+
+// LoadPlugins adds a block type to Blocks for every executable in dir. It
+// must be called before Start.
+func LoadPlugins(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || f.Mode()&0111 == 0 {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+		def, err := describePlugin(path)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Cannot load plugin %s: %s", path, err.Error()))
+		}
+
+		err = Register(def.Type, func() blocks.BlockInterface {
+			return &Plugin{
+				path: path,
+				def:  def,
+			}
+		})
+		if err != nil {
+			return errors.New(fmt.Sprintf("Cannot load plugin %s: %s", path, err.Error()))
+		}
+		Plugins[def.Type] = path
+		packages[def.Type] = path
+	}
+
+	return nil
+}
+
+// pluginQuery is a query waiting to be sent to the plugin.
+type pluginQuery struct {
+	route string
+	c     blocks.MsgChan
+}
+
+// Plugin is a block that runs in its own process. If the process dies, Run
+// panics, so that the block is restarted like any other block that crashes.
+type Plugin struct {
+	blocks.Block
+	path    string
+	def     *PluginDef
+	ins     map[string]blocks.MsgChan
+	queries map[string]chan blocks.MsgChan
+	outs    map[string]blocks.MsgChan
+	quit    blocks.MsgChan
+	started bool
+	gone    chan bool // closed when the block quits
+	feed    chan *blocks.Msg
+	ask     chan *pluginQuery
+	lastId  int64
+}
+
+func (b *Plugin) Setup() {
+	b.Kind = "Plugin"
+	b.Desc = b.def.Desc
+	b.ins = make(map[string]blocks.MsgChan)
+	b.queries = make(map[string]chan blocks.MsgChan)
+	b.outs = make(map[string]blocks.MsgChan)
+	for _, name := range b.def.InRoutes {
+		b.ins[name] = b.InRoute(name)
+	}
+	for _, name := range b.def.QueryRoutes {
+		b.queries[name] = b.QueryRoute(name)
+	}
+	for _, name := range b.def.OutRoutes {
+		b.outs[name] = b.OutRoute(name)
+	}
+	b.quit = b.Quit()
+}
+
+// start moves messages and queries from the block's routes to Run. It is only
+// done once, as the routes outlive restarts of Run.
+func (b *Plugin) start() {
+	b.gone = make(chan bool)
+	b.feed = make(chan *blocks.Msg)
+	b.ask = make(chan *pluginQuery)
+
+	for name, in := range b.ins {
+		go func(name string, in blocks.MsgChan) {
+			for msg := range in {
+				select {
+				case b.feed <- &blocks.Msg{Msg: msg, Route: name}:
+				case <-b.gone:
+					return
+				}
+			}
+		}(name, in)
+	}
+
+	for name, query := range b.queries {
+		go func(name string, query chan blocks.MsgChan) {
+			for c := range query {
+				select {
+				case b.ask <- &pluginQuery{name, c}:
+				case <-b.gone:
+					return
+				}
+			}
+		}(name, query)
+	}
+}
+
+// pluginProc is a running plugin process.
+type pluginProc struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	send   chan *PluginMsg
+	lines  chan *PluginMsg
+	exited chan error
+	done   chan bool
+}
+
+// spawn starts the plugin process.
+func (b *Plugin) spawn() (*pluginProc, error) {
+	p := &pluginProc{
+		cmd:    exec.Command(b.path),
+		send:   make(chan *PluginMsg, 1000),
+		lines:  make(chan *PluginMsg),
+		exited: make(chan error, 1),
+		done:   make(chan bool),
+	}
+
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := p.cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	p.stdin = stdin
+
+	err = p.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	// stdin is written to on its own, so that a plugin busy writing to us
+	// never waits for us to finish writing to it. Once the plugin stops
+	// listening, what we send is thrown away until it is noticed that it has
+	// exited.
+	go func() {
+		enc := json.NewEncoder(stdin)
+		for {
+			select {
+			case msg := <-p.send:
+				if enc.Encode(msg) != nil {
+					enc = json.NewEncoder(ioutil.Discard)
+				}
+			case <-p.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		r := bufio.NewReader(stdout)
+
+		// the first line is the plugin's definition, which we already have
+		var def PluginDef
+		err := readLine(r, &def)
+		for err == nil {
+			msg := &PluginMsg{}
+			err = readLine(r, msg)
+			if _, ok := err.(*json.SyntaxError); ok {
+				b.Error(errors.New("plugin wrote a line that is not JSON"))
+				err = nil
+				continue
+			}
+			if err != nil {
+				break
+			}
+			select {
+			case p.lines <- msg:
+			case <-p.done:
+				io.Copy(ioutil.Discard, r)
+				err = io.EOF
+			}
+		}
+		p.exited <- p.cmd.Wait()
+	}()
+
+	go func() {
+		s := bufio.NewScanner(stderr)
+		for s.Scan() {
+			b.Log(s.Text())
+		}
+	}()
+
+	return p, nil
+}
+
+// stop closes the plugin's stdin and waits for it to exit, killing it if it
+// doesn't.
+func (p *pluginProc) stop() {
+	close(p.done)
+	p.stdin.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(PluginTimeout):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+}
+
+// reply answers a query, unless whoever asked has given up waiting.
+func reply(c blocks.MsgChan, msg interface{}) {
+	select {
+	case c <- msg:
+	default:
+	}
+}
+
+func (b *Plugin) Run() {
+	if !b.started {
+		b.start()
+		b.started = true
+	}
+
+	p, err := b.spawn()
+	if err != nil {
+		panic(fmt.Sprintf("could not start plugin %s: %s", b.path, err.Error()))
+	}
+
+	pending := make(map[int64]blocks.MsgChan)
+
+	for {
+		select {
+		case msg := <-b.feed:
+			p.send <- &PluginMsg{
+				Route: msg.Route,
+				Msg:   msg.Msg,
+			}
+		case q := <-b.ask:
+			b.lastId++
+			pending[b.lastId] = q.c
+			p.send <- &PluginMsg{
+				Query: q.route,
+				Id:    b.lastId,
+			}
+		case msg := <-p.lines:
+			switch {
+			case msg.Error != "":
+				if msg.Msg != nil {
+					b.Error(errors.New(msg.Error), msg.Msg)
+				} else {
+					b.Error(errors.New(msg.Error))
+				}
+			case msg.Log != nil:
+				b.Log(msg.Log)
+			case msg.Id != 0:
+				c, ok := pending[msg.Id]
+				if !ok {
+					break
+				}
+				delete(pending, msg.Id)
+				reply(c, msg.Msg)
+			default:
+				out, ok := b.outs[msg.Route]
+				if !ok {
+					b.Error(errors.New(fmt.Sprintf("plugin emitted on unknown route %s", msg.Route)))
+					break
+				}
+				out <- msg.Msg
+			}
+		case err := <-p.exited:
+			for _, c := range pending {
+				reply(c, map[string]interface{}{
+					"Error": "plugin exited",
+				})
+			}
+			close(p.done)
+			if err == nil {
+				err = errors.New("exited")
+			}
+			panic(fmt.Sprintf("plugin %s: %s", b.path, err.Error()))
+		case <-b.quit:
+			close(b.gone)
+			p.stop()
+			return
+		}
+	}
+}
@@ -68,3 +68,34 @@ func (pq *PriorityQueue) PeekAndShift(max time.Time, lag time.Duration) (interfa
 
 	return nil, lag - max.Sub(item.t)
 }
+
+// pqItem is how a PQMessage is written to a snapshot.
+type pqItem struct {
+	Val interface{}
+	T   time.Time
+}
+
+// snapshotPQ lists the messages in the queue so that it can be saved.
+func snapshotPQ(pq *PriorityQueue) []pqItem {
+	items := make([]pqItem, len(*pq))
+	for i, m := range *pq {
+		items[i] = pqItem{
+			Val: m.val,
+			T:   m.t,
+		}
+	}
+	return items
+}
+
+// restorePQ builds a queue from a snapshot taken by snapshotPQ.
+func restorePQ(items []pqItem) *PriorityQueue {
+	pq := &PriorityQueue{}
+	heap.Init(pq)
+	for _, item := range items {
+		heap.Push(pq, &PQMessage{
+			val: item.Val,
+			t:   item.T,
+		})
+	}
+	return pq
+}
@@ -2,7 +2,7 @@ package library
 
 import (
 	"container/heap"
-	"time"
+	"encoding/json"
 
 	"github.com/nytlabs/streamtools/st/blocks" // blocks
 )
@@ -14,6 +14,8 @@ type Queue struct {
 	queryPeek chan blocks.MsgChan
 	inPush    blocks.MsgChan
 	inPop     blocks.MsgChan
+	snapshot  chan blocks.MsgChan
+	restore   blocks.MsgChan
 	out       blocks.MsgChan
 	quit      blocks.MsgChan
 }
@@ -31,12 +33,15 @@ func (b *Queue) Setup() {
 	b.inPop = b.InRoute("pop")
 	b.queryPop = b.QueryRoute("pop")
 	b.queryPeek = b.QueryRoute("peek")
+	b.snapshot = b.SnapshotRoute()
+	b.restore = b.RestoreRoute()
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
 }
 
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *Queue) Run() {
+	clock := b.Clock()
 	pq := &PriorityQueue{}
 	heap.Init(pq)
 	for {
@@ -47,7 +52,7 @@ func (b *Queue) Run() {
 		case msg := <-b.inPush:
 			queueMessage := &PQMessage{
 				val: msg,
-				t:   time.Now(),
+				t:   clock.Now(),
 			}
 			heap.Push(pq, queueMessage)
 		case <-b.inPop:
@@ -68,6 +73,20 @@ func (b *Queue) Run() {
 				msg = pq.Peek().(*PQMessage).val
 			}
 			MsgChan <- msg
+		case c := <-b.snapshot:
+			state, err := json.Marshal(snapshotPQ(pq))
+			if err != nil {
+				b.Error(err)
+			}
+			c <- state
+		case data := <-b.restore:
+			var items []pqItem
+			err := json.Unmarshal(data.([]byte), &items)
+			if err != nil {
+				b.Error(err)
+				continue
+			}
+			pq = restorePQ(items)
 		}
 	}
 }
@@ -151,7 +151,7 @@ func (b *Redis) Run() {
 			return
 		case msg := <-b.in:
 			if pool == nil {
-				b.Error("not connected to redis")
+				b.Error("not connected to redis", msg)
 				break
 			}
 
@@ -161,7 +161,7 @@ func (b *Redis) Run() {
 			for i, tree := range argumentTrees {
 				argument, err := jee.Eval(tree, msg)
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					break
 				}
 				args[i] = argument
@@ -170,7 +170,7 @@ func (b *Redis) Run() {
 			// commands like 'KEYS *' or 'SET NUMBERS 1'
 			reply, err := conn.Do(command, args...)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 
@@ -0,0 +1,67 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+)
+
+var BlockDefs = map[string]*blocks.BlockDef{}
+
+// packages holds where block types that don't come from a Go package, like
+// plugins, come from.
+var packages = map[string]string{}
+
+var started bool
+
+// Register adds a block type to Blocks, so that blocks in other packages can
+// be built into st. It is meant to be called from the init function of the
+// package the block is in, and must be called before Start: once the library
+// has started, Blocks and BlockDefs are read without locking.
+func Register(name string, newBlock func() blocks.BlockInterface) error {
+	if started {
+		return errors.New(fmt.Sprintf("Cannot register %s: the library has already started", name))
+	}
+
+	if name == "" {
+		return errors.New("Cannot register block: it needs a name")
+	}
+
+	if newBlock == nil {
+		return errors.New(fmt.Sprintf("Cannot register %s: no constructor", name))
+	}
+
+	if _, ok := Blocks[name]; ok {
+		return errors.New(fmt.Sprintf("Cannot register %s: block type already exists", name))
+	}
+
+	Blocks[name] = newBlock
+	return nil
+}
+
+// define describes a block type, noting the package it comes from.
+func define(name string, newBlock func() blocks.BlockInterface) *blocks.BlockDef {
+	b := newBlock()
+	b.Build(blocks.BlockChans{nil, nil, nil, nil, nil, nil, nil, nil})
+	b.Setup()
+
+	def := b.GetDef()
+	def.Package = packages[name]
+	if def.Package == "" {
+		t := reflect.TypeOf(b)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		def.Package = t.PkgPath()
+	}
+	return def
+}
+
+func Start() {
+	for k, newBlock := range Blocks {
+		BlockDefs[k] = define(k, newBlock)
+	}
+	started = true
+}
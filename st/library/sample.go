@@ -0,0 +1,267 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/nytlabs/gojee"
+	"github.com/nytlabs/streamtools/st/blocks" // blocks
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// reservoir keeps a uniform sample of up to k of the messages it has seen.
+type reservoir struct {
+	k      int
+	seen   int64
+	sample []interface{}
+	start  time.Time // the start of the window it samples, or zero
+}
+
+func newReservoir(k int) *reservoir {
+	return &reservoir{
+		k:      k,
+		sample: []interface{}{},
+	}
+}
+
+// add considers a message for the sample. The nth message seen replaces one
+// already in the sample with probability k/n.
+func (r *reservoir) add(msg interface{}, rnd *rand.Rand) {
+	r.seen++
+	if len(r.sample) < r.k {
+		r.sample = append(r.sample, msg)
+		return
+	}
+	if i := rnd.Int63n(r.seen); i < int64(r.k) {
+		r.sample[i] = msg
+	}
+}
+
+func (r *reservoir) result(window time.Duration) map[string]interface{} {
+	sample := make([]interface{}, len(r.sample))
+	copy(sample, r.sample)
+	result := map[string]interface{}{
+		"Sample": sample,
+		"Seen":   float64(r.seen),
+	}
+	if window > 0 && !r.start.IsZero() {
+		result["Start"] = r.start.Format(time.RFC3339Nano)
+		result["End"] = r.start.Add(window).Format(time.RFC3339Nano)
+	}
+	return result
+}
+
+// inHashSample says whether a key is in a hash sample: it is if its hash
+// falls in the first probability of all hashes, so that a key is always in
+// or always out.
+func inHashSample(key interface{}, probability float64) bool {
+	return probability >= 1 || float64(hashValue(key)) < math.Ldexp(probability, 64)
+}
+
+// specify those channels we're going to use to communicate with streamtools
+type Sample struct {
+	blocks.Block
+	queryrule      chan blocks.MsgChan
+	queryreservoir chan blocks.MsgChan
+	inrule         blocks.MsgChan
+	inpoll         blocks.MsgChan
+	clear          blocks.MsgChan
+	in             blocks.MsgChan
+	out            blocks.MsgChan
+	quit           blocks.MsgChan
+}
+
+// we need to build a simple factory so that streamtools can make new blocks of this kind
+func NewSample() blocks.BlockInterface {
+	return &Sample{}
+}
+
+// Setup is called once before running the block. We build up the channels and specify what kind of block this is.
+func (b *Sample) Setup() {
+	b.Kind = "Stats"
+	b.Desc = "emits a random sample of messages, each with some Probability or by the hash of the value at KeyPath, or keeps a reservoir of K of them in each Window"
+	b.in = b.InRoute("in")
+	b.inrule = b.InRoute("rule")
+	b.inpoll = b.InRoute("poll")
+	b.clear = b.InRoute("clear")
+	b.queryrule = b.QueryRoute("rule")
+	b.queryreservoir = b.QueryRoute("reservoir")
+	b.quit = b.Quit()
+	b.out = b.Broadcast()
+}
+
+// Run is the block's main loop. Here we listen on the different channels we set up.
+func (b *Sample) Run() {
+	clock := b.Clock()
+	waitTimer := clock.NewTimer(100 * time.Millisecond)
+	rnd := rand.New(rand.NewSource(clock.Now().UnixNano()))
+
+	mode := "bernoulli"
+	probability := 0.1
+	k := 100
+	var keyPath, windowString string
+	var window time.Duration
+	var tree *jee.TokenTree
+	r := newReservoir(k)
+
+	// a windowed reservoir is emitted when its window ends, and a new one is
+	// started with the next message.
+	closeWindow := func(now time.Time) {
+		if window == 0 || r.start.IsZero() || now.Before(r.start.Add(window)) {
+			return
+		}
+		b.out <- r.result(window)
+		r = newReservoir(k)
+	}
+
+	for {
+		select {
+		case ruleI := <-b.inrule:
+			tmpMode := "bernoulli"
+			var err error
+			if util.KeyExists(ruleI, "Mode") {
+				tmpMode, err = util.ParseString(ruleI, "Mode")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpMode != "bernoulli" && tmpMode != "hash" && tmpMode != "reservoir" {
+				b.Error(errors.New(fmt.Sprintf("Mode must be bernoulli, hash or reservoir, not %s", tmpMode)))
+				break
+			}
+
+			tmpProbability := 0.1
+			if util.KeyExists(ruleI, "Probability") {
+				tmpProbability, err = util.ParseFloat(ruleI, "Probability")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpProbability < 0 || tmpProbability > 1 {
+					b.Error(errors.New("Probability must be between 0 and 1"))
+					break
+				}
+			}
+
+			var tmpKeyPath string
+			var tmpTree *jee.TokenTree
+			if util.KeyExists(ruleI, "KeyPath") {
+				tmpKeyPath, err = util.ParseString(ruleI, "KeyPath")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpKeyPath != "" {
+				tmpTree, err = util.BuildTokenTree(tmpKeyPath)
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpMode == "hash" && tmpTree == nil {
+				b.Error(errors.New("hash sampling needs a KeyPath"))
+				break
+			}
+
+			tmpK := 100
+			if util.KeyExists(ruleI, "K") {
+				tmpK, err = util.ParseInt(ruleI, "K")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpK < 1 {
+					b.Error(errors.New("K must be at least 1"))
+					break
+				}
+			}
+
+			var tmpWindowString string
+			var tmpWindow time.Duration
+			if util.KeyExists(ruleI, "Window") {
+				tmpWindowString, err = util.ParseString(ruleI, "Window")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpWindowString != "" {
+				tmpWindow, err = time.ParseDuration(tmpWindowString)
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpWindow < 0 {
+					b.Error(errors.New("Window must not be negative"))
+					break
+				}
+			}
+
+			// a reservoir kept under another mode, size or window doesn't
+			// belong to the new rule's windows.
+			reset := tmpMode != mode || tmpK != k || tmpWindow != window
+			mode, probability = tmpMode, tmpProbability
+			keyPath, tree = tmpKeyPath, tmpTree
+			windowString, window = tmpWindowString, tmpWindow
+			k = tmpK
+			if reset {
+				r = newReservoir(k)
+			}
+		case <-b.quit:
+			// quit the block
+			return
+		case msg := <-b.in:
+			switch mode {
+			case "bernoulli":
+				if rnd.Float64() < probability {
+					b.out <- msg
+				}
+			case "hash":
+				key, err := jee.Eval(tree, msg)
+				if err != nil {
+					b.Error(err, msg)
+					break
+				}
+				if inHashSample(key, probability) {
+					b.out <- msg
+				}
+			case "reservoir":
+				now := clock.Now()
+				closeWindow(now)
+				if window > 0 && r.start.IsZero() {
+					r.start = now.Truncate(window)
+				}
+				r.add(msg, rnd)
+			}
+		case <-b.inpoll:
+			b.out <- r.result(window)
+		case <-b.clear:
+			r = newReservoir(k)
+		case <-waitTimer.C():
+		case c := <-b.queryrule:
+			c <- map[string]interface{}{
+				"Mode":        mode,
+				"Probability": probability,
+				"KeyPath":     keyPath,
+				"K":           float64(k),
+				"Window":      windowString,
+			}
+		case c := <-b.queryreservoir:
+			c <- r.result(window)
+		}
+
+		if mode != "reservoir" || window == 0 || r.start.IsZero() {
+			continue
+		}
+		now := clock.Now()
+		closeWindow(now)
+		if !r.start.IsZero() {
+			waitTimer.Reset(r.start.Add(window).Sub(now))
+		}
+	}
+}
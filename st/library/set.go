@@ -1,6 +1,7 @@
 package library
 
 import (
+	"encoding/json"
 	"errors"
 
 	"github.com/nytlabs/gojee"                 // jee
@@ -11,6 +12,8 @@ import (
 // specify those channels we're going to use to communicate with streamtools
 type Set struct {
 	blocks.Block
+	snapshot    chan blocks.MsgChan
+	restore     blocks.MsgChan
 	queryrule   chan blocks.MsgChan
 	inrule      blocks.MsgChan
 	add         blocks.MsgChan
@@ -37,6 +40,8 @@ func (b *Set) Setup() {
 
 	b.inrule = b.InRoute("rule")
 	b.queryrule = b.QueryRoute("rule")
+	b.snapshot = b.SnapshotRoute()
+	b.restore = b.RestoreRoute()
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
 }
@@ -66,11 +71,11 @@ func (b *Set) Run() {
 			}
 			v, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 			if _, ok := v.(string); !ok {
-				b.Error(errors.New("can only build sets of strings"))
+				b.Error(errors.New("can only build sets of strings"), msg)
 				continue
 			}
 			set[v] = true
@@ -81,7 +86,7 @@ func (b *Set) Run() {
 			}
 			v, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 			_, ok := set[v]
@@ -97,6 +102,27 @@ func (b *Set) Run() {
 			c <- map[string]interface{}{
 				"Path": path,
 			}
+		case c := <-b.snapshot:
+			members := make([]interface{}, 0, len(set))
+			for v := range set {
+				members = append(members, v)
+			}
+			state, err := json.Marshal(members)
+			if err != nil {
+				b.Error(err)
+			}
+			c <- state
+		case data := <-b.restore:
+			var members []interface{}
+			err := json.Unmarshal(data.([]byte), &members)
+			if err != nil {
+				b.Error(err)
+				continue
+			}
+			set = make(map[interface{}]bool)
+			for _, v := range members {
+				set[v] = true
+			}
 
 		}
 	}
@@ -0,0 +1,303 @@
+package library
+
+import (
+	"errors"
+	"hash/fnv"
+	"time"
+
+	"github.com/nytlabs/gojee"
+	"github.com/nytlabs/streamtools/st/blocks" // blocks
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// the number of panes a sketch's window is split into. Sketches can't forget
+// values, so a windowed sketch is a sketch for each pane, and panes are
+// forgotten as they fall out of the window.
+const sketchPanes = 10
+
+// sketch is a summary of the values a sketch block has seen, in bounded
+// memory.
+type sketch interface {
+	add(v interface{}) error
+	merge(other sketch) error
+	estimate() interface{}
+	state() interface{}
+}
+
+// sketchKind is what differs between the sketch blocks.
+type sketchKind interface {
+	desc() string
+	// parseRule reads the rule fields of the sketch and returns a function
+	// that makes empty sketches, and the fields as a rule query shows them.
+	parseRule(rule interface{}) (func() sketch, map[string]interface{}, error)
+	// parseState reads a sketch from its state, as emitted on the sketch
+	// route, to be merged.
+	parseState(state interface{}) (sketch, error)
+}
+
+// hashValue hashes a value for a sketch. Equal values hash the same, whatever
+// their type.
+func hashValue(v interface{}) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(groupKey(v)))
+	x := h.Sum64()
+
+	// FNV's high bits barely change between short, similar strings, and
+	// sketches use them, so they are mixed up as in MurmurHash3.
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb3e9fe1a85ec
+	x ^= x >> 33
+	return x
+}
+
+type sketchPane struct {
+	start  time.Time
+	sketch sketch
+}
+
+// sketchWindow keeps the panes of a windowed sketch, or one pane for the
+// sketch of everything if window is 0.
+type sketchWindow struct {
+	window    time.Duration
+	newSketch func() sketch
+	panes     []*sketchPane
+}
+
+func newSketchWindow(window time.Duration, newSketch func() sketch) *sketchWindow {
+	return &sketchWindow{
+		window:    window,
+		newSketch: newSketch,
+	}
+}
+
+// current is the sketch that values seen now go into.
+func (w *sketchWindow) current(now time.Time) sketch {
+	start := time.Time{}
+	if w.window > 0 {
+		start = now.Truncate(w.window / sketchPanes)
+	}
+	if n := len(w.panes); n > 0 && w.panes[n-1].start.Equal(start) {
+		return w.panes[n-1].sketch
+	}
+
+	p := &sketchPane{
+		start:  start,
+		sketch: w.newSketch(),
+	}
+	w.panes = append(w.panes, p)
+	return p.sketch
+}
+
+// expire forgets the panes that have fallen out of the window.
+func (w *sketchWindow) expire(now time.Time) {
+	if w.window == 0 {
+		return
+	}
+	cutoff := now.Add(-w.window)
+	for len(w.panes) > 0 && !w.panes[0].start.Add(w.window/sketchPanes).After(cutoff) {
+		w.panes = w.panes[1:]
+	}
+}
+
+// total is the sketch of every value in the window.
+func (w *sketchWindow) total(now time.Time) (sketch, error) {
+	w.expire(now)
+	if w.window == 0 && len(w.panes) == 1 {
+		return w.panes[0].sketch, nil
+	}
+
+	s := w.newSketch()
+	for _, p := range w.panes {
+		err := s.merge(p.sketch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// specify those channels we're going to use to communicate with streamtools
+type Sketch struct {
+	blocks.Block
+	kind          sketchKind
+	queryrule     chan blocks.MsgChan
+	queryestimate chan blocks.MsgChan
+	querysketch   chan blocks.MsgChan
+	inrule        blocks.MsgChan
+	inpoll        blocks.MsgChan
+	merge         blocks.MsgChan
+	clear         blocks.MsgChan
+	in            blocks.MsgChan
+	out           blocks.MsgChan
+	sketchOut     blocks.MsgChan
+	quit          blocks.MsgChan
+}
+
+// Setup is called once before running the block. We build up the channels and specify what kind of block this is.
+func (b *Sketch) Setup() {
+	b.Kind = "Stats"
+	b.Desc = b.kind.desc()
+	b.in = b.InRoute("in")
+	b.inrule = b.InRoute("rule")
+	b.inpoll = b.InRoute("poll")
+	b.merge = b.InRoute("merge")
+	b.clear = b.InRoute("clear")
+	b.queryrule = b.QueryRoute("rule")
+	b.queryestimate = b.QueryRoute("estimate")
+	b.querysketch = b.QueryRoute("sketch")
+	b.quit = b.Quit()
+	b.out = b.Broadcast()
+	b.sketchOut = b.OutRoute("sketch")
+}
+
+// Run is the block's main loop. Here we listen on the different channels we set up.
+func (b *Sketch) Run() {
+	clock := b.Clock()
+	var path, windowString string
+	var tree *jee.TokenTree
+	var fields map[string]interface{}
+	var w *sketchWindow
+
+	for {
+		select {
+		case ruleI := <-b.inrule:
+			tmpPath, err := util.ParseRequiredString(ruleI, "Path")
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			tmpTree, err := util.BuildTokenTree(tmpPath)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+
+			var tmpWindowString string
+			var window time.Duration
+			if util.KeyExists(ruleI, "Window") {
+				tmpWindowString, err = util.ParseString(ruleI, "Window")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpWindowString != "" {
+				window, err = time.ParseDuration(tmpWindowString)
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if window < 0 {
+					b.Error(errors.New("Window must not be negative"))
+					break
+				}
+			}
+
+			newSketch, tmpFields, err := b.kind.parseRule(ruleI)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+
+			path, tree = tmpPath, tmpTree
+			windowString = tmpWindowString
+			fields = tmpFields
+			w = newSketchWindow(window, newSketch)
+		case <-b.quit:
+			// quit the block
+			return
+		case msg := <-b.in:
+			if w == nil {
+				break
+			}
+			v, err := jee.Eval(tree, msg)
+			if err != nil {
+				b.Error(err, msg)
+				break
+			}
+			if v == nil {
+				break
+			}
+			err = w.current(clock.Now()).add(v)
+			if err != nil {
+				b.Error(err, msg)
+			}
+		case msg := <-b.merge:
+			if w == nil {
+				break
+			}
+			m, ok := msg.(map[string]interface{})
+			if !ok {
+				b.Error(errors.New("can only merge a message with a Sketch"), msg)
+				break
+			}
+			other, err := b.kind.parseState(m["Sketch"])
+			if err != nil {
+				b.Error(err, msg)
+				break
+			}
+			err = w.current(clock.Now()).merge(other)
+			if err != nil {
+				b.Error(err, msg)
+			}
+		case <-b.inpoll:
+			if w == nil {
+				break
+			}
+			s, err := w.total(clock.Now())
+			if err != nil {
+				b.Error(err)
+				break
+			}
+			b.out <- s.estimate()
+			b.sketchOut <- map[string]interface{}{
+				"Sketch": s.state(),
+			}
+		case <-b.clear:
+			if w != nil {
+				w = newSketchWindow(w.window, w.newSketch)
+			}
+		case c := <-b.queryestimate:
+			if w == nil {
+				c <- map[string]interface{}{}
+				break
+			}
+			s, err := w.total(clock.Now())
+			if err != nil {
+				b.Error(err)
+				c <- map[string]interface{}{}
+				break
+			}
+			c <- s.estimate()
+		case c := <-b.querysketch:
+			if w == nil {
+				c <- map[string]interface{}{}
+				break
+			}
+			s, err := w.total(clock.Now())
+			if err != nil {
+				b.Error(err)
+				c <- map[string]interface{}{}
+				break
+			}
+			c <- map[string]interface{}{
+				"Sketch": s.state(),
+			}
+		case c := <-b.queryrule:
+			r := map[string]interface{}{
+				"Path":   path,
+				"Window": windowString,
+			}
+			for k, v := range fields {
+				r[k] = v
+			}
+			c <- r
+		}
+
+		if w != nil {
+			w.expire(clock.Now())
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nytlabs/gojee"
+	"github.com/nytlabs/streamtools/st/blocks" // blocks
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// switchCase is a route of a switch, and the condition a message must meet
+// to go out on it.
+type switchCase struct {
+	name      string
+	condition string
+	tree      *jee.TokenTree
+}
+
+// parseSwitchCases reads a switch's cases from its rule. Each needs a name,
+// which it can't share with another case or with the switch's own routes.
+func parseSwitchCases(rule interface{}) ([]*switchCase, error) {
+	casesI, err := util.ParseArray(rule, "Cases")
+	if err != nil {
+		return nil, err
+	}
+
+	var cases []*switchCase
+	names := map[string]bool{
+		"default": true,
+		"error":   true,
+	}
+	for _, caseI := range casesI {
+		name, err := util.ParseRequiredString(caseI, "Name")
+		if err != nil {
+			return nil, err
+		}
+		if names[name] {
+			return nil, errors.New(fmt.Sprintf("a case can't be called %s, as there is already a route called that", name))
+		}
+		names[name] = true
+
+		condition, err := util.ParseRequiredString(caseI, "Condition")
+		if err != nil {
+			return nil, err
+		}
+		tree, err := util.BuildTokenTree(condition)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, &switchCase{
+			name:      name,
+			condition: condition,
+			tree:      tree,
+		})
+	}
+	return cases, nil
+}
+
+// parseSwitchRule reads a switch's cases and mode from its rule.
+func parseSwitchRule(rule interface{}) ([]*switchCase, string, error) {
+	cases, err := parseSwitchCases(rule)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mode := "first"
+	if util.KeyExists(rule, "Mode") {
+		mode, err = util.ParseString(rule, "Mode")
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if mode != "first" && mode != "multi" {
+		return nil, "", errors.New(fmt.Sprintf("Mode must be first or multi, not %s", mode))
+	}
+
+	return cases, mode, nil
+}
+
+type Switch struct {
+	blocks.Block
+	queryrule  chan blocks.MsgChan
+	inrule     blocks.MsgChan
+	in         blocks.MsgChan
+	named      chan *blocks.Msg
+	defaultOut blocks.MsgChan
+	quit       blocks.MsgChan
+}
+
+// a bit of boilerplate for streamtools
+func NewSwitch() blocks.BlockInterface {
+	return &Switch{}
+}
+
+func (b *Switch) Setup() {
+	b.Kind = "Core"
+	b.Desc = "emits each message on the route named after the first of its rule's Cases whose Condition it meets, or every one in multi Mode, sending those that meet none to default"
+	b.in = b.InRoute("in")
+	b.inrule = b.InRoute("rule")
+	b.queryrule = b.QueryRoute("rule")
+	b.quit = b.Quit()
+	b.named = b.NamedOutRoutes()
+	b.defaultOut = b.OutRoute("default")
+}
+
+// CheckRule makes sure the rule's cases and mode are ones the switch can use.
+func (b *Switch) CheckRule(rule interface{}) error {
+	_, _, err := parseSwitchRule(rule)
+	return err
+}
+
+func (b *Switch) Run() {
+	var cases []*switchCase
+	mode := "first"
+
+	for {
+		select {
+		case msg := <-b.in:
+			matched := false
+			for _, c := range cases {
+				e, err := jee.Eval(c.tree, msg)
+				if err != nil {
+					b.Error(err, msg)
+					continue
+				}
+				if eval, ok := e.(bool); !ok || !eval {
+					continue
+				}
+
+				matched = true
+				b.named <- &blocks.Msg{
+					Msg:   msg,
+					Route: c.name,
+				}
+				if mode == "first" {
+					break
+				}
+			}
+			if !matched {
+				b.defaultOut <- msg
+			}
+
+		case ruleI := <-b.inrule:
+			tmpCases, tmpMode, err := parseSwitchRule(ruleI)
+			if err != nil {
+				b.Error(err)
+				break
+			}
+
+			cases = tmpCases
+			mode = tmpMode
+
+		case c := <-b.queryrule:
+			// deal with a query request
+			casesOut := []interface{}{}
+			for _, sc := range cases {
+				casesOut = append(casesOut, map[string]interface{}{
+					"Name":      sc.name,
+					"Condition": sc.condition,
+				})
+			}
+			c <- map[string]interface{}{
+				"Cases": casesOut,
+				"Mode":  mode,
+			}
+		case <-b.quit:
+			// quit the block
+			return
+		}
+	}
+}
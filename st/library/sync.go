@@ -38,15 +38,16 @@ func (b *Sync) Setup() {
 
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *Sync) Run() {
+	clock := b.Clock()
 	var lagString, path string
 	var tree *jee.TokenTree
 	lag := time.Duration(0)
-	emitTick := time.NewTimer(500 * time.Millisecond)
+	emitTick := clock.NewTimer(500 * time.Millisecond)
 	pq := &PriorityQueue{}
 	heap.Init(pq)
 	for {
 		select {
-		case <-emitTick.C:
+		case <-emitTick.C():
 		case ruleI := <-b.inrule:
 			// set a parameter of the block
 			lagString, err := util.ParseString(ruleI, "Lag")
@@ -85,11 +86,11 @@ func (b *Sync) Run() {
 			}
 			tI, err := jee.Eval(tree, interface{}(msg))
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 			}
 			t, ok := tI.(float64)
 			if !ok {
-				b.Error(errors.New("couldn't convert time value to float64"))
+				b.Error(errors.New("couldn't convert time value to float64"), msg)
 				continue
 			}
 			ms := time.Unix(0, int64(t*1000000))
@@ -107,7 +108,7 @@ func (b *Sync) Run() {
 			}
 
 		}
-		now := time.Now()
+		now := clock.Now()
 		for {
 			item, diff := pq.PeekAndShift(now, lag)
 			if item == nil {
@@ -118,7 +119,7 @@ func (b *Sync) Run() {
 				emitTick.Reset(diff)
 				break
 			}
-			b.out <- item.(*PQMessage).val.(map[string]interface{})
+			b.out <- item.(*PQMessage).val
 		}
 
 	}
@@ -0,0 +1,278 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/nytlabs/streamtools/st/blocks" // blocks
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// centroid is the mean of a cluster of values, and how many there are.
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+type byMean []centroid
+
+func (c byMean) Len() int           { return len(c) }
+func (c byMean) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byMean) Less(i, j int) bool { return c[i].mean < c[j].mean }
+
+// tDigest estimates quantiles of the numbers it has seen. It clusters them
+// into centroids, which are kept small near the ends of the distribution,
+// where quantiles need to be most accurate, so that it is accurate to within
+// a fraction of a percent about the median and better towards the tails.
+type tDigest struct {
+	compression float64
+	quantiles   []float64
+	centroids   []centroid
+	buffer      []centroid
+	count       float64
+	min, max    float64
+}
+
+func newTDigest(compression float64, quantiles []float64) *tDigest {
+	return &tDigest{
+		compression: compression,
+		quantiles:   quantiles,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+func (t *tDigest) addCentroid(c centroid) {
+	t.buffer = append(t.buffer, c)
+	t.count += c.weight
+	t.min = math.Min(t.min, c.mean)
+	t.max = math.Max(t.max, c.mean)
+	if len(t.buffer) >= int(10*t.compression) {
+		t.compress()
+	}
+}
+
+// compress merges the buffered values into the centroids.
+func (t *tDigest) compress() {
+	if len(t.buffer) == 0 {
+		return
+	}
+
+	all := append(t.centroids, t.buffer...)
+	sort.Sort(byMean(all))
+	t.buffer = nil
+
+	var merged []centroid
+	cur := all[0]
+	sofar := 0.0
+	for _, c := range all[1:] {
+		q := (sofar + (cur.weight+c.weight)/2) / t.count
+		limit := 4 * t.count * q * (1 - q) / t.compression
+		if cur.weight+c.weight <= math.Max(limit, 1) {
+			cur.mean += (c.mean - cur.mean) * c.weight / (cur.weight + c.weight)
+			cur.weight += c.weight
+			continue
+		}
+		merged = append(merged, cur)
+		sofar += cur.weight
+		cur = c
+	}
+	t.centroids = append(merged, cur)
+}
+
+func (t *tDigest) add(v interface{}) error {
+	f, ok := v.(float64)
+	if !ok {
+		return errors.New("can only find quantiles of numbers")
+	}
+	t.addCentroid(centroid{
+		mean:   f,
+		weight: 1,
+	})
+	return nil
+}
+
+func (t *tDigest) merge(other sketch) error {
+	o, ok := other.(*tDigest)
+	if !ok || o.compression != t.compression {
+		return errors.New("can only merge a t-digest with the same Compression")
+	}
+	o.compress()
+	for _, c := range o.centroids {
+		t.addCentroid(c)
+	}
+	if o.count > 0 {
+		t.min = math.Min(t.min, o.min)
+		t.max = math.Max(t.max, o.max)
+	}
+	return nil
+}
+
+// quantile estimates the value that q of the numbers are below.
+func (t *tDigest) quantile(q float64) float64 {
+	t.compress()
+	switch {
+	case len(t.centroids) == 0:
+		return math.NaN()
+	case q <= 0:
+		return t.min
+	case q >= 1:
+		return t.max
+	}
+
+	// each centroid's mean is taken to be at the middle of its weight, and
+	// quantiles between them are interpolated.
+	target := q * t.count
+	sofar := 0.0
+	prevMean, prevPos := t.min, 0.0
+	for _, c := range t.centroids {
+		pos := sofar + c.weight/2
+		if target < pos {
+			return prevMean + (c.mean-prevMean)*(target-prevPos)/(pos-prevPos)
+		}
+		prevMean, prevPos = c.mean, pos
+		sofar += c.weight
+	}
+	return prevMean + (t.max-prevMean)*(target-prevPos)/(t.count-prevPos)
+}
+
+func (t *tDigest) estimate() interface{} {
+	quantiles := []interface{}{}
+	for _, q := range t.quantiles {
+		var v interface{}
+		if t.count > 0 {
+			v = t.quantile(q)
+		}
+		quantiles = append(quantiles, map[string]interface{}{
+			"Quantile": q,
+			"Value":    v,
+		})
+	}
+	return map[string]interface{}{
+		"Count":     t.count,
+		"Quantiles": quantiles,
+	}
+}
+
+func (t *tDigest) state() interface{} {
+	t.compress()
+	centroids := []interface{}{}
+	for _, c := range t.centroids {
+		centroids = append(centroids, []interface{}{c.mean, c.weight})
+	}
+	state := map[string]interface{}{
+		"Compression": t.compression,
+		"Centroids":   centroids,
+	}
+	if t.count > 0 {
+		state["Min"] = t.min
+		state["Max"] = t.max
+	}
+	return state
+}
+
+type tDigestKind struct{}
+
+// we need to build a simple factory so that streamtools can make new blocks of this kind
+func NewTDigest() blocks.BlockInterface {
+	return &Sketch{kind: tDigestKind{}}
+}
+
+func (tDigestKind) desc() string {
+	return "estimates quantiles of the numbers at a path, in bounded memory, with a t-digest"
+}
+
+func parseCompression(rule interface{}) (float64, error) {
+	compression := 100.0
+	if util.KeyExists(rule, "Compression") {
+		var err error
+		compression, err = util.ParseFloat(rule, "Compression")
+		if err != nil {
+			return 0, err
+		}
+	}
+	if compression < 10 {
+		return 0, errors.New(fmt.Sprintf("Compression must be at least 10, not %g", compression))
+	}
+	return compression, nil
+}
+
+func (tDigestKind) parseRule(rule interface{}) (func() sketch, map[string]interface{}, error) {
+	compression, err := parseCompression(rule)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	quantiles := []float64{0.5, 0.9, 0.99}
+	if util.KeyExists(rule, "Quantiles") {
+		quantiles, err = util.ParseArrayFloat(rule, "Quantiles")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, q := range quantiles {
+		if q < 0 || q > 1 {
+			return nil, nil, errors.New(fmt.Sprintf("Quantiles must be between 0 and 1, not %g", q))
+		}
+	}
+
+	qs := make([]interface{}, len(quantiles))
+	for i, q := range quantiles {
+		qs[i] = q
+	}
+
+	newSketch := func() sketch {
+		return newTDigest(compression, quantiles)
+	}
+	return newSketch, map[string]interface{}{
+		"Compression": compression,
+		"Quantiles":   qs,
+	}, nil
+}
+
+func (tDigestKind) parseState(state interface{}) (sketch, error) {
+	if _, ok := state.(map[string]interface{}); !ok {
+		return nil, errors.New("the Sketch is not an object")
+	}
+	compression, err := parseCompression(state)
+	if err != nil {
+		return nil, err
+	}
+	centroids, err := util.ParseArray(state, "Centroids")
+	if err != nil {
+		return nil, err
+	}
+
+	t := newTDigest(compression, nil)
+	for _, cI := range centroids {
+		c, ok := cI.([]interface{})
+		if !ok || len(c) != 2 {
+			return nil, errors.New("the Sketch's Centroids must each be a mean and a weight")
+		}
+		mean, okMean := c[0].(float64)
+		weight, okWeight := c[1].(float64)
+		if !okMean || !okWeight || weight <= 0 {
+			return nil, errors.New("the Sketch's Centroids must each be a mean and a weight")
+		}
+		t.addCentroid(centroid{
+			mean:   mean,
+			weight: weight,
+		})
+	}
+
+	// the smallest and largest numbers may be lost in centroids.
+	for _, key := range []string{"Min", "Max"} {
+		if !util.KeyExists(state, key) {
+			continue
+		}
+		v, err := util.ParseFloat(state, key)
+		if err != nil {
+			return nil, err
+		}
+		t.min = math.Min(t.min, v)
+		t.max = math.Max(t.max, v)
+	}
+	return t, nil
+}
@@ -0,0 +1,477 @@
+package library
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/nytlabs/gojee"
+	"github.com/nytlabs/streamtools/st/blocks" // blocks
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// throttleKey is what a throttle knows about one key: its bucket of tokens
+// and the messages queued for them or, in the other modes, the message
+// waiting for its interval to end, and when that is.
+type throttleKey struct {
+	value   interface{}
+	tokens  float64
+	last    time.Time
+	queue   []interface{}
+	msg     interface{}
+	pending bool
+	until   time.Time
+	next    time.Time // when the key is next due to be looked at, or zero
+}
+
+// throttleDeadline is when a key is due to be looked at. A key is only ever
+// due once, so a deadline that doesn't match its key's next is out of date.
+type throttleDeadline struct {
+	key string
+	t   time.Time
+}
+
+type throttleDeadlines []throttleDeadline
+
+func (d throttleDeadlines) Len() int            { return len(d) }
+func (d throttleDeadlines) Less(i, j int) bool  { return d[i].t.Before(d[j].t) }
+func (d throttleDeadlines) Swap(i, j int)       { d[i], d[j] = d[j], d[i] }
+func (d *throttleDeadlines) Push(x interface{}) { *d = append(*d, x.(throttleDeadline)) }
+func (d *throttleDeadlines) Pop() interface{} {
+	old := *d
+	n := len(old)
+	x := old[n-1]
+	*d = old[0 : n-1]
+	return x
+}
+
+// throttle holds back messages for each key, as its mode says. In ratelimit
+// mode it lets messages through as long as the key's bucket has a token for
+// them. Buckets hold up to burst tokens and gain rate tokens a second, and
+// messages that find the bucket empty are queued until it isn't, or dropped.
+// In debounce mode it emits a key's last message once the key has gone
+// interval without another. In first mode it emits a key's first message
+// and drops the rest for interval, and in last mode it emits the last of a
+// key's messages interval after the first.
+type throttle struct {
+	mode      string
+	rate      float64
+	burst     float64
+	interval  time.Duration
+	onLimit   string
+	maxQueue  int
+	keys      map[string]*throttleKey
+	deadlines *throttleDeadlines
+	emit      func(msg interface{})
+	drop      func(msg interface{})
+}
+
+func newThrottle(mode string, emit, drop func(msg interface{})) *throttle {
+	return &throttle{
+		mode:      mode,
+		keys:      map[string]*throttleKey{},
+		deadlines: &throttleDeadlines{},
+		emit:      emit,
+		drop:      drop,
+	}
+}
+
+// schedule makes a key due at t, unless it is already due.
+func (t *throttle) schedule(key string, k *throttleKey, at time.Time) {
+	if !k.next.IsZero() {
+		return
+	}
+	k.next = at
+	heap.Push(t.deadlines, throttleDeadline{
+		key: key,
+		t:   at,
+	})
+}
+
+func (t *throttle) refill(k *throttleKey, now time.Time) {
+	k.tokens = math.Min(t.burst, k.tokens+t.rate*now.Sub(k.last).Seconds())
+	k.last = now
+}
+
+// wait is how long it takes a bucket to gain n tokens.
+func (t *throttle) wait(k *throttleKey, n float64) time.Duration {
+	return time.Duration(math.Ceil((n - k.tokens) / t.rate * float64(time.Second)))
+}
+
+// a bucket holds a token if it is within a rounding error of it.
+func hasToken(tokens float64) bool {
+	return tokens >= 1-1e-9
+}
+
+func (t *throttle) add(key string, value interface{}, msg interface{}, now time.Time) {
+	k, ok := t.keys[key]
+	if !ok {
+		k = &throttleKey{
+			value:  value,
+			tokens: t.burst,
+			last:   now,
+		}
+		t.keys[key] = k
+	}
+
+	switch t.mode {
+	case "ratelimit":
+		t.refill(k, now)
+		switch {
+		case len(k.queue) == 0 && hasToken(k.tokens):
+			k.tokens--
+			t.emit(msg)
+			// the bucket is forgotten once it has filled up again.
+			t.schedule(key, k, now.Add(t.wait(k, t.burst)))
+		case t.onLimit == "drop" || len(k.queue) >= t.maxQueue:
+			t.drop(msg)
+		default:
+			k.queue = append(k.queue, msg)
+			if len(k.queue) == 1 {
+				// the key is now due when it has a token for the message,
+				// rather than when its bucket is full.
+				k.next = time.Time{}
+				t.schedule(key, k, now.Add(t.wait(k, 1)))
+			}
+		}
+	case "debounce":
+		if k.pending {
+			t.drop(k.msg)
+		}
+		k.msg, k.pending = msg, true
+		k.until = now.Add(t.interval)
+		t.schedule(key, k, k.until)
+	case "first":
+		if ok {
+			t.drop(msg)
+			return
+		}
+		t.emit(msg)
+		k.until = now.Add(t.interval)
+		t.schedule(key, k, k.until)
+	case "last":
+		if k.pending {
+			t.drop(k.msg)
+		}
+		k.msg, k.pending = msg, true
+		if !ok {
+			k.until = now.Add(t.interval)
+			t.schedule(key, k, k.until)
+		}
+	}
+}
+
+// due looks at each key that is due by now.
+func (t *throttle) due(now time.Time) {
+	for t.deadlines.Len() > 0 && !(*t.deadlines)[0].t.After(now) {
+		d := heap.Pop(t.deadlines).(throttleDeadline)
+		k, ok := t.keys[d.key]
+		if !ok || !k.next.Equal(d.t) {
+			continue
+		}
+		k.next = time.Time{}
+
+		switch t.mode {
+		case "ratelimit":
+			t.refill(k, now)
+			for len(k.queue) > 0 && hasToken(k.tokens) {
+				k.tokens--
+				t.emit(k.queue[0])
+				k.queue = k.queue[1:]
+			}
+			switch {
+			case len(k.queue) > 0:
+				t.schedule(d.key, k, now.Add(t.wait(k, 1)))
+			case k.tokens < t.burst:
+				t.schedule(d.key, k, now.Add(t.wait(k, t.burst)))
+			default:
+				delete(t.keys, d.key)
+			}
+		case "debounce", "last":
+			// a debounced key that has seen another message isn't quiet yet.
+			if now.Before(k.until) {
+				t.schedule(d.key, k, k.until)
+				break
+			}
+			t.emit(k.msg)
+			delete(t.keys, d.key)
+		case "first":
+			delete(t.keys, d.key)
+		}
+	}
+}
+
+func (t *throttle) next() (time.Time, bool) {
+	if t.deadlines.Len() == 0 {
+		return time.Time{}, false
+	}
+	return (*t.deadlines)[0].t, true
+}
+
+// flush emits every message the throttle is holding back.
+func (t *throttle) flush() {
+	for key, k := range t.keys {
+		for _, msg := range k.queue {
+			t.emit(msg)
+		}
+		k.queue = nil
+		if k.pending {
+			t.emit(k.msg)
+			delete(t.keys, key)
+		}
+	}
+}
+
+// dropAll drops every message the throttle is holding back.
+func (t *throttle) dropAll() {
+	for _, k := range t.keys {
+		for _, msg := range k.queue {
+			t.drop(msg)
+		}
+		if k.pending {
+			t.drop(k.msg)
+		}
+	}
+}
+
+// levels describes each key the throttle knows about.
+func (t *throttle) levels(now time.Time) []interface{} {
+	keys := make([]string, 0, len(t.keys))
+	for key := range t.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	levels := []interface{}{}
+	for _, key := range keys {
+		k := t.keys[key]
+		level := map[string]interface{}{
+			"Key": k.value,
+		}
+		if t.mode == "ratelimit" {
+			t.refill(k, now)
+			level["Tokens"] = k.tokens
+			level["Queued"] = float64(len(k.queue))
+		} else {
+			level["Until"] = k.until.Format(time.RFC3339Nano)
+			level["Pending"] = k.pending
+		}
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+// specify those channels we're going to use to communicate with streamtools
+type Throttle struct {
+	blocks.Block
+	queryrule    chan blocks.MsgChan
+	querybuckets chan blocks.MsgChan
+	inrule       blocks.MsgChan
+	flush        blocks.MsgChan
+	in           blocks.MsgChan
+	out          blocks.MsgChan
+	dropped      blocks.MsgChan
+	quit         blocks.MsgChan
+}
+
+// we need to build a simple factory so that streamtools can make new blocks of this kind
+func NewThrottle() blocks.BlockInterface {
+	return &Throttle{}
+}
+
+// Setup is called once before running the block. We build up the channels and specify what kind of block this is.
+func (b *Throttle) Setup() {
+	b.Kind = "Core"
+	b.Desc = "limits the rate of messages, for each key if KeyPath is set, with a token bucket, or by debouncing them or letting through the first or last in each Interval"
+	b.in = b.InRoute("in")
+	b.inrule = b.InRoute("rule")
+	b.flush = b.InRoute("flush")
+	b.queryrule = b.QueryRoute("rule")
+	b.querybuckets = b.QueryRoute("buckets")
+	b.quit = b.Quit()
+	b.out = b.Broadcast()
+	b.dropped = b.OutRoute("dropped")
+}
+
+// Run is the block's main loop. Here we listen on the different channels we set up.
+func (b *Throttle) Run() {
+	clock := b.Clock()
+	waitTimer := clock.NewTimer(100 * time.Millisecond)
+
+	mode := "ratelimit"
+	rate := 1.0
+	burst := 1
+	interval := time.Second
+	onLimit := "delay"
+	maxQueue := 1000
+	var keyPath string
+	var tree *jee.TokenTree
+
+	emit := func(msg interface{}) {
+		b.out <- msg
+	}
+	drop := func(msg interface{}) {
+		b.dropped <- msg
+	}
+	t := newThrottle(mode, emit, drop)
+	t.rate, t.burst, t.interval = rate, float64(burst), interval
+	t.onLimit, t.maxQueue = onLimit, maxQueue
+
+	for {
+		select {
+		case ruleI := <-b.inrule:
+			tmpMode := "ratelimit"
+			var err error
+			if util.KeyExists(ruleI, "Mode") {
+				tmpMode, err = util.ParseString(ruleI, "Mode")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpMode != "ratelimit" && tmpMode != "debounce" && tmpMode != "first" && tmpMode != "last" {
+				b.Error(errors.New(fmt.Sprintf("Mode must be ratelimit, debounce, first or last, not %s", tmpMode)))
+				break
+			}
+
+			tmpRate := 1.0
+			if util.KeyExists(ruleI, "Rate") {
+				tmpRate, err = util.ParseFloat(ruleI, "Rate")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpRate <= 0 {
+					b.Error(errors.New("Rate must be positive"))
+					break
+				}
+			}
+
+			tmpBurst := 1
+			if util.KeyExists(ruleI, "Burst") {
+				tmpBurst, err = util.ParseInt(ruleI, "Burst")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpBurst < 1 {
+					b.Error(errors.New("Burst must be at least 1"))
+					break
+				}
+			}
+
+			tmpInterval := time.Second
+			if util.KeyExists(ruleI, "Interval") {
+				intervalString, err := util.ParseString(ruleI, "Interval")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				tmpInterval, err = time.ParseDuration(intervalString)
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpInterval <= 0 {
+					b.Error(errors.New("Interval must be positive"))
+					break
+				}
+			}
+
+			tmpOnLimit := "delay"
+			if util.KeyExists(ruleI, "OnLimit") {
+				tmpOnLimit, err = util.ParseString(ruleI, "OnLimit")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpOnLimit != "delay" && tmpOnLimit != "drop" {
+				b.Error(errors.New(fmt.Sprintf("OnLimit must be delay or drop, not %s", tmpOnLimit)))
+				break
+			}
+
+			tmpMaxQueue := 1000
+			if util.KeyExists(ruleI, "MaxQueue") {
+				tmpMaxQueue, err = util.ParseInt(ruleI, "MaxQueue")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+				if tmpMaxQueue < 1 {
+					b.Error(errors.New("MaxQueue must be at least 1"))
+					break
+				}
+			}
+
+			var tmpKeyPath string
+			var tmpTree *jee.TokenTree
+			if util.KeyExists(ruleI, "KeyPath") {
+				tmpKeyPath, err = util.ParseString(ruleI, "KeyPath")
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+			if tmpKeyPath != "" {
+				tmpTree, err = util.BuildTokenTree(tmpKeyPath)
+				if err != nil {
+					b.Error(err)
+					break
+				}
+			}
+
+			// what the throttle is holding back only makes sense to a throttle
+			// of the same kind.
+			if tmpMode != mode || tmpKeyPath != keyPath {
+				t.dropAll()
+				t = newThrottle(tmpMode, emit, drop)
+			}
+			mode, rate, burst, interval = tmpMode, tmpRate, tmpBurst, tmpInterval
+			onLimit, maxQueue = tmpOnLimit, tmpMaxQueue
+			keyPath, tree = tmpKeyPath, tmpTree
+			t.rate, t.burst, t.interval = rate, float64(burst), interval
+			t.onLimit, t.maxQueue = onLimit, maxQueue
+		case <-b.quit:
+			// quit the block
+			return
+		case msg := <-b.in:
+			var value interface{}
+			if tree != nil {
+				var err error
+				value, err = jee.Eval(tree, msg)
+				if err != nil {
+					b.Error(err, msg)
+					break
+				}
+			}
+			t.add(groupKey(value), value, msg, clock.Now())
+		case <-b.flush:
+			t.flush()
+		case <-waitTimer.C():
+		case c := <-b.queryrule:
+			c <- map[string]interface{}{
+				"Mode":     mode,
+				"Rate":     rate,
+				"Burst":    float64(burst),
+				"Interval": interval.String(),
+				"KeyPath":  keyPath,
+				"OnLimit":  onLimit,
+				"MaxQueue": float64(maxQueue),
+			}
+		case c := <-b.querybuckets:
+			c <- map[string]interface{}{
+				"Buckets": t.levels(clock.Now()),
+			}
+		}
+
+		now := clock.Now()
+		t.due(now)
+		if next, ok := t.next(); ok {
+			waitTimer.Reset(next.Sub(now))
+		}
+	}
+}
@@ -33,11 +33,12 @@ func (b *Ticker) Setup() {
 
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *Ticker) Run() {
+	clock := b.Clock()
 	interval := time.Duration(1) * time.Second
-	ticker := time.NewTicker(interval)
+	ticker := clock.NewTicker(interval)
 	for {
 		select {
-		case tick := <-ticker.C:
+		case tick := <-ticker.C():
 			b.out <- map[string]interface{}{
 				"tick": tick.String(),
 			}
@@ -62,7 +63,7 @@ func (b *Ticker) Run() {
 
 			interval = dur
 			ticker.Stop()
-			ticker = time.NewTicker(interval)
+			ticker = clock.NewTicker(interval)
 		case <-b.quit:
 			return
 		case c := <-b.queryrule:
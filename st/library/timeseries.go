@@ -1,8 +1,8 @@
 package library
 
 import (
+	"encoding/json"
 	"errors"
-	"time"
 
 	"github.com/nytlabs/gojee"                 // jee
 	"github.com/nytlabs/streamtools/st/blocks" // blocks
@@ -16,6 +16,8 @@ type Timeseries struct {
 	querystate chan blocks.MsgChan
 	inrule     blocks.MsgChan
 	inpoll     blocks.MsgChan
+	snapshot   chan blocks.MsgChan
+	restore    blocks.MsgChan
 	in         blocks.MsgChan
 	out        blocks.MsgChan
 	quit       blocks.MsgChan
@@ -30,6 +32,15 @@ type tsData struct {
 	Values []tsDataPoint
 }
 
+// fill replaces the most recent samples with values, keeping the number of
+// samples the same.
+func (d *tsData) fill(values []tsDataPoint) {
+	if len(values) > len(d.Values) {
+		values = values[len(values)-len(d.Values):]
+	}
+	copy(d.Values[len(d.Values)-len(values):], values)
+}
+
 // we need to build a simple factory so that streamtools can make new blocks of this kind
 func NewTimeseries() blocks.BlockInterface {
 	return &Timeseries{}
@@ -43,6 +54,8 @@ func (b *Timeseries) Setup() {
 	b.inrule = b.InRoute("rule")
 	b.queryrule = b.QueryRoute("rule")
 	b.querystate = b.QueryRoute("timeseries")
+	b.snapshot = b.SnapshotRoute()
+	b.restore = b.RestoreRoute()
 	b.inpoll = b.InRoute("poll")
 	b.quit = b.Quit()
 	b.out = b.Broadcast()
@@ -50,6 +63,7 @@ func (b *Timeseries) Setup() {
 
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *Timeseries) Run() {
+	clock := b.Clock()
 
 	var err error
 	//var path, lagStr string
@@ -59,6 +73,10 @@ func (b *Timeseries) Run() {
 	var data tsData
 	var numSamples float64
 
+	// a restored timeseries waits here until a rule tells us how many
+	// samples to keep.
+	var restored *tsData
+
 	// defaults
 	numSamples = 1
 
@@ -100,6 +118,10 @@ func (b *Timeseries) Run() {
 			data = tsData{
 				Values: make([]tsDataPoint, int(numSamples)),
 			}
+			if restored != nil {
+				data.fill(restored.Values)
+				restored = nil
+			}
 
 		case <-b.quit:
 			// quit * time.Second the block
@@ -114,7 +136,7 @@ func (b *Timeseries) Run() {
 			// deal with inbound data
 			v, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			var val float64
@@ -128,7 +150,7 @@ func (b *Timeseries) Run() {
 			}
 
 			//t := float64(time.Now().Add(-lag).Unix())
-			t := float64(time.Now().UnixNano() / 1000000)
+			t := float64(clock.Now().UnixNano() / 1000000)
 
 			d := tsDataPoint{
 				Timestamp: t,
@@ -142,6 +164,24 @@ func (b *Timeseries) Run() {
 				"Path":       path,
 				"NumSamples": numSamples,
 			}
+		case c := <-b.snapshot:
+			state, err := json.Marshal(data)
+			if err != nil {
+				b.Error(err)
+			}
+			c <- state
+		case d := <-b.restore:
+			restored = &tsData{}
+			err := json.Unmarshal(d.([]byte), restored)
+			if err != nil {
+				b.Error(err)
+				restored = nil
+				continue
+			}
+			if data.Values != nil {
+				data.fill(restored.Values)
+				restored = nil
+			}
 		case MsgChan := <-b.querystate:
 			out := map[string]interface{}{
 				"timeseries": data,
@@ -126,13 +126,13 @@ func (b *ToAMQP) Run() {
 				msgBytes, err = json.Marshal(json_msg)
 
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					continue
 				}
 			}
 
 			if len(msgBytes) == 0 {
-				b.Error("Zero byte length message")
+				b.Error("Zero byte length message", msg)
 				continue
 			}
 
@@ -151,7 +151,7 @@ func (b *ToAMQP) Run() {
 				},
 			)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 		case <-b.quit:
@@ -80,16 +80,16 @@ func (b *ToBeanstalkd) Run() {
 			// deal with inbound data
 			msgStr, err := json.Marshal(msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			if conn != nil {
 				_, err := conn.Put(0, 0, ttr, msgStr)
 				if err != nil {
-					b.Error(err.Error())
+					b.Error(err.Error(), msg)
 				}
 			} else {
-				b.Error(errors.New("Beanstalkd connection not initated or lost. Please check your beanstalkd server or block settings."))
+				b.Error(errors.New("Beanstalkd connection not initated or lost. Please check your beanstalkd server or block settings."), msg)
 			}
 		case MsgChan := <-b.queryrule:
 			// deal with a query request
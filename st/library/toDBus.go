@@ -125,7 +125,7 @@ func (b *ToDBus) Run() {
 				if v, err := util.ParseString(msg, "Signature"); err == nil {
 					_sign, err = dbus.ParseSignature(v)
 					if err != nil {
-						b.Error(err)
+						b.Error(err, msg)
 						continue
 					}
 				}
@@ -141,12 +141,12 @@ func (b *ToDBus) Run() {
 
 				args, err := util.ParseArray(msg, "args") // FIXME: rename to "Arguments"?
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					continue
 				}
 				args, err = util.DBusConv(_sign, args...)
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					continue
 				}
 
@@ -154,7 +154,7 @@ func (b *ToDBus) Run() {
 				//log.Printf("calling D-BUS method: %+v", args)
 				call := obj.Call(_name, 0, args...)
 				if call.Err != nil {
-					b.Error(call.Err)
+					b.Error(call.Err, msg)
 					// send error to the output
 					b.out <- map[string]interface{}{
 						"error": call.Err,
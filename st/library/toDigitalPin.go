@@ -100,13 +100,13 @@ func (b *ToDigitalPin) Run() {
 			}
 			valI, err := jee.Eval(tree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			val, ok := valI.(float64)
 			if !ok {
 				log.Println(msg)
-				b.Error(errors.New("couldn't assert value to a float"))
+				b.Error(errors.New("couldn't assert value to a float"), msg)
 				continue
 			}
 			if int(val) == 0 {
@@ -114,7 +114,7 @@ func (b *ToDigitalPin) Run() {
 			} else if int(val) == 1 {
 				hwio.DigitalWrite(pin, hwio.HIGH)
 			} else {
-				b.Error(errors.New("value must be 0 for LOW and 1 for HIGH"))
+				b.Error(errors.New("value must be 0 for LOW and 1 for HIGH"), msg)
 				continue
 			}
 
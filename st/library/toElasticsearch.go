@@ -74,7 +74,7 @@ func (b *ToElasticsearch) Run() {
 		case msg := <-b.in:
 			_, err := conn.Index(esIndex, esType, "", nil, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 			}
 		case <-b.quit:
 			return
@@ -310,7 +310,7 @@ func (e *ToEmail) Run() {
 		case msg := <-e.in:
 			// if no client configured, error and give up.
 			if e.client == nil {
-				e.Error("The SMTP client does not exist yet. Please update the credentials.")
+				e.Error("The SMTP client does not exist yet. Please update the credentials.", msg)
 				continue
 			}
 
@@ -319,7 +319,7 @@ func (e *ToEmail) Run() {
 			var from, to string
 			from, to, email, err = e.buildEmail(msg)
 			if err != nil {
-				e.Error(fmt.Sprint("Unable to parse message for emailing: ", err))
+				e.Error(fmt.Sprint("Unable to parse message for emailing: ", err), msg)
 				continue
 			}
 
@@ -335,19 +335,19 @@ func (e *ToEmail) Run() {
 					break
 				}
 				if err != nil {
-					e.Error(err)
+					e.Error(err, msg)
 				}
 				// attempt to reset client after each failure.
 				connected = e.resetClient()
 				if !connected {
 					// if we cannot reconnect, dont retry sending.
-					e.Error("cannot recconet")
+					e.Error("cannot recconet", msg)
 					break
 				}
 				time.Sleep(time.Duration(retries*errWait) * time.Second)
 			}
 			if !emlSent {
-				e.Error(fmt.Sprint("Unable to send email: ", err))
+				e.Error(fmt.Sprint("Unable to send email: ", err), msg)
 			}
 
 			// reset the connection and the counter every 50 msgs or if theres been a send error.
@@ -36,6 +36,12 @@ func (b *ToFile) Setup() {
 	b.out = b.Broadcast()
 }
 
+// CheckRule makes sure the rule has a Filename, without creating the file.
+func (b *ToFile) CheckRule(ruleI interface{}) error {
+	_, err := util.ParseString(ruleI, "Filename")
+	return err
+}
+
 // Run is the block's main loop. Here we listen on the different channels we set up.
 func (b *ToFile) Run() {
 	var err error
@@ -66,7 +72,7 @@ func (b *ToFile) Run() {
 			writer := bufio.NewWriter(file)
 			msgStr, err := json.Marshal(msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 			fmt.Fprintln(writer, string(msgStr))
@@ -72,17 +72,17 @@ func (b *ToHTTPGetRequest) Run() {
 			}
 			cI, err := jee.Eval(respTree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 			c, ok := cI.(blocks.MsgChan)
 			if !ok {
-				b.Error(errors.New("response path must point to a channel"))
+				b.Error(errors.New("response path must point to a channel"), msg)
 				continue
 			}
 			m, err := jee.Eval(msgTree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 			c <- m
@@ -14,6 +14,7 @@ type ToMongoDB struct {
 	queryrule chan blocks.MsgChan
 	inrule    blocks.MsgChan
 	in        blocks.MsgChan
+	flush     blocks.MsgChan
 	quit      blocks.MsgChan
 }
 
@@ -29,6 +30,7 @@ func (b *ToMongoDB) Setup() {
 	b.in = b.InRoute("in")
 	b.inrule = b.InRoute("rule")
 	b.queryrule = b.QueryRoute("rule")
+	b.flush = b.InRoute("flush")
 	b.quit = b.Quit()
 }
 
@@ -108,7 +110,7 @@ func (b *ToMongoDB) Run() {
 						// insert batch if count reaches batch size
 						err = collection.Insert(list...)
 						if err != nil {
-							b.Error(err.Error())
+							b.Error(err.Error(), msg)
 						}
 						// reset list and count
 						list = make([]interface{}, batch, batch)
@@ -118,11 +120,21 @@ func (b *ToMongoDB) Run() {
 					// mgo coolness again. No need to do a json.Marshal on the inbound.
 					err = collection.Insert(msg)
 					if err != nil {
-						b.Error(err.Error())
+						b.Error(err.Error(), msg)
 					}
 				}
 			} else {
-				b.Error(errors.New("MongoDB connection not initated or lost. Please check your MongoDB server or block settings."))
+				b.Error(errors.New("MongoDB connection not initated or lost. Please check your MongoDB server or block settings."), msg)
+			}
+		case <-b.flush:
+			// insert what there is of the current batch
+			if session != nil && count > 0 {
+				err = collection.Insert(list[:count]...)
+				if err != nil {
+					b.Error(err.Error())
+				}
+				list = make([]interface{}, batch, batch)
+				count = 0
 			}
 		case MsgChan := <-b.queryrule:
 			// deal with a query request
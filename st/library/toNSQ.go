@@ -72,7 +72,7 @@ func (b *ToNSQ) Run() {
 			}
 			msgBytes, err := json.Marshal(msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 			if len(msgBytes) == 0 {
@@ -80,7 +80,7 @@ func (b *ToNSQ) Run() {
 			}
 			err = writer.Publish(topic, msgBytes)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				break
 			}
 
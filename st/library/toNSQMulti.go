@@ -35,6 +35,7 @@ func (b *ToNSQMulti) Setup() {
 
 // connects to an NSQ topic and emits each message into streamtools.
 func (b *ToNSQMulti) Run() {
+	clock := b.Clock()
 	var err error
 	var nsqdTCPAddrs string
 	var topic string
@@ -45,10 +46,10 @@ func (b *ToNSQMulti) Run() {
 
 	conf := nsq.NewConfig()
 
-	dump := time.NewTicker(interval)
+	dump := clock.NewTicker(interval)
 	for {
 		select {
-		case <-dump.C:
+		case <-dump.C():
 			if writer == nil || len(batch) == 0 {
 				break
 			}
@@ -104,7 +105,7 @@ func (b *ToNSQMulti) Run() {
 			interval = dur
 
 			dump.Stop()
-			dump = time.NewTicker(interval)
+			dump = clock.NewTicker(interval)
 			writer, err = nsq.NewProducer(nsqdTCPAddrs, conf)
 			if err != nil {
 				b.Error(err)
@@ -119,14 +120,14 @@ func (b *ToNSQMulti) Run() {
 
 			msgByte, err := json.Marshal(msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 			}
 			batch = append(batch, msgByte)
 
 			if len(batch) > maxBatch {
 				err := writer.MultiPublish(topic, batch)
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					break
 				}
 				batch = nil
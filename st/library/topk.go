@@ -0,0 +1,312 @@
+package library
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nytlabs/streamtools/st/blocks" // blocks
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// heavyHitter is a value that may be one of the most frequent.
+type heavyHitter struct {
+	key   string
+	value interface{}
+	count float64
+	index int
+}
+
+// heavyHitters is a min-heap of the most frequent values, least frequent
+// first, so that it is quick to find the one to replace.
+type heavyHitters []*heavyHitter
+
+func (h heavyHitters) Len() int           { return len(h) }
+func (h heavyHitters) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h heavyHitters) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *heavyHitters) Push(x interface{}) {
+	hh := x.(*heavyHitter)
+	hh.index = len(*h)
+	*h = append(*h, hh)
+}
+
+func (h *heavyHitters) Pop() interface{} {
+	old := *h
+	n := len(old)
+	hh := old[n-1]
+	*h = old[0 : n-1]
+	return hh
+}
+
+// topK keeps the K most frequent values, counting values in a Count-Min
+// sketch, which never undercounts and overcounts by little for values that
+// are frequent.
+type topK struct {
+	k      int
+	width  int
+	depth  int
+	counts [][]float64
+	top    heavyHitters
+	byKey  map[string]*heavyHitter
+}
+
+func newTopK(k, width, depth int) *topK {
+	counts := make([][]float64, depth)
+	for i := range counts {
+		counts[i] = make([]float64, width)
+	}
+	return &topK{
+		k:      k,
+		width:  width,
+		depth:  depth,
+		counts: counts,
+		byKey:  map[string]*heavyHitter{},
+	}
+}
+
+// cells are where a value is counted in each row of the sketch.
+func (t *topK) cells(key string) []int {
+	x := hashValue(key)
+	h1 := x & 0xffffffff
+	h2 := x >> 32
+
+	cells := make([]int, t.depth)
+	for i := range cells {
+		cells[i] = int((h1 + uint64(i)*h2) % uint64(t.width))
+	}
+	return cells
+}
+
+func (t *topK) countOf(key string) float64 {
+	count := 0.0
+	for i, c := range t.cells(key) {
+		if i == 0 || t.counts[i][c] < count {
+			count = t.counts[i][c]
+		}
+	}
+	return count
+}
+
+// offer considers a value with the given count for the top K.
+func (t *topK) offer(key string, value interface{}, count float64) {
+	if hh, ok := t.byKey[key]; ok {
+		hh.count = count
+		heap.Fix(&t.top, hh.index)
+		return
+	}
+
+	if len(t.top) < t.k {
+		hh := &heavyHitter{
+			key:   key,
+			value: value,
+			count: count,
+		}
+		heap.Push(&t.top, hh)
+		t.byKey[key] = hh
+		return
+	}
+
+	if count > t.top[0].count {
+		least := heap.Pop(&t.top).(*heavyHitter)
+		delete(t.byKey, least.key)
+		hh := &heavyHitter{
+			key:   key,
+			value: value,
+			count: count,
+		}
+		heap.Push(&t.top, hh)
+		t.byKey[key] = hh
+	}
+}
+
+func (t *topK) add(v interface{}) error {
+	key := groupKey(v)
+	for i, c := range t.cells(key) {
+		t.counts[i][c]++
+	}
+	t.offer(key, v, t.countOf(key))
+	return nil
+}
+
+func (t *topK) merge(other sketch) error {
+	o, ok := other.(*topK)
+	if !ok || o.k != t.k || o.width != t.width || o.depth != t.depth {
+		return errors.New("can only merge a top K with the same K, Width and Depth")
+	}
+
+	for i := range o.counts {
+		for j, c := range o.counts[i] {
+			t.counts[i][j] += c
+		}
+	}
+
+	// every value that was in either top K is counted again.
+	candidates := map[string]interface{}{}
+	for _, hh := range t.top {
+		candidates[hh.key] = hh.value
+	}
+	for _, hh := range o.top {
+		candidates[hh.key] = hh.value
+	}
+	t.top = nil
+	t.byKey = map[string]*heavyHitter{}
+	for key, value := range candidates {
+		t.offer(key, value, t.countOf(key))
+	}
+	return nil
+}
+
+// sorted lists the top K, most frequent first.
+func (t *topK) sorted() []*heavyHitter {
+	top := make([]*heavyHitter, len(t.top))
+	copy(top, t.top)
+	sort.Sort(byCount(top))
+	return top
+}
+
+// byCount sorts values most frequent first. Unlike heavyHitters, it leaves
+// their places in the heap alone.
+type byCount []*heavyHitter
+
+func (h byCount) Len() int      { return len(h) }
+func (h byCount) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h byCount) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count > h[j].count
+	}
+	return h[i].key < h[j].key
+}
+
+func (t *topK) estimate() interface{} {
+	top := []interface{}{}
+	for _, hh := range t.sorted() {
+		top = append(top, map[string]interface{}{
+			"Value": hh.value,
+			"Count": hh.count,
+		})
+	}
+	return map[string]interface{}{
+		"TopK": top,
+	}
+}
+
+func (t *topK) state() interface{} {
+	counts := make([]interface{}, t.depth)
+	for i, row := range t.counts {
+		r := make([]interface{}, t.width)
+		for j, c := range row {
+			r[j] = c
+		}
+		counts[i] = r
+	}
+
+	top := []interface{}{}
+	for _, hh := range t.sorted() {
+		top = append(top, hh.value)
+	}
+
+	return map[string]interface{}{
+		"K":      float64(t.k),
+		"Width":  float64(t.width),
+		"Depth":  float64(t.depth),
+		"Counts": counts,
+		"Top":    top,
+	}
+}
+
+type topKKind struct{}
+
+// we need to build a simple factory so that streamtools can make new blocks of this kind
+func NewTopK() blocks.BlockInterface {
+	return &Sketch{kind: topKKind{}}
+}
+
+func (topKKind) desc() string {
+	return "estimates the most frequent values at a path, in bounded memory, with a Count-Min sketch"
+}
+
+// parseTopKSize reads the K, Width and Depth of a top K, from a rule or a
+// sketch's state.
+func parseTopKSize(rule interface{}) (int, int, int, error) {
+	size := map[string]int{
+		"K":     10,
+		"Width": 2048,
+		"Depth": 5,
+	}
+	for _, key := range []string{"K", "Width", "Depth"} {
+		if !util.KeyExists(rule, key) {
+			continue
+		}
+		n, err := util.ParseInt(rule, key)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if n <= 0 {
+			return 0, 0, 0, errors.New(fmt.Sprintf("%s must be positive, not %d", key, n))
+		}
+		size[key] = n
+	}
+	return size["K"], size["Width"], size["Depth"], nil
+}
+
+func (topKKind) parseRule(rule interface{}) (func() sketch, map[string]interface{}, error) {
+	k, width, depth, err := parseTopKSize(rule)
+	if err != nil {
+		return nil, nil, err
+	}
+	newSketch := func() sketch {
+		return newTopK(k, width, depth)
+	}
+	return newSketch, map[string]interface{}{
+		"K":     float64(k),
+		"Width": float64(width),
+		"Depth": float64(depth),
+	}, nil
+}
+
+func (topKKind) parseState(state interface{}) (sketch, error) {
+	if _, ok := state.(map[string]interface{}); !ok {
+		return nil, errors.New("the Sketch is not an object")
+	}
+	k, width, depth, err := parseTopKSize(state)
+	if err != nil {
+		return nil, err
+	}
+	t := newTopK(k, width, depth)
+
+	rows, err := util.ParseArray(state, "Counts")
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != depth {
+		return nil, errors.New("the Sketch has the wrong number of rows of Counts")
+	}
+	for i, rowI := range rows {
+		row, ok := rowI.([]interface{})
+		if !ok || len(row) != width {
+			return nil, errors.New("the Sketch has the wrong number of Counts in a row")
+		}
+		for j, c := range row {
+			t.counts[i][j], ok = c.(float64)
+			if !ok {
+				return nil, errors.New("the Sketch's Counts must be numbers")
+			}
+		}
+	}
+
+	top, err := util.ParseArray(state, "Top")
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range top {
+		key := groupKey(v)
+		t.offer(key, v, t.countOf(key))
+	}
+	return t, nil
+}
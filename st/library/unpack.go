@@ -96,20 +96,20 @@ func (b *Unpack) Run() {
 
 			arrInterface, err := jee.Eval(arrayTree, msg)
 			if err != nil {
-				b.Error(err)
+				b.Error(err, msg)
 				continue
 			}
 
 			arr, ok := arrInterface.([]interface{})
 			if !ok {
-				b.Error(errors.New("cannot assert " + arrayPath + " to array"))
+				b.Error(errors.New("cannot assert "+arrayPath+" to array"), msg)
 				continue
 			}
 
 			if labelTree != nil {
 				label, err = jee.Eval(labelTree, msg)
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					continue
 				}
 			}
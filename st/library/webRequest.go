@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 
@@ -27,6 +28,24 @@ func parseHeaders(headerRule map[string]interface{}) (map[string]string, error)
 	return t, nil
 }
 
+// failedMsg is what is emitted on the failed route: as much of the response
+// as there was, what went wrong, and the message that made the request, so
+// that it can be retried.
+func failedMsg(msg interface{}, resp *http.Response, body interface{}, err error) map[string]interface{} {
+	failed := map[string]interface{}{
+		"body":    body,
+		"headers": nil,
+		"status":  "",
+		"error":   err.Error(),
+		"msg":     msg,
+	}
+	if resp != nil {
+		failed["headers"] = resp.Header
+		failed["status"] = resp.Status
+	}
+	return failed
+}
+
 // specify those channels we're going to use to communicate with streamtools
 type WebRequest struct {
 	blocks.Block
@@ -35,6 +54,8 @@ type WebRequest struct {
 	inpoll    blocks.MsgChan
 	in        blocks.MsgChan
 	out       blocks.MsgChan
+	ok        blocks.MsgChan
+	failed    blocks.MsgChan
 	quit      blocks.MsgChan
 }
 
@@ -46,11 +67,13 @@ func NewWebRequest() blocks.BlockInterface {
 // Setup is called once before running the block. We build up the channels and specify what kind of block this is.
 func (b *WebRequest) Setup() {
 	b.Kind = "Network I/O"
-	b.Desc = "Makes requests to a given URL with specified HTTP method."
+	b.Desc = "Makes requests to a given URL with specified HTTP method, emitting responses on ok or failed by status instead if SplitByStatus is set"
 	b.in = b.InRoute("in")
 	b.inrule = b.InRoute("rule")
 	b.queryrule = b.QueryRoute("rule")
 	b.out = b.Broadcast()
+	b.ok = b.OutRoute("ok")
+	b.failed = b.OutRoute("failed")
 	b.quit = b.Quit()
 }
 
@@ -78,6 +101,7 @@ func (b *WebRequest) Run() {
 	}
 
 	var httpMethod string
+	var splitByStatus bool
 	headerRule := map[string]interface{}{}
 	headers, _ := parseHeaders(headerRule)
 
@@ -150,6 +174,15 @@ func (b *WebRequest) Run() {
 				continue
 			}
 
+			splitByStatus = false
+			if util.KeyExists(ruleI, "SplitByStatus") {
+				splitByStatus, err = util.ParseBool(ruleI, "SplitByStatus")
+				if err != nil {
+					b.Error(err)
+					continue
+				}
+			}
+
 			rule := ruleI.(map[string]interface{})
 			headerRuleI, ok := rule["Headers"]
 			if !ok {
@@ -172,13 +205,13 @@ func (b *WebRequest) Run() {
 			if urlTree != nil {
 				urlInterface, err := jee.Eval(urlTree, msg)
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					continue
 				}
 				// use the url found via rule.UrlPath in the request
 				requestUrl, ok = urlInterface.(string)
 				if !ok {
-					b.Error(errors.New("couldn't assert url to a string"))
+					b.Error(errors.New("couldn't assert url to a string"), msg)
 					continue
 				}
 			}
@@ -191,25 +224,25 @@ func (b *WebRequest) Run() {
 			if httpMethod == "POST" || httpMethod == "PUT" {
 				bodyInterface, err := jee.Eval(bodyTree, msg)
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					continue
 				}
 				requestBody, err := json.Marshal(bodyInterface)
 				if err != nil {
-					b.Error(errors.New("couldn't marshal body"))
+					b.Error(errors.New("couldn't marshal body"), msg)
 					continue
 				}
 
 				req, err = http.NewRequest(httpMethod, requestUrl, bytes.NewReader(requestBody))
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					break
 				}
 
 			} else {
 				req, err = http.NewRequest(httpMethod, requestUrl, nil)
 				if err != nil {
-					b.Error(err)
+					b.Error(err, msg)
 					break
 				}
 			}
@@ -222,16 +255,26 @@ func (b *WebRequest) Run() {
 				}
 			}
 
+			// with SplitByStatus, requests that fail are emitted on failed
+			// rather than the error route.
 			resp, err := client.Do(req)
 			if err != nil {
-				b.Error(err)
+				if splitByStatus {
+					b.failed <- failedMsg(msg, nil, nil, err)
+				} else {
+					b.Error(err, msg)
+				}
 				break
 			}
 			defer resp.Body.Close()
 
 			body, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
-				b.Error(err)
+				if splitByStatus {
+					b.failed <- failedMsg(msg, resp, nil, err)
+				} else {
+					b.Error(err, msg)
+				}
 				break
 			}
 
@@ -248,15 +291,23 @@ func (b *WebRequest) Run() {
 				"status":  resp.Status,
 			}
 
-			b.out <- outMsg
+			switch {
+			case !splitByStatus:
+				b.out <- outMsg
+			case resp.StatusCode >= 200 && resp.StatusCode < 300:
+				b.ok <- outMsg
+			default:
+				b.failed <- failedMsg(msg, resp, responseBody, errors.New(fmt.Sprintf("request failed with status %s", resp.Status)))
+			}
 
 		case resp := <-b.queryrule:
 			resp <- map[string]interface{}{
-				"Url":      url,
-				"UrlPath":  urlPath,
-				"BodyPath": bodyPath,
-				"Method":   httpMethod,
-				"Headers":  headerRule,
+				"Url":           url,
+				"UrlPath":       urlPath,
+				"BodyPath":      bodyPath,
+				"Method":        httpMethod,
+				"Headers":       headerRule,
+				"SplitByStatus": splitByStatus,
 			}
 		}
 	}
@@ -2,19 +2,30 @@ package main
 
 import (
 	"flag"
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/engine"
 	"github.com/nytlabs/streamtools/st/library"
 	"github.com/nytlabs/streamtools/st/loghub"
 	"github.com/nytlabs/streamtools/st/server"
 	"github.com/nytlabs/streamtools/st/util"
 	"log"
 	"os"
+	"time"
 )
 
 var (
 	// port that streamtools reuns on
-	port    = flag.String("port", "7070", "streamtools port")
-	domain  = flag.String("domain", "127.0.0.1", "streamtools domain")
-	version = flag.Bool("version", false, "prints current streamtools version")
+	port     = flag.String("port", "7070", "streamtools port")
+	domain   = flag.String("domain", "127.0.0.1", "streamtools domain")
+	version  = flag.Bool("version", false, "prints current streamtools version")
+	spillDir = flag.String("spill-dir", os.TempDir(), "directory for messages spilled by blocks that cannot keep up")
+	restarts = flag.Int("max-restarts", 5, "times a block that panics is restarted before it is marked failed, -1 for no limit")
+	stateDir = flag.String("state-dir", "", "directory blocks save their state to, so that it survives a restart")
+	interval = flag.Duration("snapshot-interval", 1*time.Minute, "how often blocks save their state to the state directory")
+	pattern  = flag.String("pattern-file", "", "file the running pattern is saved to after every change, and loaded from on start")
+	history  = flag.Int("pattern-history", 10, "number of previous versions of the pattern file to keep")
+	plugins  = flag.String("plugin-dir", "", "directory of executables that provide plugin blocks")
+	settle   = flag.Duration("settle-time", 500*time.Millisecond, "how long a pattern run by st run or st test has to go without a message moving to count as drained")
 )
 
 func main() {
@@ -27,13 +38,55 @@ func main() {
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
+	blocks.SpillDir = *spillDir
+	blocks.MaxRestarts = *restarts
+	blocks.StateDir = *stateDir
+	if *stateDir != "" {
+		if err := os.MkdirAll(*stateDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *interval <= 0 {
+		log.Fatal("snapshot-interval must be positive")
+	}
+	blocks.SnapshotInterval = *interval
+
+	if *plugins != "" {
+		if err := library.LoadPlugins(*plugins); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	library.Start()
 	loghub.Start()
 
+	// st run pattern.json runs a pattern to completion instead of serving
+	// it, and st test spec.json tests one.
+	engine.SettleTime = *settle
+	switch flag.Arg(0) {
+	case "run":
+		if flag.NArg() != 2 {
+			log.Fatal("usage: st [options] run pattern.json")
+		}
+		os.Exit(runPattern(flag.Arg(1)))
+	case "test":
+		os.Exit(testPatterns(flag.Args()[1:]))
+	}
+
 	s := server.NewServer()
+	s.PatternFile = *pattern
+	s.History = *history
 
-	for _, file := range flag.Args() {
-		s.ImportFile(file)
+	// a saved pattern carries on from where the last run left off, so the
+	// patterns given on the command line have already been imported into it.
+	loaded, err := s.LoadPattern()
+	if err != nil {
+		log.Fatalf("could not load %s: %s", *pattern, err.Error())
+	}
+	if !loaded {
+		for _, file := range flag.Args() {
+			s.ImportFile(file)
+		}
 	}
 
 	s.Id = "SERVER"
@@ -0,0 +1,96 @@
+package patterntest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes the results of the specs as JUnit XML, which CI servers
+// know how to show. Each spec is a test suite.
+func WriteJUnit(w io.Writer, results []*SuiteResult) error {
+	doc := junitSuites{}
+
+	for _, s := range results {
+		suite := junitSuite{
+			Name:  s.Name,
+			Tests: len(s.Results),
+			Time:  seconds(s.Time),
+		}
+
+		for _, r := range s.Results {
+			c := junitCase{
+				Name:      r.Name,
+				Classname: s.Name,
+				Time:      seconds(r.Time),
+			}
+
+			switch {
+			case r.Error != "":
+				suite.Errors++
+				c.Error = &junitProblem{
+					Message: r.Error,
+					Text:    r.Error,
+				}
+			case len(r.Failures) > 0:
+				suite.Failures++
+				c.Failure = &junitProblem{
+					Message: r.Failures[0],
+					Text:    strings.Join(r.Failures, "\n"),
+				}
+			}
+
+			suite.Cases = append(suite.Cases, c)
+		}
+
+		doc.Suites = append(doc.Suites, suite)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
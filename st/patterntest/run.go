@@ -0,0 +1,370 @@
+package patterntest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/nytlabs/gojee"
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/engine"
+	"github.com/nytlabs/streamtools/st/util"
+)
+
+// Result is how a test went.
+type Result struct {
+	Name     string
+	Failures []string      // expectations that didn't hold
+	Error    string        // what stopped the test from running, if anything
+	Time     time.Duration // how long the test took
+}
+
+// Passed reports whether the test ran and everything it expected held.
+func (r *Result) Passed() bool {
+	return r.Error == "" && len(r.Failures) == 0
+}
+
+// SuiteResult is how the tests in a spec went.
+type SuiteResult struct {
+	Name    string
+	Results []*Result
+	Time    time.Duration
+}
+
+// Failed counts the tests that didn't pass.
+func (s *SuiteResult) Failed() int {
+	failed := 0
+	for _, r := range s.Results {
+		if !r.Passed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// received collects what a block emits on one of its out routes.
+type received struct {
+	blockId string
+	subId   string
+	c       chan *blocks.Msg
+	mu      sync.Mutex
+	msgs    []interface{}
+	stop    chan bool
+}
+
+func (r *received) collect() {
+	for {
+		select {
+		case msg := <-r.c:
+			r.mu.Lock()
+			r.msgs = append(r.msgs, msg.Msg)
+			r.mu.Unlock()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Run runs every test in the spec.
+func (s *Spec) Run() *SuiteResult {
+	start := time.Now()
+	suite := &SuiteResult{
+		Name: s.Name,
+	}
+
+	for i, t := range s.Tests {
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("test %d", i)
+		}
+
+		testStart := time.Now()
+		failures, err := s.runTest(t)
+		r := &Result{
+			Name:     name,
+			Failures: failures,
+			Time:     time.Since(testStart),
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		suite.Results = append(suite.Results, r)
+	}
+
+	suite.Time = time.Since(start)
+	return suite
+}
+
+// runTest runs a test on a pattern of its own and returns the expectations
+// that didn't hold.
+func (s *Spec) runTest(t *Test) ([]string, error) {
+	start := time.Unix(0, 0).UTC()
+	if s.Start != "" {
+		var err error
+		start, err = time.Parse(time.RFC3339, s.Start)
+		if err != nil {
+			return nil, err
+		}
+	}
+	clock := blocks.NewManualClock(start)
+
+	// importing a pattern changes it, and every test needs a fresh copy.
+	data, err := json.Marshal(s.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	var p engine.Pattern
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing can be dropped, or the results would depend on how fast the
+	// machine running the test is.
+	for _, block := range p.Blocks {
+		if block != nil && block.Overflow == "" {
+			block.Overflow = blocks.BACKPRESSURE
+		}
+	}
+
+	e := engine.New()
+	e.SetClock(clock)
+
+	_, _, err = e.Import(&p)
+	if err != nil {
+		return nil, err
+	}
+	defer e.Clear()
+
+	var subs []*received
+	defer func() {
+		for _, r := range subs {
+			close(r.stop)
+			e.Unsubscribe(r.blockId, r.subId, r.c)
+		}
+	}()
+
+	for _, x := range t.Expect {
+		if x.Route == "" {
+			x.Route = "out"
+		}
+
+		c, subId, err := e.Subscribe(x.Block, x.Route)
+		if err != nil {
+			return nil, err
+		}
+
+		r := &received{
+			blockId: x.Block,
+			subId:   subId,
+			c:       c,
+			stop:    make(chan bool),
+		}
+		subs = append(subs, r)
+		go r.collect()
+	}
+
+	// let the blocks take their rules before anything else happens.
+	err = e.Drain()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, step := range t.Steps {
+		err = s.runStep(e, clock, step)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("step %d: %s", i, err.Error()))
+		}
+
+		err = e.Drain()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var failures []string
+	for i, x := range t.Expect {
+		subs[i].mu.Lock()
+		msgs := subs[i].msgs
+		subs[i].mu.Unlock()
+
+		for _, f := range check(x, msgs) {
+			failures = append(failures, fmt.Sprintf("%s %s: %s", x.Block, x.Route, f))
+		}
+	}
+
+	return failures, nil
+}
+
+// runStep carries out a step.
+func (s *Spec) runStep(e *engine.Engine, clock *blocks.ManualClock, step *Step) error {
+	if step.Advance != "" {
+		d, err := time.ParseDuration(step.Advance)
+		if err != nil {
+			return err
+		}
+		clock.Advance(d)
+		return nil
+	}
+
+	route := step.Route
+	if route == "" {
+		route = "in"
+	}
+
+	msgs := step.Msgs
+	if step.File != "" {
+		fromFile, err := readMsgs(s.path(step.File))
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, fromFile...)
+	}
+
+	for _, msg := range msgs {
+		err := e.Send(step.Block, route, msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readMsgs reads a file of JSON messages, one per line.
+func readMsgs(filename string) ([]interface{}, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var msg interface{}
+		err := json.Unmarshal(line, &msg)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Cannot read %s: %s", filename, err.Error()))
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, scanner.Err()
+}
+
+// normalize makes a message look as it would if it had been read from JSON,
+// so that it can be compared with the messages in a spec.
+func normalize(msg interface{}) interface{} {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return msg
+	}
+
+	var n interface{}
+	err = json.Unmarshal(data, &n)
+	if err != nil {
+		return msg
+	}
+	return n
+}
+
+// check lists how the messages that arrived fall short of what was expected.
+func check(x *Expect, msgs []interface{}) []string {
+	var failures []string
+
+	got := make([]interface{}, len(msgs))
+	for i, msg := range msgs {
+		got[i] = normalize(msg)
+	}
+
+	if x.Count != nil && len(got) != *x.Count {
+		failures = append(failures, fmt.Sprintf("expected %d messages, got %d", *x.Count, len(got)))
+	}
+
+	if x.Msgs != nil {
+		failures = append(failures, checkMsgs(x, got)...)
+	}
+
+	if x.Match != "" {
+		tree, err := util.BuildTokenTree(x.Match)
+		if err != nil {
+			return append(failures, fmt.Sprintf("bad expression %s: %s", x.Match, err.Error()))
+		}
+
+		for _, msg := range got {
+			v, err := jee.Eval(tree, msg)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %s", x.Match, err.Error()))
+				continue
+			}
+			if ok, _ := v.(bool); !ok {
+				failures = append(failures, fmt.Sprintf("%s does not hold for %s", x.Match, show(msg)))
+			}
+		}
+	}
+
+	return failures
+}
+
+// checkMsgs compares the messages that arrived with the ones expected.
+func checkMsgs(x *Expect, got []interface{}) []string {
+	var failures []string
+
+	if x.Ordered {
+		for i, want := range x.Msgs {
+			if i >= len(got) {
+				failures = append(failures, fmt.Sprintf("message %d: expected %s, got nothing", i, show(want)))
+				continue
+			}
+			if !reflect.DeepEqual(normalize(want), got[i]) {
+				failures = append(failures, fmt.Sprintf("message %d: expected %s, got %s", i, show(want), show(got[i])))
+			}
+		}
+		for i := len(x.Msgs); i < len(got); i++ {
+			failures = append(failures, fmt.Sprintf("message %d: unexpected %s", i, show(got[i])))
+		}
+		return failures
+	}
+
+	used := make([]bool, len(got))
+	for _, want := range x.Msgs {
+		found := false
+		for i, msg := range got {
+			if !used[i] && reflect.DeepEqual(normalize(want), msg) {
+				used[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("expected %s, which never arrived", show(want)))
+		}
+	}
+
+	for i, msg := range got {
+		if !used[i] {
+			failures = append(failures, fmt.Sprintf("unexpected %s", show(msg)))
+		}
+	}
+
+	return failures
+}
+
+func show(msg interface{}) string {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Sprint(msg)
+	}
+	return string(data)
+}
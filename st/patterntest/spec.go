@@ -0,0 +1,100 @@
+// Package patterntest tests patterns. A spec describes a pattern and a set of
+// tests. Each test runs the pattern on its own, in simulated time: it sends
+// fixture messages to the pattern's blocks, moves the clock on, and checks
+// what the blocks emitted against what it expects.
+package patterntest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/nytlabs/streamtools/st/engine"
+)
+
+// Spec is a pattern and the tests to run on it.
+type Spec struct {
+	Name        string
+	Pattern     *engine.Pattern // the pattern, as /export gives it
+	PatternFile string          // or a file holding it
+	Start       string          // when the clock starts, RFC3339; the epoch by default
+	Tests       []*Test
+	dir         string
+}
+
+// Test is a series of steps and what the blocks should have emitted by the
+// end of them.
+type Test struct {
+	Name   string
+	Steps  []*Step
+	Expect []*Expect
+}
+
+// Step sends messages to one of a block's in routes, or moves the clock on.
+// The pattern is given time to settle after each step.
+type Step struct {
+	Block   string        // the block to send to
+	Route   string        // the in route to send to, "in" by default
+	Msgs    []interface{} // messages to send
+	File    string        // a file of messages to send, one JSON message per line
+	Advance string        // a duration string to move the clock on by
+}
+
+// Expect is what a test expects to have come out of one of a block's out
+// routes. Every part of it that is set has to hold.
+type Expect struct {
+	Block   string        // the block to check
+	Route   string        // the out route to check, "out" by default
+	Msgs    []interface{} // exactly the messages that have to arrive
+	Ordered bool          // the Msgs have to arrive in order
+	Count   *int          // how many messages have to arrive
+	Match   string        // a gojee expression every message has to satisfy
+}
+
+// Load reads a spec from a JSON file. Files named in the spec are relative
+// to it.
+func Load(filename string) (*Spec, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Spec
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot read spec %s: %s", filename, err.Error()))
+	}
+
+	s.dir = filepath.Dir(filename)
+	if s.Name == "" {
+		s.Name = filepath.Base(filename)
+	}
+
+	if s.Pattern == nil && s.PatternFile != "" {
+		data, err := ioutil.ReadFile(s.path(s.PatternFile))
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(data, &s.Pattern)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Cannot read pattern %s: %s", s.PatternFile, err.Error()))
+		}
+	}
+
+	if s.Pattern == nil {
+		return nil, errors.New(fmt.Sprintf("Cannot read spec %s: it has no pattern", filename))
+	}
+
+	return &s, nil
+}
+
+// path finds a file named in the spec.
+func (s *Spec) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.dir, name)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/engine"
+)
+
+// runPattern runs the pattern in file to completion without the server and
+// returns the status st should exit with: 1 if any block reported an error.
+func runPattern(file string) int {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	var p engine.Pattern
+	if err := json.Unmarshal(data, &p); err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	// a batch has to see every message, so blocks hold back the blocks that
+	// send to them rather than drop what they can't keep up with.
+	for _, block := range p.Blocks {
+		if block != nil && block.Overflow == "" {
+			block.Overflow = blocks.BACKPRESSURE
+		}
+	}
+
+	e := engine.New()
+	if _, _, err := e.Import(&p); err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	errs, err := e.Run()
+	e.Clear()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	if errs > 0 {
+		log.Printf("Pattern %s finished with %d errors", file, errs)
+		return 1
+	}
+
+	log.Printf("Pattern %s finished", file)
+	return 0
+}
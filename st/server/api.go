@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -10,12 +11,13 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/nytlabs/streamtools/st/blocks"
-	"github.com/nytlabs/streamtools/st/library"
+	"github.com/nytlabs/streamtools/st/engine"
 	"github.com/nytlabs/streamtools/st/loghub"
 	"github.com/nytlabs/streamtools/st/util"
 )
@@ -35,15 +37,18 @@ var uiStream = hub{
 }
 
 type Server struct {
-	manager *BlockManager
-	Port    string
-	Domain  string
-	Id      string
+	engine      *engine.Engine
+	mu          sync.Mutex // held while handling a request, so that changes and saving them go together
+	Port        string
+	Domain      string
+	Id          string
+	PatternFile string // where the running pattern is saved, if anywhere
+	History     int    // how many saved versions of the pattern to keep
 }
 
 func NewServer() *Server {
 	return &Server{
-		manager: NewBlockManager(),
+		engine: engine.New(),
 	}
 }
 
@@ -76,7 +81,10 @@ func (s *Server) staticHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) libraryHandler(w http.ResponseWriter, r *http.Request) {
-	lib, err := json.Marshal(library.BlockDefs)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lib, err := json.Marshal(s.engine.Library())
 	if err != nil {
 		loghub.Log <- &loghub.LogMsg{
 			Type: loghub.CREATE,
@@ -93,20 +101,24 @@ func (s *Server) versionHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) clearHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	conns := s.manager.ListConnections()
-	for _, v := range conns {
-		id, err := s.manager.DeleteConnection(v.Id)
-		if err != nil {
-			loghub.Log <- &loghub.LogMsg{
-				Type: loghub.DELETE,
-				Data: err.Error(),
-				Id:   s.Id,
-			}
-		}
+	s.clear()
+	s.persist()
 
+	s.apiWrap(w, r, 200, s.response("OK"))
+}
+
+// clear deletes every block and connection.
+func (s *Server) clear() {
+	conns, blocks := s.engine.Clear()
+	s.announceClear(conns, blocks)
+}
+
+// announceClear tells the log and the UI about deleted connections and blocks.
+func (s *Server) announceClear(conns []string, blocks []string) {
+	for _, id := range conns {
 		loghub.UI <- &loghub.LogMsg{
 			Type: loghub.DELETE,
 			Data: struct {
@@ -118,35 +130,8 @@ func (s *Server) clearHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	blocks := s.manager.ListBlocks()
-	for _, v := range blocks {
-		ids, err := s.manager.DeleteBlock(v.Id)
-		if err != nil {
-			loghub.Log <- &loghub.LogMsg{
-				Type: loghub.DELETE,
-				Data: err.Error(),
-				Id:   s.Id,
-			}
-			continue
-		}
-
-		for _, id := range ids {
-			loghub.Log <- &loghub.LogMsg{
-				Type: loghub.DELETE,
-				Data: fmt.Sprintf("Block %s", id),
-				Id:   s.Id,
-			}
-
-			loghub.UI <- &loghub.LogMsg{
-				Type: loghub.DELETE,
-				Data: struct {
-					Id string
-				}{
-					id,
-				},
-				Id: s.Id,
-			}
-		}
+	for _, id := range blocks {
+		s.announceDelete("Block", id)
 	}
 
 	loghub.Log <- &loghub.LogMsg{
@@ -154,8 +139,6 @@ func (s *Server) clearHandler(w http.ResponseWriter, r *http.Request) {
 		Data: fmt.Sprintf("Go routines: %d", runtime.NumGoroutine()),
 		Id:   s.Id,
 	}
-
-	s.apiWrap(w, r, 200, s.response("OK"))
 }
 
 // serveLogStream handles websocket connections for the streamtools log.
@@ -212,9 +195,7 @@ func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	c := &connection{send: make(chan []byte, 256), ws: ws}
 
-	s.manager.Mu.Lock()
-	blockChan, connId, err := s.manager.GetSocket(vars["id"])
-	s.manager.Mu.Unlock()
+	blockChan, connId, err := s.engine.Subscribe(vars["id"], r.URL.Query().Get("route"))
 
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
@@ -224,9 +205,7 @@ func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
 	ticker := time.NewTicker((10 * time.Second * 9) / 10)
 	go func(c *connection, bChan chan *blocks.Msg, cId string, bId string) {
 		defer func() {
-			s.manager.Mu.Lock()
-			_ = s.manager.DeleteSocket(bId, cId)
-			s.manager.Mu.Unlock()
+			_ = s.engine.Unsubscribe(bId, cId, bChan)
 			ticker.Stop()
 			c.ws.Close()
 		}()
@@ -264,9 +243,7 @@ func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
 		s.apiWrap(w, r, 500, s.response("must specify block ID to connect"))
 		return
 	}
-	s.manager.Mu.Lock()
-	blockChan, connId, err := s.manager.GetSocket(blockId)
-	s.manager.Mu.Unlock()
+	blockChan, connId, err := s.engine.Subscribe(blockId, r.URL.Query().Get("route"))
 
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
@@ -279,9 +256,7 @@ func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
 		_, err := w.Write(message)
 		_, err = w.Write([]byte("\r\n"))
 		if err != nil {
-			s.manager.Mu.Lock()
-			s.manager.DeleteSocket(blockId, connId)
-			s.manager.Mu.Unlock()
+			s.engine.Unsubscribe(blockId, connId, blockChan)
 			break
 		}
 		if f, ok := w.(http.Flusher); ok {
@@ -358,8 +333,8 @@ func (s *Server) serveUIStream(w http.ResponseWriter, r *http.Request) {
 					return
 				case "export":
 					// emit block configuration on message
-					s.manager.Mu.Lock()
-					for _, v := range s.manager.ListBlocks() {
+					s.mu.Lock()
+					for _, v := range s.engine.ListBlocks() {
 						out, _ := json.Marshal(struct {
 							Type string
 							Data interface{}
@@ -373,7 +348,7 @@ func (s *Server) serveUIStream(w http.ResponseWriter, r *http.Request) {
 							return
 						}
 					}
-					for _, v := range s.manager.ListConnections() {
+					for _, v := range s.engine.ListConnections() {
 						out, _ := json.Marshal(struct {
 							Type string
 							Data interface{}
@@ -387,7 +362,7 @@ func (s *Server) serveUIStream(w http.ResponseWriter, r *http.Request) {
 							return
 						}
 					}
-					s.manager.Mu.Unlock()
+					s.mu.Unlock()
 				case "rule":
 					_, ok := msg["id"]
 					if !ok {
@@ -397,9 +372,9 @@ func (s *Server) serveUIStream(w http.ResponseWriter, r *http.Request) {
 					if !ok {
 						break
 					}
-					s.manager.Mu.Lock()
-					b, _ := s.manager.GetBlock(idStr)
-					s.manager.Mu.Unlock()
+					s.mu.Lock()
+					b, _ := s.engine.GetBlock(idStr)
+					s.mu.Unlock()
 					out, _ := json.Marshal(struct {
 						Type string
 						Data interface{}
@@ -434,7 +409,7 @@ func (s *Server) ImportFile(filename string) {
 		}
 	}
 
-	err = s.importJSON(b)
+	err = s.importJSON(b, true)
 	if err != nil {
 		loghub.Log <- &loghub.LogMsg{
 			Type: loghub.ERROR,
@@ -444,76 +419,39 @@ func (s *Server) ImportFile(filename string) {
 	}
 }
 
-func (s *Server) importJSON(body []byte) error {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+// importJSON imports a pattern, saving the result to the pattern file if save
+// is set.
+func (s *Server) importJSON(body []byte, save bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	var export struct {
-		Blocks      []*BlockInfo
-		Connections []*ConnectionInfo
-	}
-
-	corrected := make(map[string]string)
-
-	err := json.Unmarshal(body, &export)
+	var p engine.Pattern
+	err := json.Unmarshal(body, &p)
 	if err != nil {
 		return err
 	}
 
-	for _, block := range export.Blocks {
-		corrected[block.Id] = block.Id
-		for s.manager.IdExists(corrected[block.Id]) {
-			corrected[block.Id] = block.Id + "_" + s.manager.GetId()
-		}
+	blocks, conns, err := s.engine.Import(&p)
+	if err != nil {
+		return err
 	}
 
-	for _, conn := range export.Connections {
-		corrected[conn.Id] = conn.Id
-		for s.manager.IdExists(corrected[conn.Id]) {
-			corrected[conn.Id] = conn.Id + "_" + s.manager.GetId()
-		}
+	s.announceImport(blocks, conns)
+	if save {
+		s.persist()
 	}
+	return nil
+}
 
-	for _, block := range export.Blocks {
-		block.Id = corrected[block.Id]
-		eblock, err := s.manager.Create(block)
-		if err != nil {
-			return err
-		}
-
-		loghub.UI <- &loghub.LogMsg{
-			Type: loghub.CREATE,
-			Data: eblock,
-			Id:   s.Id,
-		}
-
-		loghub.Log <- &loghub.LogMsg{
-			Type: loghub.CREATE,
-			Data: fmt.Sprintf("Block %s", block.Id),
-			Id:   s.Id,
-		}
+// announceImport tells the log and the UI about the blocks and connections an
+// import created, once all of them exist.
+func (s *Server) announceImport(blocks []*engine.BlockInfo, conns []*engine.ConnectionInfo) {
+	for _, block := range blocks {
+		s.announceCreate("Block", block.Id, block)
 	}
 
-	for _, conn := range export.Connections {
-		conn.Id = corrected[conn.Id]
-		conn.FromId = corrected[conn.FromId]
-		conn.ToId = corrected[conn.ToId]
-		econn, err := s.manager.Connect(conn)
-		if err != nil {
-			return err
-		}
-
-		loghub.Log <- &loghub.LogMsg{
-			Type: loghub.CREATE,
-			Data: fmt.Sprintf("Connection %s", conn.Id),
-			Id:   s.Id,
-		}
-
-		loghub.UI <- &loghub.LogMsg{
-			Type: loghub.CREATE,
-			Data: econn,
-			Id:   s.Id,
-		}
+	for _, conn := range conns {
+		s.announceCreate("Connection", conn.Id, conn)
 	}
 
 	loghub.Log <- &loghub.LogMsg{
@@ -521,7 +459,6 @@ func (s *Server) importJSON(body []byte) error {
 		Data: "Import OK",
 		Id:   s.Id,
 	}
-	return nil
 }
 
 // importHandler accepts a JSON through POST that updats the state of ST
@@ -533,7 +470,32 @@ func (s *Server) importHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.importJSON(body)
+	if r.URL.Query().Get("dryrun") == "true" {
+		var problems []string
+		var p engine.Pattern
+		if err := json.Unmarshal(body, &p); err != nil {
+			problems = []string{err.Error()}
+		} else {
+			problems = s.engine.Check(&p)
+		}
+
+		jproblems, err := json.Marshal(struct {
+			Valid    bool
+			Problems []string
+		}{
+			len(problems) == 0,
+			problems,
+		})
+		if err != nil {
+			s.apiWrap(w, r, 500, s.response(err.Error()))
+			return
+		}
+
+		s.apiWrap(w, r, 200, jproblems)
+		return
+	}
+
+	err = s.importJSON(body, true)
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 		return
@@ -544,18 +506,10 @@ func (s *Server) importHandler(w http.ResponseWriter, r *http.Request) {
 
 // exportHandler creates a JSON file representing the current block system.
 func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	export := struct {
-		Blocks      []*BlockInfo
-		Connections []*ConnectionInfo
-	}{
-		s.manager.ListBlocks(),
-		s.manager.ListConnections(),
-	}
-
-	jex, err := json.Marshal(export)
+	jex, err := s.exportJSON()
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 		return
@@ -564,12 +518,17 @@ func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
 	s.apiWrap(w, r, 200, jex)
 }
 
+// exportJSON describes the running pattern.
+func (s *Server) exportJSON() ([]byte, error) {
+	return json.Marshal(s.engine.Export())
+}
+
 // listBlockHandler retuns a slice of the current blocks operating in the sytem.
 func (s *Server) listBlockHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	blocks, err := json.Marshal(s.manager.ListBlocks())
+	blocks, err := json.Marshal(s.engine.ListBlocks())
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 		return
@@ -577,13 +536,13 @@ func (s *Server) listBlockHandler(w http.ResponseWriter, r *http.Request) {
 	s.apiWrap(w, r, 200, blocks)
 }
 
-// createBlockHandler asks the manager to create a block and then return that block
+// createBlockHandler asks the engine to create a block and then return that block
 // if the block has been creates.
 func (s *Server) createBlockHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	var block *BlockInfo
+	var block *engine.BlockInfo
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -597,7 +556,7 @@ func (s *Server) createBlockHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mblock, err := s.manager.Create(block)
+	mblock, err := s.engine.Create(block)
 
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
@@ -622,6 +581,8 @@ func (s *Server) createBlockHandler(w http.ResponseWriter, r *http.Request) {
 		Id:   s.Id,
 	}
 
+	s.persist()
+
 	jblock, err := json.Marshal(mblock)
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
@@ -634,8 +595,8 @@ func (s *Server) createBlockHandler(w http.ResponseWriter, r *http.Request) {
 // updateBlockHandler updates the coordinates of a block.
 // block.id and block.type can't be changes. block.rule is set through sendRoute
 func (s *Server) updateBlockHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	var update map[string]interface{}
 
@@ -655,12 +616,12 @@ func (s *Server) updateBlockHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if _, ok := update["X"]; ok {
-		c := &Coords{
+		c := &engine.Coords{
 			X: update["X"].(float64),
 			Y: update["Y"].(float64),
 		}
 
-		mblock, err := s.manager.UpdateBlockPosition(blockId, c)
+		mblock, err := s.engine.UpdateBlockPosition(blockId, c)
 
 		if err != nil {
 			s.apiWrap(w, r, 500, s.response(err.Error()))
@@ -681,7 +642,7 @@ func (s *Server) updateBlockHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if _, ok := update["Id"]; ok {
-		mblock, mconnections, err := s.manager.UpdateBlockId(blockId, update["Id"].(string))
+		mblock, mconnections, err := s.engine.UpdateBlockId(blockId, update["Id"].(string))
 		if err != nil {
 			s.apiWrap(w, r, 500, s.response(err.Error()))
 			return
@@ -724,7 +685,9 @@ func (s *Server) updateBlockHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	block, err := s.manager.GetBlock(blockId)
+	s.persist()
+
+	block, err := s.engine.GetBlock(blockId)
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 		return
@@ -741,12 +704,12 @@ func (s *Server) updateBlockHandler(w http.ResponseWriter, r *http.Request) {
 
 // blockInfoHandler returns a block given an id
 func (s *Server) blockInfoHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	vars := mux.Vars(r)
 
-	conn, err := s.manager.GetBlock(vars["id"])
+	conn, err := s.engine.GetBlock(vars["id"])
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 		return
@@ -760,13 +723,13 @@ func (s *Server) blockInfoHandler(w http.ResponseWriter, r *http.Request) {
 	s.apiWrap(w, r, 200, jconn)
 }
 
-// deleteBlockHandler asks the block manager to delete a block.
+// deleteBlockHandler asks the engine to delete a block.
 func (s *Server) deleteBlockHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	vars := mux.Vars(r)
-	ids, err := s.manager.DeleteBlock(vars["id"])
+	ids, err := s.engine.DeleteBlock(vars["id"])
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 		return
@@ -796,13 +759,15 @@ func (s *Server) deleteBlockHandler(w http.ResponseWriter, r *http.Request) {
 		Id:   s.Id,
 	}
 
+	s.persist()
+
 	s.apiWrap(w, r, 200, s.response("OK"))
 }
 
 // sendRouteHandler sends a message to a block's route. (unidirectional)
 func (s *Server) sendRouteHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	var msg interface{}
 	vars := mux.Vars(r)
@@ -819,7 +784,7 @@ func (s *Server) sendRouteHandler(w http.ResponseWriter, r *http.Request) {
 			"data": string(body),
 		}
 	}
-	err = s.manager.Send(vars["id"], vars["route"], msg)
+	err = s.engine.Send(vars["id"], vars["route"], msg)
 
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
@@ -832,7 +797,7 @@ func (s *Server) sendRouteHandler(w http.ResponseWriter, r *http.Request) {
 		Id:   s.Id,
 	}
 
-	/*b, err := s.manager.GetBlock(vars["id"])
+	/*b, err := s.engine.GetBlock(vars["id"])
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 	}
@@ -843,13 +808,17 @@ func (s *Server) sendRouteHandler(w http.ResponseWriter, r *http.Request) {
 		Id: s.Id,
 	}*/
 
+	if vars["route"] == "rule" {
+		s.persist()
+	}
+
 	s.apiWrap(w, r, 200, s.response("OK"))
 }
 
 // queryRouteHandler queries a block and returns a msg. (bidirectional)
 func (s *Server) queryBlockHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	vars := mux.Vars(r)
 	u, err := url.Parse(r.RequestURI)
@@ -860,7 +829,7 @@ func (s *Server) queryBlockHandler(w http.ResponseWriter, r *http.Request) {
 	params := u.Query()
 	var msg interface{}
 	if len(params) > 0 {
-		msg, err = s.manager.QueryParamBlock(vars["id"], vars["route"], params)
+		msg, err = s.engine.QueryParamBlock(vars["id"], vars["route"], params)
 		if err != nil {
 			s.apiWrap(w, r, 500, s.response(err.Error()))
 			return
@@ -868,7 +837,7 @@ func (s *Server) queryBlockHandler(w http.ResponseWriter, r *http.Request) {
 
 	} else {
 
-		msg, err = s.manager.QueryBlock(vars["id"], vars["route"])
+		msg, err = s.engine.QueryBlock(vars["id"], vars["route"])
 		if err != nil {
 			s.apiWrap(w, r, 500, s.response(err.Error()))
 			return
@@ -901,12 +870,12 @@ func (s *Server) queryBlockHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) queryConnectionHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	vars := mux.Vars(r)
 
-	msg, err := s.manager.QueryConnection(vars["id"], vars["route"])
+	msg, err := s.engine.QueryConnection(vars["id"], vars["route"])
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 		return
@@ -939,10 +908,10 @@ func (s *Server) queryConnectionHandler(w http.ResponseWriter, r *http.Request)
 
 // listConnectionHandler returns a slice of the current connections in streamtools.
 func (s *Server) listConnectionHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	conns, err := json.Marshal(s.manager.ListConnections())
+	conns, err := json.Marshal(s.engine.ListConnections())
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 		return
@@ -952,10 +921,10 @@ func (s *Server) listConnectionHandler(w http.ResponseWriter, r *http.Request) {
 
 // createConnectHandler creates a connection and returns it.
 func (s *Server) createConnectionHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	var conn *ConnectionInfo
+	var conn *engine.ConnectionInfo
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -969,7 +938,7 @@ func (s *Server) createConnectionHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	mconn, err := s.manager.Connect(conn)
+	mconn, err := s.engine.Connect(conn)
 
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
@@ -994,6 +963,8 @@ func (s *Server) createConnectionHandler(w http.ResponseWriter, r *http.Request)
 		Id:   s.Id,
 	}
 
+	s.persist()
+
 	jconn, err := json.Marshal(mconn)
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
@@ -1010,13 +981,22 @@ func (s *Server) topHandler(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
 
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	statuses := s.engine.StatusBlocks()
 	export := struct {
 		Blocks []string
+		Failed []string
 	}{
-		s.manager.StatusBlocks(),
+		[]string{},
+		[]string{},
+	}
+	for id, status := range statuses {
+		export.Blocks = append(export.Blocks, status)
+		if status == blocks.FAILED {
+			export.Failed = append(export.Failed, id)
+		}
 	}
 
 	jex, err := json.Marshal(export)
@@ -1028,6 +1008,34 @@ func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
 	s.apiWrap(w, r, 200, jex)
 }
 
+// metricsHandler reports the counters of every block in the Prometheus text
+// format.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var metrics []blockMetrics
+	for _, block := range s.engine.ListBlocks() {
+		stats, err := s.engine.BlockStats(block.Id)
+		if err != nil {
+			continue
+		}
+
+		metrics = append(metrics, blockMetrics{
+			Id:    block.Id,
+			Type:  block.Type,
+			Stats: stats,
+		})
+	}
+
+	var buf bytes.Buffer
+	writeMetrics(&buf, metrics)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(200)
+	w.Write(buf.Bytes())
+}
+
 func (s *Server) profStartHandler(w http.ResponseWriter, r *http.Request) {
 	f, err := os.Create("streamtools.prof")
 	if err != nil {
@@ -1045,12 +1053,12 @@ func (s *Server) profStopHandler(w http.ResponseWriter, r *http.Request) {
 
 // connectionInfoHandler returns a connection object, given an is.
 func (s *Server) connectionInfoHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	vars := mux.Vars(r)
 
-	conn, err := s.manager.GetConnection(vars["id"])
+	conn, err := s.engine.GetConnection(vars["id"])
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 		return
@@ -1107,11 +1115,11 @@ func (s *Server) apiWrap(w http.ResponseWriter, r *http.Request, statusCode int,
 
 // deleteConnectionHandler deletes a connection, responds with OK.
 func (s *Server) deleteConnectionHandler(w http.ResponseWriter, r *http.Request) {
-	s.manager.Mu.Lock()
-	defer s.manager.Mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	vars := mux.Vars(r)
-	id, err := s.manager.DeleteConnection(vars["id"])
+	id, err := s.engine.DeleteConnection(vars["id"])
 	if err != nil {
 		s.apiWrap(w, r, 500, s.response(err.Error()))
 		return
@@ -1139,6 +1147,8 @@ func (s *Server) deleteConnectionHandler(w http.ResponseWriter, r *http.Request)
 		Id:   s.Id,
 	}
 
+	s.persist()
+
 	s.apiWrap(w, r, 200, s.response("OK"))
 }
 
@@ -1153,6 +1163,9 @@ func (s *Server) Run() {
 	r.StrictSlash(true)
 	r.HandleFunc("/", s.rootHandler)
 	r.HandleFunc("/library", s.libraryHandler)
+	r.HandleFunc("/composites", s.listCompositesHandler).Methods("GET")
+	r.HandleFunc("/composites", s.createCompositeHandler).Methods("POST")
+	r.HandleFunc("/composites/{type}", s.deleteCompositeHandler).Methods("DELETE")
 	r.HandleFunc("/static/{type}/{file}", s.staticHandler)
 	r.HandleFunc("/log", s.serveLogStream)
 	r.HandleFunc("/ui", s.serveUIStream)
@@ -1160,12 +1173,17 @@ func (s *Server) Run() {
 	r.HandleFunc("/top", s.topHandler)
 	r.HandleFunc("/examples/{file}", s.exampleHandler)
 	r.HandleFunc("/status", s.statusHandler)
+	r.HandleFunc("/metrics", s.metricsHandler).Methods("GET")
 	r.HandleFunc("/profstart", s.profStartHandler)
 	r.HandleFunc("/profstop", s.profStopHandler)
 	r.HandleFunc("/clear", s.clearHandler).Methods("GET")
 	r.HandleFunc("/import", s.importHandler).Methods("POST")
 	r.HandleFunc("/import", s.optionsHandler).Methods("OPTIONS")
 	r.HandleFunc("/export", s.exportHandler).Methods("GET")
+	r.HandleFunc("/pattern", s.applyHandler).Methods("PUT")
+	r.HandleFunc("/pattern/history", s.historyHandler).Methods("GET")
+	r.HandleFunc("/pattern/history/{version}", s.patternVersionHandler).Methods("GET")
+	r.HandleFunc("/pattern/history/{version}", s.restoreHandler).Methods("POST")
 	r.HandleFunc("/blocks", s.listBlockHandler).Methods("GET")                         // list all blocks
 	r.HandleFunc("/blocks", s.createBlockHandler).Methods("POST")                      // create block w/o id
 	r.HandleFunc("/blocks", s.optionsHandler).Methods("OPTIONS")                       // allow cross-domain
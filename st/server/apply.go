@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/nytlabs/streamtools/st/engine"
+	"github.com/nytlabs/streamtools/st/loghub"
+)
+
+// announceDiff tells the log and the UI about the changes made by applying a
+// pattern.
+func (s *Server) announceDiff(diff *engine.PatternDiff) {
+	for _, id := range diff.DeletedConnections {
+		s.announceDelete("Connection", id)
+	}
+
+	for _, id := range diff.DeletedBlocks {
+		s.announceDelete("Block", id)
+	}
+
+	for _, id := range diff.UpdatedRules {
+		loghub.Log <- &loghub.LogMsg{
+			Type: loghub.UPDATE,
+			Data: fmt.Sprintf("Block %s", id),
+			Id:   s.Id,
+		}
+	}
+
+	for _, id := range diff.MovedBlocks {
+		block, err := s.engine.GetBlock(id)
+		if err != nil {
+			continue
+		}
+		loghub.UI <- &loghub.LogMsg{
+			Type: loghub.UPDATE_POSITION,
+			Data: block,
+			Id:   s.Id,
+		}
+	}
+
+	for _, id := range diff.CreatedBlocks {
+		block, err := s.engine.GetBlock(id)
+		if err != nil {
+			continue
+		}
+		s.announceCreate("Block", id, block)
+	}
+
+	for _, id := range diff.CreatedConnections {
+		conn, err := s.engine.GetConnection(id)
+		if err != nil {
+			continue
+		}
+		s.announceCreate("Connection", id, conn)
+	}
+}
+
+func (s *Server) announceCreate(kind string, id string, data interface{}) {
+	loghub.Log <- &loghub.LogMsg{
+		Type: loghub.CREATE,
+		Data: fmt.Sprintf("%s %s", kind, id),
+		Id:   s.Id,
+	}
+
+	loghub.UI <- &loghub.LogMsg{
+		Type: loghub.CREATE,
+		Data: data,
+		Id:   s.Id,
+	}
+}
+
+func (s *Server) announceDelete(kind string, id string) {
+	loghub.Log <- &loghub.LogMsg{
+		Type: loghub.DELETE,
+		Data: fmt.Sprintf("%s %s", kind, id),
+		Id:   s.Id,
+	}
+
+	loghub.UI <- &loghub.LogMsg{
+		Type: loghub.DELETE,
+		Data: struct {
+			Id string
+		}{
+			id,
+		},
+		Id: s.Id,
+	}
+}
+
+// applyHandler reconciles the running pattern with the one PUT to it, and
+// responds with the changes it made. With ?dryrun=true it only works them out.
+func (s *Server) applyHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	var p engine.Pattern
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	dryrun := r.URL.Query().Get("dryrun") == "true"
+	diff, err := s.engine.Apply(&p, dryrun)
+	if !dryrun && diff != nil {
+		s.announceDiff(diff)
+		s.persist()
+	}
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	jdiff, err := json.Marshal(diff)
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	s.apiWrap(w, r, 200, jdiff)
+}
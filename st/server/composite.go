@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/nytlabs/streamtools/st/library"
+	"github.com/nytlabs/streamtools/st/loghub"
+)
+
+// listCompositesHandler returns the definitions of all composite block types.
+func (s *Server) listCompositesHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	composites, err := json.Marshal(s.engine.Composites())
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	s.apiWrap(w, r, 200, composites)
+}
+
+// createCompositeHandler adds a composite block type to the library, or
+// redefines one there are no blocks of.
+func (s *Server) createCompositeHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	var d *library.CompositeDef
+	err = json.Unmarshal(body, &d)
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	err = s.engine.DefineComposite(d)
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	s.persist()
+
+	loghub.Log <- &loghub.LogMsg{
+		Type: loghub.CREATE,
+		Data: fmt.Sprintf("Composite %s", d.Type),
+		Id:   s.Id,
+	}
+
+	jdef, err := json.Marshal(s.engine.Library()[d.Type])
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	s.apiWrap(w, r, 200, jdef)
+}
+
+// deleteCompositeHandler removes a composite block type from the library.
+func (s *Server) deleteCompositeHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := mux.Vars(r)["type"]
+
+	err := s.engine.RemoveComposite(t)
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	s.persist()
+
+	loghub.Log <- &loghub.LogMsg{
+		Type: loghub.DELETE,
+		Data: fmt.Sprintf("Composite %s", t),
+		Id:   s.Id,
+	}
+
+	s.apiWrap(w, r, 200, s.response("OK"))
+}
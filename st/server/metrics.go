@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+)
+
+// blockMetrics pairs a block with its counters.
+type blockMetrics struct {
+	Id    string
+	Type  string
+	Stats blocks.Stats
+}
+
+type byId []blockMetrics
+
+func (m byId) Len() int           { return len(m) }
+func (m byId) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byId) Less(i, j int) bool { return m[i].Id < m[j].Id }
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetrics renders the counters of every block in the Prometheus text
+// exposition format.
+func writeMetrics(buf *bytes.Buffer, metrics []blockMetrics) {
+	sort.Sort(byId(metrics))
+
+	header := func(name, kind, help string) {
+		fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+	}
+
+	labels := func(m blockMetrics, route string) string {
+		l := fmt.Sprintf(`block="%s",type="%s"`, labelEscaper.Replace(m.Id), labelEscaper.Replace(m.Type))
+		if route != "" {
+			l += fmt.Sprintf(`,route="%s"`, labelEscaper.Replace(route))
+		}
+		return "{" + l + "}"
+	}
+
+	perRoute := func(name string, m blockMetrics, counts map[string]int64) {
+		routes := make([]string, 0, len(counts))
+		for route := range counts {
+			routes = append(routes, route)
+		}
+		sort.Strings(routes)
+		for _, route := range routes {
+			fmt.Fprintf(buf, "%s%s %d\n", name, labels(m, route), counts[route])
+		}
+	}
+
+	header("streamtools_block_received_total", "counter", "Messages received by a block, per in route.")
+	for _, m := range metrics {
+		perRoute("streamtools_block_received_total", m, m.Stats.Received)
+	}
+
+	header("streamtools_block_emitted_total", "counter", "Messages emitted by a block, per out route.")
+	for _, m := range metrics {
+		perRoute("streamtools_block_emitted_total", m, m.Stats.Emitted)
+	}
+
+	header("streamtools_block_errors_total", "counter", "Errors reported by a block.")
+	for _, m := range metrics {
+		fmt.Fprintf(buf, "streamtools_block_errors_total%s %d\n", labels(m, ""), m.Stats.Errors)
+	}
+
+	header("streamtools_block_dropped_total", "counter", "Messages dropped by a block's overflow policy.")
+	for _, m := range metrics {
+		fmt.Fprintf(buf, "streamtools_block_dropped_total%s %d\n", labels(m, ""), m.Stats.Dropped)
+	}
+
+	header("streamtools_block_latency_seconds", "summary", "Time messages waited on a block's in routes before being processed.")
+	for _, m := range metrics {
+		fmt.Fprintf(buf, "streamtools_block_latency_seconds_sum%s %g\n", labels(m, ""), m.Stats.Latency.Sum)
+		fmt.Fprintf(buf, "streamtools_block_latency_seconds_count%s %d\n", labels(m, ""), m.Stats.Latency.Count)
+	}
+
+	header("streamtools_block_latency_seconds_max", "gauge", "Longest time a message waited on a block's in routes.")
+	for _, m := range metrics {
+		fmt.Fprintf(buf, "streamtools_block_latency_seconds_max%s %g\n", labels(m, ""), m.Stats.Latency.Max)
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nytlabs/streamtools/st/engine"
+	"github.com/nytlabs/streamtools/st/loghub"
+)
+
+// PatternVersion is a saved version of the pattern.
+type PatternVersion struct {
+	Version string
+	Time    time.Time
+}
+
+// historyDir is where old versions of the pattern are kept, next to the
+// pattern itself.
+func (s *Server) historyDir() string {
+	return s.PatternFile + ".history"
+}
+
+// writeFileAtomic replaces filename with data, so that readers only ever see
+// the old or the new contents.
+func writeFileAtomic(filename string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// persist saves the running pattern to the pattern file, keeping the last
+// History versions around. It must be called with the server locked.
+func (s *Server) persist() {
+	if s.PatternFile == "" {
+		return
+	}
+
+	err := s.savePattern()
+	if err != nil {
+		loghub.Log <- &loghub.LogMsg{
+			Type: loghub.ERROR,
+			Data: fmt.Sprintf("Could not save pattern: %s", err.Error()),
+			Id:   s.Id,
+		}
+	}
+}
+
+func (s *Server) savePattern() error {
+	jex, err := s.exportJSON()
+	if err != nil {
+		return err
+	}
+
+	if s.History > 0 {
+		err = os.MkdirAll(s.historyDir(), 0755)
+		if err != nil {
+			return err
+		}
+
+		version := strconv.FormatInt(time.Now().UnixNano(), 10)
+		err = writeFileAtomic(filepath.Join(s.historyDir(), version+".json"), jex)
+		if err != nil {
+			return err
+		}
+
+		err = s.pruneHistory()
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(s.PatternFile, jex)
+}
+
+// history lists the saved versions of the pattern, newest first.
+func (s *Server) history() ([]PatternVersion, error) {
+	files, err := ioutil.ReadDir(s.historyDir())
+	if os.IsNotExist(err) {
+		return []PatternVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		versions = append(versions, strings.TrimSuffix(name, ".json"))
+	}
+
+	// versions are nanosecond timestamps of the same length, so they sort
+	// as strings.
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+
+	history := []PatternVersion{}
+	for _, v := range versions {
+		ns, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		history = append(history, PatternVersion{
+			Version: v,
+			Time:    time.Unix(0, ns),
+		})
+	}
+
+	return history, nil
+}
+
+// pruneHistory removes all but the last History versions of the pattern.
+func (s *Server) pruneHistory() error {
+	history, err := s.history()
+	if err != nil {
+		return err
+	}
+
+	for i := s.History; i < len(history); i++ {
+		err := os.Remove(s.versionFile(history[i].Version))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) versionFile(version string) string {
+	return filepath.Join(s.historyDir(), version+".json")
+}
+
+// readVersion returns a saved version of the pattern.
+func (s *Server) readVersion(version string) ([]byte, error) {
+	if _, err := strconv.ParseInt(version, 10, 64); err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot find pattern version %s", version))
+	}
+
+	p, err := ioutil.ReadFile(s.versionFile(version))
+	if os.IsNotExist(err) {
+		return nil, errors.New(fmt.Sprintf("Cannot find pattern version %s", version))
+	}
+
+	return p, err
+}
+
+// LoadPattern imports the pattern saved by a previous run, if there is one. It
+// reports whether there was. Loading the pattern doesn't save a new version of
+// it, as nothing has changed.
+func (s *Server) LoadPattern() (bool, error) {
+	if s.PatternFile == "" {
+		return false, nil
+	}
+
+	b, err := ioutil.ReadFile(s.PatternFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	return true, s.importJSON(b, false)
+}
+
+// historyHandler lists the saved versions of the pattern.
+func (s *Server) historyHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.PatternFile == "" {
+		s.apiWrap(w, r, 500, s.response("Pattern history is off, start st with --pattern-file"))
+		return
+	}
+
+	history, err := s.history()
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	jhistory, err := json.Marshal(history)
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	s.apiWrap(w, r, 200, jhistory)
+}
+
+// patternVersionHandler returns a saved version of the pattern.
+func (s *Server) patternVersionHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.readVersion(mux.Vars(r)["version"])
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	s.apiWrap(w, r, 200, p)
+}
+
+// restoreHandler turns the running pattern back into a saved version of it,
+// leaving alone the blocks that are the same in both.
+func (s *Server) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version := mux.Vars(r)["version"]
+	p, err := s.readVersion(version)
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	var pattern engine.Pattern
+	err = json.Unmarshal(p, &pattern)
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	diff, err := s.engine.Apply(&pattern, false)
+	if diff != nil {
+		s.announceDiff(diff)
+		s.persist()
+	}
+	if err != nil {
+		s.apiWrap(w, r, 500, s.response(err.Error()))
+		return
+	}
+
+	loghub.Log <- &loghub.LogMsg{
+		Type: loghub.INFO,
+		Data: fmt.Sprintf("Restored pattern version %s", version),
+		Id:   s.Id,
+	}
+
+	s.apiWrap(w, r, 200, s.response("OK"))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/nytlabs/streamtools/st/patterntest"
+)
+
+// testPatterns runs the tests in the spec files given by args and returns the
+// status st should exit with: 1 if any of them failed.
+func testPatterns(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	junit := fs.String("junit", "", "file to write the results to as JUnit XML")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		log.Println("usage: st [options] test [-junit results.xml] spec.json ...")
+		return 1
+	}
+
+	status := 0
+	var results []*patterntest.SuiteResult
+	for _, file := range fs.Args() {
+		spec, err := patterntest.Load(file)
+		if err != nil {
+			log.Println(err)
+			status = 1
+			continue
+		}
+
+		suite := spec.Run()
+		for _, r := range suite.Results {
+			switch {
+			case r.Error != "":
+				log.Printf("ERROR %s: %s: %s", suite.Name, r.Name, r.Error)
+			case len(r.Failures) > 0:
+				log.Printf("FAIL %s: %s", suite.Name, r.Name)
+				for _, f := range r.Failures {
+					log.Printf("    %s", f)
+				}
+			default:
+				log.Printf("ok %s: %s", suite.Name, r.Name)
+			}
+		}
+
+		if suite.Failed() > 0 {
+			status = 1
+		}
+		results = append(results, suite)
+	}
+
+	if *junit != "" {
+		f, err := os.Create(*junit)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		defer f.Close()
+
+		err = patterntest.WriteJUnit(f, results)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+	}
+
+	return status
+}
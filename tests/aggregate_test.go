@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"log"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/test_utils"
+	. "launchpad.net/gocheck"
+)
+
+type AggregateSuite struct{}
+
+var aggregateSuite = Suite(&AggregateSuite{})
+
+func (s *AggregateSuite) TestAggregateTumbling(c *C) {
+	log.Println("testing aggregate")
+	b, ch := test_utils.NewBlock("testingAggregate", "aggregate")
+	clock := blocks.NewManualClock(time.Unix(0, 0).UTC())
+	b.SetClock(clock)
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+
+	setRule(c, ch, map[string]interface{}{
+		"WindowType": "tumbling",
+		"Window":     "10s",
+		"GroupBy":    ".k",
+		"Aggregates": []interface{}{
+			map[string]interface{}{"Name": "n", "Op": "count", "Path": ""},
+			map[string]interface{}{"Name": "total", "Op": "sum", "Path": ".v"},
+			map[string]interface{}{"Name": "median", "Op": "percentile", "Path": ".v", "Percentile": 50.0},
+			map[string]interface{}{"Name": "first", "Op": "first", "Path": ".v"},
+		},
+	})
+
+	for _, m := range []map[string]interface{}{
+		{"k": "b", "v": 1.0},
+		{"k": "a", "v": 4.0},
+		{"k": "a", "v": 2.0},
+		{"k": "a", "v": 3.0},
+	} {
+		ch.InChan <- &blocks.Msg{Msg: m, Route: "in"}
+	}
+
+	// the messages and the query race each other, so ask until they're all in
+	deadline := time.Now().Add(time.Second)
+	var results []interface{}
+	for time.Now().Before(deadline) {
+		q := make(blocks.MsgChan)
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "results"}
+		results = (<-q).(map[string]interface{})["Results"].([]interface{})
+		if len(results) == 2 && results[0].(map[string]interface{})["Values"].(map[string]interface{})["n"] == 3.0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(len(results), Equals, 2)
+
+	clock.Advance(10 * time.Second)
+	for _, want := range []map[string]interface{}{
+		{
+			"Group":  "a",
+			"Start":  "1970-01-01T00:00:00Z",
+			"End":    "1970-01-01T00:00:10Z",
+			"Values": map[string]interface{}{"n": 3.0, "total": 9.0, "median": 3.0, "first": 4.0},
+		},
+		{
+			"Group":  "b",
+			"Start":  "1970-01-01T00:00:00Z",
+			"End":    "1970-01-01T00:00:10Z",
+			"Values": map[string]interface{}{"n": 1.0, "total": 1.0, "median": 1.0, "first": 1.0},
+		},
+	} {
+		select {
+		case msg := <-outChan:
+			c.Assert(msg.Msg, DeepEquals, want)
+		case <-time.After(time.Second):
+			c.Fatal("timed out waiting for a window to close")
+		}
+	}
+}
+
+func (s *AggregateSuite) TestAggregateSessions(c *C) {
+	log.Println("testing aggregate in sessions")
+	b, ch := test_utils.NewBlock("testingAggregateSessions", "aggregate")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+
+	setRule(c, ch, map[string]interface{}{
+		"WindowType": "session",
+		"Window":     "5s",
+		"GroupBy":    ".user",
+		"TimePath":   ".t",
+		"Aggregates": []interface{}{
+			map[string]interface{}{"Name": "pages", "Op": "distinct", "Path": ".page"},
+		},
+	})
+
+	go func() {
+		for _, m := range []map[string]interface{}{
+			{"user": "x", "page": "home", "t": 0.0},
+			{"user": "x", "page": "news", "t": 3000.0},
+			{"user": "x", "page": "home", "t": 4000.0},
+			{"user": "x", "page": "home", "t": 20000.0},
+		} {
+			ch.InChan <- &blocks.Msg{Msg: m, Route: "in"}
+		}
+	}()
+
+	select {
+	case msg := <-outChan:
+		c.Assert(msg.Msg, DeepEquals, map[string]interface{}{
+			"Group":  "x",
+			"Start":  time.Unix(0, 0).Format(time.RFC3339Nano),
+			"End":    time.Unix(9, 0).Format(time.RFC3339Nano),
+			"Values": map[string]interface{}{"pages": 2.0},
+		})
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the first session")
+	}
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{}, Route: "flush"}
+	select {
+	case msg := <-outChan:
+		c.Assert(msg.Msg.(map[string]interface{})["Start"], Equals, time.Unix(20, 0).Format(time.RFC3339Nano))
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the flushed session")
+	}
+}
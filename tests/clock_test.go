@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"log"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	. "launchpad.net/gocheck"
+)
+
+type ClockSuite struct{}
+
+var clockSuite = Suite(&ClockSuite{})
+
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *ClockSuite) TestManualClock(c *C) {
+	log.Println("testing ManualClock")
+	start := time.Unix(100, 0)
+	clock := blocks.NewManualClock(start)
+	c.Assert(clock.Now(), Equals, start)
+
+	timer := clock.NewTimer(time.Second)
+	ticker := clock.NewTicker(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	c.Assert(fired(timer.C()), Equals, false)
+	c.Assert(fired(ticker.C()), Equals, false)
+
+	clock.Advance(time.Millisecond)
+	c.Assert(fired(timer.C()), Equals, true)
+	c.Assert(fired(ticker.C()), Equals, true)
+	c.Assert(timer.Stop(), Equals, false)
+
+	// a ticker that falls behind drops ticks
+	clock.Advance(5 * time.Second)
+	c.Assert(fired(ticker.C()), Equals, true)
+	c.Assert(fired(ticker.C()), Equals, false)
+	c.Assert(clock.Now(), Equals, start.Add(6*time.Second))
+
+	c.Assert(timer.Reset(2*time.Second), Equals, false)
+	clock.Advance(time.Second)
+	c.Assert(fired(timer.C()), Equals, false)
+	c.Assert(timer.Stop(), Equals, true)
+	clock.Advance(time.Second)
+	c.Assert(fired(timer.C()), Equals, false)
+
+	fired(ticker.C())
+	ticker.Stop()
+	clock.Advance(time.Second)
+	c.Assert(fired(ticker.C()), Equals, false)
+
+	// clocks don't go backwards
+	clock.Set(start)
+	c.Assert(clock.Now(), Equals, start.Add(9*time.Second))
+}
@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"log"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/engine"
+	"github.com/nytlabs/streamtools/st/library"
+	. "launchpad.net/gocheck"
+)
+
+type CompositeSuite struct{}
+
+var compositeSuite = Suite(&CompositeSuite{})
+
+func (s *CompositeSuite) TestCompositeErrors(c *C) {
+	log.Println("testing composite error route")
+	library.Start()
+	e := engine.New()
+	defer e.Clear()
+
+	p := &engine.Pattern{
+		Composites: []*library.CompositeDef{
+			{
+				Type: "testingCompositeErrors",
+				Blocks: []*library.InnerBlock{
+					{Id: "u", Type: "unpack", Rule: map[string]interface{}{"ArrayPath": ".items", "LabelPath": ""}},
+				},
+				InRoutes:  map[string]*library.InnerRoute{"in": {Block: "u", Route: "in"}},
+				OutRoutes: map[string]*library.InnerRoute{"out": {Block: "u", Route: "out"}},
+			},
+		},
+		Blocks: []*engine.BlockInfo{
+			{Id: "c", Type: "testingCompositeErrors"},
+		},
+	}
+	_, _, err := e.Import(p)
+	c.Assert(err, IsNil)
+	defer library.UnregisterComposite("testingCompositeErrors")
+
+	errs, _, err := e.Subscribe("c", "error")
+	c.Assert(err, IsNil)
+
+	msg := map[string]interface{}{"items": "not an array"}
+	c.Assert(e.Send("c", "in", msg), IsNil)
+	select {
+	case m := <-errs:
+		env := m.Msg.(map[string]interface{})
+		c.Assert(env["Msg"], DeepEquals, msg)
+		c.Assert(env["Id"], Equals, "c.u")
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the error")
+	}
+}
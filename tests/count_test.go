@@ -1,7 +1,9 @@
 package tests
 
 import (
+	"io/ioutil"
 	"log"
+	"os"
 	"reflect"
 	"testing"
 	"time"
@@ -80,3 +82,104 @@ func (s *CountSuite) TestCount(c *C) {
 		}
 	}
 }
+
+func (s *CountSuite) TestCountStats(c *C) {
+	log.Println("testing Count stats")
+	b, ch := test_utils.NewBlock("testingCountStats", "count")
+	go blocks.BlockRoutine(b)
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"Window": "1s"}, Route: "rule"}
+	for i := 0; i < 3; i++ {
+		ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{}, Route: "in"}
+	}
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{}, Route: "poll"}
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+
+	statsChan := make(blocks.MsgChan)
+	time.AfterFunc(time.Duration(1)*time.Second, func() {
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: statsChan, Route: "stats"}
+	})
+
+	time.AfterFunc(time.Duration(2)*time.Second, func() {
+		ch.QuitChan <- true
+	})
+
+	for {
+		select {
+		case messageI := <-statsChan:
+			stats, ok := messageI.(blocks.Stats)
+			c.Assert(ok, Equals, true)
+			c.Assert(stats.Received["rule"], Equals, int64(1))
+			c.Assert(stats.Received["in"], Equals, int64(3))
+			c.Assert(stats.Received["poll"], Equals, int64(1))
+			c.Assert(stats.Emitted["out"], Equals, int64(1))
+			c.Assert(stats.Errors, Equals, int64(0))
+			c.Assert(stats.Dropped, Equals, int64(0))
+			c.Assert(stats.Latency.Count, Equals, int64(5))
+		case <-outChan:
+		case err := <-ch.ErrChan:
+			if err != nil {
+				c.Errorf(err.Error())
+			} else {
+				return
+			}
+		}
+	}
+}
+
+func (s *CountSuite) TestCountSnapshot(c *C) {
+	log.Println("testing Count snapshot")
+	dir, err := ioutil.TempDir("", "streamtools-state-")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	blocks.StateDir = dir
+	defer func() {
+		blocks.StateDir = ""
+	}()
+
+	ruleMsg := map[string]interface{}{"Window": "1m"}
+
+	b, ch := test_utils.NewBlock("testingCountSnapshot", "count")
+	b.SetId("testingCountSnapshot")
+	go blocks.BlockRoutine(b)
+	ch.InChan <- &blocks.Msg{Msg: ruleMsg, Route: "rule"}
+	for i := 0; i < 3; i++ {
+		ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{}, Route: "in"}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	snapshotChan := make(blocks.MsgChan)
+	ch.QueryChan <- &blocks.QueryMsg{MsgChan: snapshotChan, Route: "snapshot"}
+	<-snapshotChan
+
+	// a block created with the same id picks up where the first left off
+	restored, restoredCh := test_utils.NewBlock("testingCountSnapshot", "count")
+	restored.SetId("testingCountSnapshot")
+	go blocks.BlockRoutine(restored)
+	restoredCh.InChan <- &blocks.Msg{Msg: ruleMsg, Route: "rule"}
+
+	countChan := make(blocks.MsgChan)
+	time.AfterFunc(time.Duration(100)*time.Millisecond, func() {
+		restoredCh.QueryChan <- &blocks.QueryMsg{MsgChan: countChan, Route: "count"}
+	})
+
+	time.AfterFunc(time.Duration(1)*time.Second, func() {
+		ch.QuitChan <- true
+		restoredCh.QuitChan <- true
+	})
+
+	for {
+		select {
+		case messageI := <-countChan:
+			c.Assert(messageI, DeepEquals, map[string]interface{}{"Count": 3.0})
+		case err := <-restoredCh.ErrChan:
+			if err != nil {
+				c.Errorf(err.Error())
+			} else {
+				return
+			}
+		}
+	}
+}
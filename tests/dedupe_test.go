@@ -72,3 +72,103 @@ func (s *DeDupeSuite) TestDeDupe(c *C) {
 		}
 	}
 }
+
+// dedupeOut reads what a dedupe block emits until it has been quiet for a
+// while.
+func dedupeOut(outChan chan *blocks.Msg) []interface{} {
+	var out []interface{}
+	for {
+		select {
+		case m := <-outChan:
+			out = append(out, m.Msg)
+		case <-time.After(200 * time.Millisecond):
+			return out
+		}
+	}
+}
+
+func dedupeSize(ch blocks.BlockChans) interface{} {
+	q := make(blocks.MsgChan)
+	ch.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "size"}
+	return (<-q).(map[string]interface{})["Size"]
+}
+
+func (s *DeDupeSuite) TestDeDupeTTL(c *C) {
+	log.Println("testing dedupe with a TTL")
+	b, ch := test_utils.NewBlock("testingDeDupeTTL", "dedupe")
+	clock := blocks.NewManualClock(time.Unix(0, 0))
+	b.SetClock(clock)
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "out", Channel: outChan}
+	setRule(c, ch, map[string]interface{}{"Path": ".a", "TTL": "10s"})
+
+	msg := map[string]interface{}{"a": map[string]interface{}{"b": 1.0}}
+	ch.InChan <- &blocks.Msg{Msg: msg, Route: "in"}
+	ch.InChan <- &blocks.Msg{Msg: msg, Route: "in"}
+	c.Assert(dedupeOut(outChan), DeepEquals, []interface{}{msg})
+	c.Assert(dedupeSize(ch), Equals, 1.0)
+
+	clock.Advance(10 * time.Second)
+	c.Assert(dedupeSize(ch), Equals, 0.0)
+
+	ch.InChan <- &blocks.Msg{Msg: msg, Route: "in"}
+	c.Assert(dedupeOut(outChan), DeepEquals, []interface{}{msg})
+}
+
+func (s *DeDupeSuite) TestDeDupeMaxEntries(c *C) {
+	log.Println("testing dedupe of composite keys with MaxEntries")
+	b, ch := test_utils.NewBlock("testingDeDupeMaxEntries", "dedupe")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "out", Channel: outChan}
+	setRule(c, ch, map[string]interface{}{"Paths": []interface{}{".a", ".b"}, "MaxEntries": 2.0})
+
+	msgs := []map[string]interface{}{
+		{"a": 1.0, "b": 1.0},
+		{"a": 1.0, "b": 2.0},
+		{"a": 1.0, "b": 1.0},
+		// this forgets {1, 2}, the least recently seen.
+		{"a": 2.0, "b": 2.0},
+		{"a": 1.0, "b": 2.0},
+		{"a": 2.0, "b": 2.0},
+	}
+	for _, msg := range msgs {
+		ch.InChan <- &blocks.Msg{Msg: msg, Route: "in"}
+	}
+	c.Assert(dedupeOut(outChan), DeepEquals, []interface{}{msgs[0], msgs[1], msgs[3], msgs[4]})
+	c.Assert(dedupeSize(ch), Equals, 2.0)
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{}, Route: "clear"}
+	ch.InChan <- &blocks.Msg{Msg: msgs[0], Route: "in"}
+	c.Assert(dedupeOut(outChan), DeepEquals, []interface{}{msgs[0]})
+}
+
+func (s *DeDupeSuite) TestDeDupeBloom(c *C) {
+	log.Println("testing dedupe with a bloom filter")
+	b, ch := test_utils.NewBlock("testingDeDupeBloom", "dedupe")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "out", Channel: outChan}
+	setRule(c, ch, map[string]interface{}{"Path": ".a", "Mode": "bloom", "Capacity": 1000.0})
+
+	go func() {
+		for i := 0; i < 400; i++ {
+			ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"a": float64(i % 200)}, Route: "in"}
+		}
+	}()
+	c.Assert(dedupeOut(outChan), HasLen, 200)
+	c.Assert(dedupeSize(ch), Equals, 200.0)
+}
@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/engine"
+	"github.com/nytlabs/streamtools/st/library"
+	. "launchpad.net/gocheck"
+)
+
+type EngineSuite struct{}
+
+var engineSuite = Suite(&EngineSuite{})
+
+func init() {
+	err := library.Register("testingEngineEcho", func() blocks.BlockInterface {
+		return &Panicky{}
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (s *EngineSuite) SetUpSuite(c *C) {
+	library.Start()
+}
+
+func (s *EngineSuite) TestEngine(c *C) {
+	log.Println("testing Engine")
+	e := engine.New()
+
+	p := &engine.Pattern{
+		Blocks: []*engine.BlockInfo{
+			{Id: "a", Type: "testingEngineEcho"},
+			{Id: "b", Type: "testingEngineEcho", Rule: map[string]interface{}{"Some": "rule"}},
+		},
+		Connections: []*engine.ConnectionInfo{
+			{Id: "ab", FromId: "a", ToId: "b", ToRoute: "in"},
+		},
+	}
+
+	_, _, err := e.Import(p)
+	c.Assert(err, IsNil)
+
+	out, subId, err := e.Subscribe("b", "out")
+	c.Assert(err, IsNil)
+
+	inMsg := map[string]interface{}{"hello": "world"}
+	err = e.Send("a", "in", inMsg)
+	c.Assert(err, IsNil)
+
+	select {
+	case msg := <-out:
+		c.Assert(msg.Msg, DeepEquals, inMsg)
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the message")
+	}
+
+	c.Assert(e.Unsubscribe("b", subId, out), IsNil)
+
+	rule, err := e.QueryBlock("b", "rule")
+	c.Assert(err, IsNil)
+	c.Assert(rule, DeepEquals, map[string]interface{}{"Some": "rule"})
+
+	export := e.Export()
+	c.Assert(len(export.Blocks), Equals, 2)
+	c.Assert(len(export.Connections), Equals, 1)
+
+	_, err = e.DeleteBlock("a")
+	c.Assert(err, IsNil)
+	c.Assert(len(e.ListConnections()), Equals, 0)
+
+	e.Clear()
+	c.Assert(len(e.ListBlocks()), Equals, 0)
+}
+
+func (s *EngineSuite) TestEngineState(c *C) {
+	log.Println("testing Engine keeps state of stopped blocks")
+	dir, err := ioutil.TempDir("", "streamtools-state-")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	blocks.StateDir = dir
+	defer func() {
+		blocks.StateDir = ""
+	}()
+
+	e := engine.New()
+	p := &engine.Pattern{
+		Blocks: []*engine.BlockInfo{
+			{Id: "counter", Type: "count", Rule: map[string]interface{}{"Window": "1m"}},
+		},
+	}
+	_, _, err = e.Import(p)
+	c.Assert(err, IsNil)
+	c.Assert(e.Send("counter", "in", map[string]interface{}{}), IsNil)
+	_, err = e.QueryBlock("counter", "snapshot")
+	c.Assert(err, IsNil)
+
+	path := filepath.Join(dir, "counter.json")
+	e.Clear()
+	_, err = os.Stat(path)
+	c.Assert(err, IsNil)
+
+	_, _, err = e.Import(p)
+	c.Assert(err, IsNil)
+	time.Sleep(100 * time.Millisecond)
+	count, err := e.QueryBlock("counter", "count")
+	c.Assert(err, IsNil)
+	c.Assert(count, DeepEquals, map[string]interface{}{"Count": 1.0})
+
+	_, err = e.DeleteBlock("counter")
+	c.Assert(err, IsNil)
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *EngineSuite) TestEngineApplyState(c *C) {
+	log.Println("testing Engine Apply removes state of blocks it deletes")
+	dir, err := ioutil.TempDir("", "streamtools-state-")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	blocks.StateDir = dir
+	defer func() {
+		blocks.StateDir = ""
+	}()
+
+	e := engine.New()
+	defer e.Clear()
+	p := &engine.Pattern{
+		Blocks: []*engine.BlockInfo{
+			{Id: "counter", Type: "count", Rule: map[string]interface{}{"Window": "1m"}},
+		},
+	}
+	_, err = e.Apply(p, false)
+	c.Assert(err, IsNil)
+	c.Assert(e.Send("counter", "in", map[string]interface{}{}), IsNil)
+	_, err = e.QueryBlock("counter", "snapshot")
+	c.Assert(err, IsNil)
+
+	path := filepath.Join(dir, "counter.json")
+	_, err = os.Stat(path)
+	c.Assert(err, IsNil)
+
+	_, err = e.Apply(&engine.Pattern{}, false)
+	c.Assert(err, IsNil)
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *EngineSuite) TestEngineApplyFailure(c *C) {
+	log.Println("testing Engine Apply drops the composites it added when it fails")
+	e := engine.New()
+	defer e.Clear()
+
+	// the connection's id is taken by a block, which only comes out once
+	// the blocks have been created.
+	p := &engine.Pattern{
+		Composites: []*library.CompositeDef{
+			{
+				Type: "testingEngineApplyFailure",
+				Blocks: []*library.InnerBlock{
+					{Id: "n", Type: "count"},
+				},
+				InRoutes: map[string]*library.InnerRoute{"in": {Block: "n", Route: "in"}},
+			},
+		},
+		Blocks: []*engine.BlockInfo{
+			{Id: "a", Type: "count"},
+			{Id: "b", Type: "count"},
+		},
+		Connections: []*engine.ConnectionInfo{
+			{Id: "a", FromId: "a", ToId: "b", ToRoute: "in"},
+		},
+	}
+	_, err := e.Apply(p, false)
+	c.Assert(err, NotNil)
+	_, ok := library.Composites["testingEngineApplyFailure"]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *EngineSuite) TestEngineCheck(c *C) {
+	log.Println("testing Engine checks rules without running blocks")
+	dir, err := ioutil.TempDir("", "streamtools-check-")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "out.json")
+
+	e := engine.New()
+	defer e.Clear()
+
+	p := &engine.Pattern{
+		Blocks: []*engine.BlockInfo{
+			{Id: "f", Type: "filter", Rule: map[string]interface{}{"Filter": 1.0}},
+			{Id: "m", Type: "map", Rule: map[string]interface{}{}},
+			{Id: "t", Type: "tofile", Rule: map[string]interface{}{"Filename": filename}},
+			{Id: "n", Type: "count", Rule: "not an object"},
+		},
+	}
+	problems := e.Check(p)
+	c.Assert(problems, DeepEquals, []string{
+		"block f: bad rule: bad filter",
+		"block m: bad rule: could not find Map in rule",
+		"block n: bad rule: rule must be an object",
+	})
+
+	// the file is only created once the block runs
+	_, err = os.Stat(filename)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// imports are turned down for the same problems
+	_, _, err = e.Import(p)
+	c.Assert(err, NotNil)
+	c.Assert(len(e.ListBlocks()), Equals, 0)
+}
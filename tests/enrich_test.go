@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/engine"
+	"github.com/nytlabs/streamtools/st/library"
+	"github.com/nytlabs/streamtools/test_utils"
+	. "launchpad.net/gocheck"
+)
+
+type EnrichSuite struct{}
+
+var enrichSuite = Suite(&EnrichSuite{})
+
+func (s *EnrichSuite) TestEnrichFile(c *C) {
+	log.Println("testing enrich from a file")
+	f, err := ioutil.TempFile("", "streamtools_test_enrich")
+	c.Assert(err, IsNil)
+	defer os.Remove(f.Name())
+	f.WriteString("name,id\nalice,1\nbob,2\n")
+	f.Close()
+
+	// the file needs to end in .csv to be read as CSV.
+	filename := f.Name() + ".csv"
+	c.Assert(os.Rename(f.Name(), filename), IsNil)
+	defer os.Remove(filename)
+
+	b, ch := test_utils.NewBlock("testingEnrichFile", "enrich")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+	missChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "2", FromRoute: "miss", Channel: missChan}
+
+	setRule(c, ch, map[string]interface{}{
+		"KeyPath":   ".user",
+		"Source":    "file",
+		"File":      filename,
+		"KeyColumn": "id",
+		"Into":      "User",
+		"OnMiss":    "route",
+	})
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"user": 2.0}, Route: "in"}
+	select {
+	case msg := <-outChan:
+		c.Assert(msg.Msg, DeepEquals, map[string]interface{}{
+			"user": 2.0,
+			"User": map[string]interface{}{"name": "bob", "id": "2"},
+		})
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the enriched message")
+	}
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"user": 3.0}, Route: "in"}
+	select {
+	case msg := <-missChan:
+		c.Assert(msg.Msg, DeepEquals, map[string]interface{}{"user": 3.0})
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the miss")
+	}
+}
+
+func (s *EnrichSuite) TestEnrichBlock(c *C) {
+	log.Println("testing enrich from a block")
+	library.Start()
+	e := engine.New()
+	defer e.Clear()
+
+	p := &engine.Pattern{
+		Blocks: []*engine.BlockInfo{
+			{Id: "ref", Type: "cache", Rule: map[string]interface{}{
+				"KeyPath":    ".k",
+				"ValuePath":  ".v",
+				"TimeToLive": "1h",
+			}},
+			{Id: "enrich", Type: "enrich", Rule: map[string]interface{}{
+				"KeyPath": ".k",
+				"Source":  "block",
+				"Block":   "ref",
+			}},
+		},
+	}
+	_, _, err := e.Import(p)
+	c.Assert(err, IsNil)
+
+	out, _, err := e.Subscribe("enrich", "out")
+	c.Assert(err, IsNil)
+
+	c.Assert(e.Send("ref", "in", map[string]interface{}{"k": "a", "v": map[string]interface{}{"x": 1.0}}), IsNil)
+	c.Assert(e.Drain(), IsNil)
+
+	c.Assert(e.Send("enrich", "in", map[string]interface{}{"k": "a"}), IsNil)
+	select {
+	case msg := <-out:
+		c.Assert(msg.Msg, DeepEquals, map[string]interface{}{"k": "a", "x": 1.0})
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the enriched message")
+	}
+
+	// misses are passed through as they are by default.
+	c.Assert(e.Send("enrich", "in", map[string]interface{}{"k": "b"}), IsNil)
+	select {
+	case msg := <-out:
+		c.Assert(msg.Msg, DeepEquals, map[string]interface{}{"k": "b"})
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the missed message")
+	}
+}
+
+func (s *EnrichSuite) TestEnrichSelf(c *C) {
+	log.Println("testing enrich won't look up in itself")
+	library.Start()
+	e := engine.New()
+	defer e.Clear()
+
+	p := &engine.Pattern{
+		Blocks: []*engine.BlockInfo{
+			{Id: "enrich", Type: "enrich", Rule: map[string]interface{}{
+				"KeyPath": ".k",
+				"Source":  "block",
+				"Block":   "enrich",
+			}},
+		},
+	}
+	_, _, err := e.Import(p)
+	c.Assert(err, IsNil)
+
+	// a rule that is turned down doesn't say so on any route, so give the
+	// block a moment to have read it.
+	time.Sleep(100 * time.Millisecond)
+
+	r, err := e.QueryBlock("enrich", "rule")
+	c.Assert(err, IsNil)
+	c.Assert(r.(map[string]interface{})["Source"], Equals, "")
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"log"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/test_utils"
+	. "launchpad.net/gocheck"
+)
+
+type EventTimeSuite struct{}
+
+var eventTimeSuite = Suite(&EventTimeSuite{})
+
+func (s *EventTimeSuite) TestCountEventTime(c *C) {
+	log.Println("testing count in event time")
+	b, ch := test_utils.NewBlock("testingCountEventTime", "count")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	lateChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", FromRoute: "late", Channel: lateChan}
+
+	setRule(c, ch, map[string]interface{}{
+		"Window":          "10s",
+		"TimePath":        ".t",
+		"AllowedLateness": "5s",
+	})
+
+	// the third message moves the watermark on to 15s, which is the end of
+	// the first two, and is after the fourth.
+	for _, t := range []float64{0, 1000, 20000, 12000, 16000} {
+		ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"t": t}, Route: "in"}
+	}
+
+	select {
+	case msg := <-lateChan:
+		c.Assert(msg.Msg, DeepEquals, map[string]interface{}{"t": float64(12000)})
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the late message")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	var count interface{}
+	for time.Now().Before(deadline) {
+		q := make(blocks.MsgChan)
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "count"}
+		count = (<-q).(map[string]interface{})["Count"]
+		if count == float64(2) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(count, Equals, float64(2))
+}
+
+func (s *EventTimeSuite) TestPackByIntervalEventTime(c *C) {
+	log.Println("testing packbyinterval in event time")
+	b, ch := test_utils.NewBlock("testingPackByIntervalEventTime", "packbyinterval")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+	lateChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "2", FromRoute: "late", Channel: lateChan}
+
+	setRule(c, ch, map[string]interface{}{
+		"Interval": "10s",
+		"TimePath": ".t",
+	})
+
+	for _, t := range []float64{1000, 5000, 12000, 3000} {
+		ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"t": t}, Route: "in"}
+	}
+
+	// out routes are independent of each other, so the pack and the late
+	// message can come in either order.
+	var pack, late interface{}
+	for pack == nil || late == nil {
+		select {
+		case msg := <-outChan:
+			pack = msg.Msg
+		case msg := <-lateChan:
+			late = msg.Msg
+		case <-time.After(time.Second):
+			c.Fatal("timed out waiting for the first pack and the late message")
+		}
+	}
+	c.Assert(pack, DeepEquals, map[string]interface{}{"Pack": []interface{}{
+		map[string]interface{}{"t": float64(1000)},
+		map[string]interface{}{"t": float64(5000)},
+	}})
+	c.Assert(late, DeepEquals, map[string]interface{}{"t": float64(3000)})
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{}, Route: "flush"}
+	select {
+	case msg := <-outChan:
+		c.Assert(msg.Msg, DeepEquals, map[string]interface{}{"Pack": []interface{}{
+			map[string]interface{}{"t": float64(12000)},
+		}})
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the flushed pack")
+	}
+}
+
+func (s *EventTimeSuite) TestMovingAverageBadEventTime(c *C) {
+	log.Println("testing movingaverage keeps its rule when the event time is bad")
+	b, ch := test_utils.NewBlock("testingMovingAverageBadEventTime", "movingaverage")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	rule := map[string]interface{}{
+		"Path":     ".v",
+		"Window":   "1m0s",
+		"TimePath": ".t",
+	}
+	setRule(c, ch, rule)
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{
+		"Path":            ".w",
+		"Window":          "2m0s",
+		"TimePath":        ".u",
+		"AllowedLateness": "soon",
+	}, Route: "rule"}
+
+	// a rule that is turned down doesn't say so on any route, so give the
+	// block a moment to have read it.
+	time.Sleep(100 * time.Millisecond)
+
+	q := make(blocks.MsgChan)
+	ch.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "rule"}
+	r := (<-q).(map[string]interface{})
+	for k, v := range rule {
+		c.Assert(r[k], Equals, v)
+	}
+}
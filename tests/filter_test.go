@@ -48,3 +48,76 @@ func (s *FilterSuite) TestFilter(c *C) {
 		}
 	}
 }
+
+func (s *FilterSuite) TestFilterNoMatch(c *C) {
+	log.Println("testing Filter nomatch")
+	b, ch := test_utils.NewBlock("testingFilterNoMatch", "filter")
+	go blocks.BlockRoutine(b)
+
+	ruleMsg := map[string]interface{}{"Filter": ".device == 'iPhone'"}
+	toRule := &blocks.Msg{Msg: ruleMsg, Route: "rule"}
+	ch.InChan <- toRule
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+
+	noMatchChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "2", FromRoute: "nomatch", Channel: noMatchChan}
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"device": "iPhone"}, Route: "in"}
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"device": "Android"}, Route: "in"}
+
+	time.AfterFunc(time.Duration(5)*time.Second, func() {
+		ch.QuitChan <- true
+	})
+
+	for {
+		select {
+		case message := <-outChan:
+			c.Assert(message.Msg, DeepEquals, map[string]interface{}{"device": "iPhone"})
+		case message := <-noMatchChan:
+			c.Assert(message.Msg, DeepEquals, map[string]interface{}{"device": "Android"})
+		case err := <-ch.ErrChan:
+			if err != nil {
+				c.Errorf(err.Error())
+			} else {
+				return
+			}
+		}
+	}
+}
+
+func (s *FilterSuite) TestFilterDeadLetter(c *C) {
+	log.Println("testing Filter error route")
+	b, ch := test_utils.NewBlock("testingFilterDeadLetter", "filter")
+	go blocks.BlockRoutine(b)
+
+	errChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", FromRoute: "error", Channel: errChan}
+
+	// no rule has been set, so the filter cannot handle the message
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"device": "iPhone"}, Route: "in"}
+
+	time.AfterFunc(time.Duration(5)*time.Second, func() {
+		ch.QuitChan <- true
+	})
+
+	for {
+		select {
+		case message := <-errChan:
+			env, ok := message.Msg.(map[string]interface{})
+			c.Assert(ok, Equals, true)
+			c.Assert(env["Msg"], DeepEquals, map[string]interface{}{"device": "iPhone"})
+			c.Assert(env["Error"], Equals, "no filter set")
+			c.Assert(env["Id"], Equals, "testingFilterDeadLetter")
+			_, ok = env["Time"].(float64)
+			c.Assert(ok, Equals, true)
+		case err := <-ch.ErrChan:
+			if err != nil {
+				c.Errorf(err.Error())
+			} else {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/test_utils"
+	. "launchpad.net/gocheck"
+)
+
+// setRule sends a block a rule and waits until it has taken it, as rules and
+// messages reach the block on different routes.
+func setRule(c *C, ch blocks.BlockChans, rule map[string]interface{}) {
+	ch.InChan <- &blocks.Msg{Msg: rule, Route: "rule"}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		q := make(blocks.MsgChan)
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "rule"}
+		r := (<-q).(map[string]interface{})
+
+		taken := true
+		for k, v := range rule {
+			if !reflect.DeepEqual(r[k], v) {
+				taken = false
+			}
+		}
+		if taken {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatal("block never took its rule")
+}
+
+// listenRoutes connects to each of a block's given out routes, and passes
+// what it emits on any of them to one channel, noting the route.
+func listenRoutes(ch blocks.BlockChans, routes ...string) chan *blocks.Msg {
+	all := make(chan *blocks.Msg)
+	for _, route := range routes {
+		c := make(chan *blocks.Msg)
+		ch.AddChan <- &blocks.AddChanMsg{Route: route, FromRoute: route, Channel: c}
+		go func(route string, c chan *blocks.Msg) {
+			for m := range c {
+				all <- &blocks.Msg{Msg: m.Msg, Route: route}
+			}
+		}(route, c)
+	}
+	return all
+}
+
+// routesOut reads what was emitted, by route, until the block has been quiet
+// for a while.
+func routesOut(all chan *blocks.Msg) map[string][]interface{} {
+	out := map[string][]interface{}{}
+	for {
+		select {
+		case m := <-all:
+			out[m.Route] = append(out[m.Route], m.Msg)
+		case <-time.After(200 * time.Millisecond):
+			return out
+		}
+	}
+}
+
+// newClockedBlock starts a block of the given type on a manual clock, listens
+// to the given out routes, and sets its rule.
+func newClockedBlock(c *C, id string, blockType string, rule map[string]interface{}, routes ...string) (blocks.BlockChans, *blocks.ManualClock, chan *blocks.Msg) {
+	b, ch := test_utils.NewBlock(id, blockType)
+	clock := blocks.NewManualClock(time.Unix(0, 0))
+	b.SetClock(clock)
+	go blocks.BlockRoutine(b)
+	all := listenRoutes(ch, routes...)
+	setRule(c, ch, rule)
+	return ch, clock, all
+}
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"log"
+	"reflect"
 	"time"
 
 	"github.com/nytlabs/streamtools/st/blocks"
@@ -39,3 +40,106 @@ func (s *JoinSuite) TestJoin(c *C) {
 		}
 	}
 }
+
+func (s *JoinSuite) TestKeyedJoin(c *C) {
+	log.Println("testing keyed join")
+	b, ch := test_utils.NewBlock("testing keyed join", "join")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+
+	setRule(c, ch, map[string]interface{}{
+		"Mode":     "keyed",
+		"KeyA":     ".id",
+		"KeyB":     ".user",
+		"Window":   "100ms",
+		"JoinType": "outer",
+	})
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"id": "1", "a": true}, Route: "inA"}
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"id": "2", "a": true}, Route: "inA"}
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"user": "3", "b": true}, Route: "inB"}
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"user": "1", "b": true}, Route: "inB"}
+
+	want := []interface{}{
+		map[string]interface{}{
+			"A": map[string]interface{}{"id": "1", "a": true},
+			"B": map[string]interface{}{"user": "1", "b": true},
+		},
+		map[string]interface{}{
+			"A": map[string]interface{}{"id": "2", "a": true},
+			"B": nil,
+		},
+		map[string]interface{}{
+			"A": nil,
+			"B": map[string]interface{}{"user": "3", "b": true},
+		},
+	}
+
+	// the inputs race each other, so the joined messages can come in any
+	// order.
+	var got []interface{}
+	for len(got) < len(want) {
+		select {
+		case msg := <-outChan:
+			got = append(got, msg.Msg)
+		case <-time.After(time.Second):
+			c.Fatalf("timed out after %d joined messages", len(got))
+		}
+	}
+
+	for _, w := range want {
+		found := false
+		for _, g := range got {
+			if reflect.DeepEqual(w, g) {
+				found = true
+			}
+		}
+		c.Assert(found, Equals, true, Commentf("missing %v", w))
+	}
+
+	select {
+	case msg := <-outChan:
+		c.Fatalf("unexpected %v", msg.Msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func (s *JoinSuite) TestKeyedJoinNewRule(c *C) {
+	log.Println("testing keyed join emits what it holds on a new rule")
+	b, ch := test_utils.NewBlock("testing keyed join new rule", "join")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+
+	setRule(c, ch, map[string]interface{}{
+		"Mode":     "keyed",
+		"KeyA":     ".id",
+		"KeyB":     ".user",
+		"Window":   "1h0m0s",
+		"JoinType": "left",
+	})
+
+	msg := map[string]interface{}{"id": "1"}
+	ch.InChan <- &blocks.Msg{Msg: msg, Route: "inA"}
+
+	// the message and the rule reach the block on different routes, so
+	// give it a moment to have taken the message.
+	time.Sleep(100 * time.Millisecond)
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"Mode": "fifo"}, Route: "rule"}
+
+	select {
+	case m := <-outChan:
+		c.Assert(m.Msg, DeepEquals, map[string]interface{}{"A": msg, "B": nil})
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the unmatched message")
+	}
+}
@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/engine"
+	"github.com/nytlabs/streamtools/st/library"
+	"github.com/nytlabs/streamtools/st/patterntest"
+	. "launchpad.net/gocheck"
+)
+
+type PatternTestSuite struct{}
+
+var patternTestSuite = Suite(&PatternTestSuite{})
+
+const patternTestSpec = `{
+	"Name": "counting",
+	"Pattern": {
+		"Blocks": [
+			{"Id": "count", "Type": "count", "Rule": {"Window": "10s"}},
+			{"Id": "pack", "Type": "packbyinterval", "Rule": {"Interval": "1m"}}
+		],
+		"Connections": []
+	},
+	"Tests": [
+		{
+			"Name": "count forgets old messages",
+			"Steps": [
+				{"Block": "count", "Msgs": [{"a": 1}, {"a": 2}, {"a": 3}]},
+				{"Block": "count", "Route": "poll", "Msgs": [{}]},
+				{"Advance": "11s"},
+				{"Block": "count", "Route": "poll", "Msgs": [{}]}
+			],
+			"Expect": [
+				{"Block": "count", "Msgs": [{"Count": 3}, {"Count": 0}], "Ordered": true}
+			]
+		},
+		{
+			"Name": "pack waits for the interval",
+			"Steps": [
+				{"Block": "pack", "Msgs": [{"a": 1}, {"a": 2}]},
+				{"Advance": "59s"},
+				{"Advance": "1s"}
+			],
+			"Expect": [
+				{"Block": "pack", "Count": 1, "Msgs": [{"Pack": [{"a": 1}, {"a": 2}]}]}
+			]
+		},
+		{
+			"Name": "wrong",
+			"Steps": [
+				{"Block": "count", "Route": "poll", "Msgs": [{}]}
+			],
+			"Expect": [
+				{"Block": "count", "Msgs": [{"Count": 1}]}
+			]
+		}
+	]
+}`
+
+func (s *PatternTestSuite) TestPatternTest(c *C) {
+	log.Println("testing patterntest")
+	library.Start()
+	defer func(settle time.Duration) {
+		engine.SettleTime = settle
+	}(engine.SettleTime)
+	engine.SettleTime = 100 * time.Millisecond
+
+	f, err := ioutil.TempFile("", "streamtools_test_spec")
+	c.Assert(err, IsNil)
+	defer os.Remove(f.Name())
+	f.WriteString(patternTestSpec)
+	f.Close()
+
+	spec, err := patterntest.Load(f.Name())
+	c.Assert(err, IsNil)
+
+	result := spec.Run()
+	c.Assert(len(result.Results), Equals, 3)
+	c.Assert(result.Results[0].Failures, IsNil)
+	c.Assert(result.Results[0].Error, Equals, "")
+	c.Assert(result.Results[1].Failures, IsNil)
+	c.Assert(result.Results[1].Error, Equals, "")
+	c.Assert(result.Results[2].Passed(), Equals, false)
+	c.Assert(result.Failed(), Equals, 1)
+
+	var buf bytes.Buffer
+	err = patterntest.WriteJUnit(&buf, []*patterntest.SuiteResult{result})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(buf.String(), `<testsuite name="counting" tests="3" failures="1" errors="0"`), Equals, true)
+	c.Assert(strings.Contains(buf.String(), `<failure message="count out: expected {&#34;Count&#34;:1}, which never arrived">`), Equals, true)
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/library"
+	"github.com/nytlabs/streamtools/test_utils"
+	. "launchpad.net/gocheck"
+)
+
+type PluginSuite struct{}
+
+var pluginSuite = Suite(&PluginSuite{})
+
+// echoPlugin describes itself and then sends everything it gets on in back
+// out on out.
+const echoPlugin = `#!/bin/sh
+echo '{"Type":"echoPlugin","Desc":"echoes","InRoutes":["in"],"OutRoutes":["out"]}'
+exec sed -u 's/"Route":"in"/"Route":"out"/'
+`
+
+// plugins are loaded from init, like blocks are registered, before any suite
+// starts the library.
+var pluginDir, pluginErr = loadPlugins(map[string]string{
+	"echo": echoPlugin,
+	"slow": slowPlugin,
+})
+
+func loadPlugins(plugins map[string]string) (string, error) {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		return "", err
+	}
+	for name, script := range plugins {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755)
+		if err != nil {
+			return dir, err
+		}
+	}
+	return dir, library.LoadPlugins(dir)
+}
+
+func (s *PluginSuite) TearDownSuite(c *C) {
+	os.RemoveAll(pluginDir)
+}
+
+func (s *PluginSuite) TestPlugin(c *C) {
+	log.Println("testing Plugin")
+	c.Assert(pluginErr, IsNil)
+
+	b, ch := test_utils.NewBlock("testingPlugin", "echoPlugin")
+	go blocks.BlockRoutine(b)
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+
+	inMsg := map[string]interface{}{"hello": "world"}
+	ch.InChan <- &blocks.Msg{Msg: inMsg, Route: "in"}
+
+	time.AfterFunc(time.Duration(5)*time.Second, func() {
+		ch.QuitChan <- true
+	})
+
+	echoed := false
+	for {
+		select {
+		case message := <-outChan:
+			c.Assert(message.Msg, DeepEquals, inMsg)
+			echoed = true
+		case err := <-ch.ErrChan:
+			if err != nil {
+				c.Errorf(err.Error())
+			} else {
+				c.Assert(echoed, Equals, true)
+				return
+			}
+		}
+	}
+}
+
+// slowPlugin echoes like echoPlugin, but takes a while to answer queries.
+const slowPlugin = `#!/bin/sh
+echo '{"Type":"slowPlugin","Desc":"answers late","InRoutes":["in"],"QueryRoutes":["rule"],"OutRoutes":["out"]}'
+while read -r line; do
+	case "$line" in
+	*'"Query"'*) sleep 1; echo "$line" | sed -e 's/"Query":"rule",//' -e 's/}$/,"Msg":"late"}/' ;;
+	*) echo "$line" | sed 's/"Route":"in"/"Route":"out"/' ;;
+	esac
+done
+`
+
+func (s *PluginSuite) TestPluginLateAnswer(c *C) {
+	log.Println("testing Plugin answering a query no one is waiting for")
+	c.Assert(pluginErr, IsNil)
+
+	b, ch := test_utils.NewBlock("testingPluginLateAnswer", "slowPlugin")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+
+	// give up on the answer, as the engine does after a while
+	ch.QueryChan <- &blocks.QueryMsg{MsgChan: make(blocks.MsgChan), Route: "rule"}
+	time.Sleep(1500 * time.Millisecond)
+
+	inMsg := map[string]interface{}{"hello": "world"}
+	ch.InChan <- &blocks.Msg{Msg: inMsg, Route: "in"}
+	select {
+	case message := <-outChan:
+		c.Assert(message.Msg, DeepEquals, inMsg)
+	case <-time.After(time.Second):
+		c.Fatal("the plugin block is stuck")
+	}
+}
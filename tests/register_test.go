@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"log"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/library"
+	. "launchpad.net/gocheck"
+)
+
+type RegisterSuite struct{}
+
+var registerSuite = Suite(&RegisterSuite{})
+
+func newRegistered() blocks.BlockInterface {
+	return &Panicky{}
+}
+
+// blocks are registered from init, before any suite starts the library.
+var registerErrs []error
+
+func init() {
+	registerErrs = []error{
+		library.Register("testingRegister", newRegistered),
+		library.Register("testingRegister", newRegistered),
+		library.Register("count", newRegistered),
+	}
+}
+
+func (s *RegisterSuite) TestRegister(c *C) {
+	log.Println("testing Register")
+	c.Assert(registerErrs[0], IsNil)
+	_, ok := library.Blocks["testingRegister"]
+	c.Assert(ok, Equals, true)
+
+	c.Assert(registerErrs[1], NotNil)
+	c.Assert(registerErrs[2], NotNil)
+
+	library.Start()
+	_, ok = library.BlockDefs["testingRegister"]
+	c.Assert(ok, Equals, true)
+
+	err := library.Register("testingRegisterLate", newRegistered)
+	c.Assert(err, NotNil)
+	_, ok = library.Blocks["testingRegisterLate"]
+	c.Assert(ok, Equals, false)
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/engine"
+	"github.com/nytlabs/streamtools/st/library"
+	. "launchpad.net/gocheck"
+)
+
+type RunSuite struct{}
+
+var runSuite = Suite(&RunSuite{})
+
+func (s *RunSuite) TestRun(c *C) {
+	log.Println("testing Run")
+	library.Start()
+	defer func(settle time.Duration) {
+		engine.SettleTime = settle
+	}(engine.SettleTime)
+	engine.SettleTime = 100 * time.Millisecond
+
+	f, err := ioutil.TempFile("", "streamtools_test_run")
+	c.Assert(err, IsNil)
+	defer os.Remove(f.Name())
+
+	// the last line has no newline, and still has to come through
+	for i := 0; i < 250; i++ {
+		fmt.Fprintf(f, "{\"n\": %d}", i)
+		if i < 249 {
+			fmt.Fprintln(f)
+		}
+	}
+	f.Close()
+
+	e := engine.New()
+	_, _, err = e.Import(&engine.Pattern{
+		Blocks: []*engine.BlockInfo{
+			{Id: "file", Type: "fromfile", Rule: map[string]interface{}{"Filename": f.Name()}, Overflow: blocks.BACKPRESSURE},
+			{Id: "pack", Type: "packbycount", Rule: map[string]interface{}{"MaxCount": 100.0}, Overflow: blocks.BACKPRESSURE},
+		},
+		Connections: []*engine.ConnectionInfo{
+			{Id: "filepack", FromId: "file", ToId: "pack", ToRoute: "in"},
+		},
+	})
+	c.Assert(err, IsNil)
+	defer e.Clear()
+
+	out, subId, err := e.Subscribe("pack", "out")
+	c.Assert(err, IsNil)
+	defer e.Unsubscribe("pack", subId, out)
+
+	var sizes []int
+	done := make(chan bool)
+	go func() {
+		for msg := range out {
+			pack := msg.Msg.(map[string]interface{})["Pack"].([]interface{})
+			sizes = append(sizes, len(pack))
+			if len(sizes) == 3 {
+				close(done)
+				return
+			}
+		}
+	}()
+
+	errs, err := e.Run()
+	c.Assert(err, IsNil)
+	c.Assert(errs, Equals, int64(0))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the packs")
+	}
+	c.Assert(sizes, DeepEquals, []int{100, 100, 50})
+
+	stats, err := e.BlockStats("file")
+	c.Assert(err, IsNil)
+	c.Assert(stats.Exhausted, Equals, true)
+	c.Assert(stats.Emitted["out"], Equals, int64(250))
+}
@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	. "launchpad.net/gocheck"
+)
+
+type SampleSuite struct{}
+
+var sampleSuite = Suite(&SampleSuite{})
+
+func (s *SampleSuite) TestBernoulli(c *C) {
+	log.Println("testing bernoulli sampling")
+	for _, p := range []float64{0, 0.5, 1} {
+		ch, _, all := newClockedBlock(c, fmt.Sprintf("testingSampleBernoulli%g", p), "sample", map[string]interface{}{
+			"Probability": p,
+		}, "out")
+		go func() {
+			for i := 0; i < 400; i++ {
+				ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"n": float64(i)}, Route: "in"}
+			}
+		}()
+		n := float64(len(routesOut(all)["out"]))
+		c.Assert(n >= 400*p-80 && n <= 400*p+80, Equals, true, Commentf("%g of 400 sampled with probability %g", n, p))
+		ch.QuitChan <- true
+	}
+}
+
+func (s *SampleSuite) TestHashSample(c *C) {
+	log.Println("testing hash sampling")
+	ch, _, all := newClockedBlock(c, "testingSampleHash", "sample", map[string]interface{}{
+		"Mode":        "hash",
+		"KeyPath":     ".user",
+		"Probability": 0.5,
+	}, "out")
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	go func() {
+		for i := 0; i < 200; i++ {
+			ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"user": fmt.Sprintf("user%d", i%100)}, Route: "in"}
+		}
+	}()
+
+	// each user is either always in the sample or never.
+	sampled := map[interface{}]int{}
+	for _, msg := range routesOut(all)["out"] {
+		sampled[msg.(map[string]interface{})["user"]]++
+	}
+	for user, n := range sampled {
+		c.Assert(n, Equals, 2, Commentf("%s", user))
+	}
+	c.Assert(len(sampled) >= 25 && len(sampled) <= 75, Equals, true, Commentf("%d of 100 users sampled", len(sampled)))
+}
+
+func (s *SampleSuite) TestReservoir(c *C) {
+	log.Println("testing reservoir sampling")
+	ch, clock, all := newClockedBlock(c, "testingSampleReservoir", "sample", map[string]interface{}{
+		"Mode":   "reservoir",
+		"K":      3.0,
+		"Window": "10s",
+	}, "out")
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	sent := map[float64]bool{}
+	for i := 0; i < 10; i++ {
+		sent[float64(i)] = true
+		ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"n": float64(i)}, Route: "in"}
+	}
+
+	var result map[string]interface{}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		q := make(blocks.MsgChan)
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "reservoir"}
+		result = (<-q).(map[string]interface{})
+		if result["Seen"] == 10.0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(result["Seen"], Equals, 10.0)
+	c.Assert(result["Sample"], HasLen, 3)
+	for _, msg := range result["Sample"].([]interface{}) {
+		c.Assert(sent[msg.(map[string]interface{})["n"].(float64)], Equals, true)
+	}
+
+	// the reservoir is emitted when its window ends.
+	clock.Advance(10 * time.Second)
+	out := routesOut(all)["out"]
+	c.Assert(out, HasLen, 1)
+	emitted := out[0].(map[string]interface{})
+	c.Assert(emitted["Seen"], Equals, 10.0)
+	c.Assert(emitted["Start"], Equals, time.Unix(0, 0).Format(time.RFC3339Nano))
+	c.Assert(emitted["End"], Equals, time.Unix(10, 0).Format(time.RFC3339Nano))
+	c.Assert(emitted["Sample"], DeepEquals, result["Sample"])
+}
+
+func (s *SampleSuite) TestReservoirNewWindow(c *C) {
+	log.Println("testing a reservoir starts again with a new window")
+	ch, _, _ := newClockedBlock(c, "testingSampleReservoirNewWindow", "sample", map[string]interface{}{
+		"Mode":   "reservoir",
+		"K":      3.0,
+		"Window": "10s",
+	}, "out")
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	reservoir := func() map[string]interface{} {
+		q := make(blocks.MsgChan)
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "reservoir"}
+		return (<-q).(map[string]interface{})
+	}
+
+	for i := 0; i < 5; i++ {
+		ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"n": float64(i)}, Route: "in"}
+	}
+	deadline := time.Now().Add(time.Second)
+	for reservoir()["Seen"] != 5.0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(reservoir()["Seen"], Equals, 5.0)
+
+	setRule(c, ch, map[string]interface{}{
+		"Mode":   "reservoir",
+		"K":      3.0,
+		"Window": "1m",
+	})
+	result := reservoir()
+	c.Assert(result["Seen"], Equals, 0.0)
+	c.Assert(result["Sample"], HasLen, 0)
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/test_utils"
+	. "launchpad.net/gocheck"
+)
+
+type SketchSuite struct{}
+
+var sketchSuite = Suite(&SketchSuite{})
+
+// newSketchBlock starts a sketch block that holds on to every message it is
+// sent, as they are sent faster than it can take them.
+func newSketchBlock(c *C, id, kind string, rule map[string]interface{}) blocks.BlockChans {
+	b, ch := test_utils.NewBlock(id, kind)
+	b.SetOverflow(blocks.BACKPRESSURE)
+	go blocks.BlockRoutine(b)
+	setRule(c, ch, rule)
+	return ch
+}
+
+// waitForEstimate asks the block for its estimate until it holds.
+func waitForEstimate(c *C, ch blocks.BlockChans, holds func(map[string]interface{}) bool) map[string]interface{} {
+	deadline := time.Now().Add(2 * time.Second)
+	var estimate map[string]interface{}
+	for time.Now().Before(deadline) {
+		q := make(blocks.MsgChan)
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "estimate"}
+		estimate = (<-q).(map[string]interface{})
+		if holds(estimate) {
+			return estimate
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("the estimate never held: %v", estimate)
+	return nil
+}
+
+func near(v interface{}, want, tolerance float64) bool {
+	f, ok := v.(float64)
+	return ok && math.Abs(f-want) <= tolerance
+}
+
+func (s *SketchSuite) TestHyperLogLog(c *C) {
+	log.Println("testing hyperloglog")
+	rule := map[string]interface{}{"Path": ".v", "Precision": 12.0}
+	a := newSketchBlock(c, "testingHyperLogLogA", "hyperloglog", rule)
+	defer func() {
+		a.QuitChan <- true
+	}()
+	b := newSketchBlock(c, "testingHyperLogLogB", "hyperloglog", rule)
+	defer func() {
+		b.QuitChan <- true
+	}()
+
+	for i := 0; i < 600; i++ {
+		a.InChan <- &blocks.Msg{Msg: map[string]interface{}{"v": fmt.Sprintf("user%d", i)}, Route: "in"}
+		a.InChan <- &blocks.Msg{Msg: map[string]interface{}{"v": fmt.Sprintf("user%d", i)}, Route: "in"}
+		b.InChan <- &blocks.Msg{Msg: map[string]interface{}{"v": fmt.Sprintf("user%d", i+300)}, Route: "in"}
+	}
+
+	waitForEstimate(c, a, func(e map[string]interface{}) bool {
+		return near(e["Count"], 600, 30)
+	})
+	waitForEstimate(c, b, func(e map[string]interface{}) bool {
+		return near(e["Count"], 600, 30)
+	})
+
+	q := make(blocks.MsgChan)
+	b.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "sketch"}
+	a.InChan <- &blocks.Msg{Msg: <-q, Route: "merge"}
+
+	waitForEstimate(c, a, func(e map[string]interface{}) bool {
+		return near(e["Count"], 900, 45)
+	})
+}
+
+func (s *SketchSuite) TestHyperLogLogWindow(c *C) {
+	log.Println("testing hyperloglog in a window")
+	b, ch := test_utils.NewBlock("testingHyperLogLogWindow", "hyperloglog")
+	clock := blocks.NewManualClock(time.Unix(0, 0))
+	b.SetClock(clock)
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+	setRule(c, ch, map[string]interface{}{"Path": ".v", "Window": "10s"})
+
+	for _, v := range []string{"a", "b", "c"} {
+		ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"v": v}, Route: "in"}
+	}
+	waitForEstimate(c, ch, func(e map[string]interface{}) bool {
+		return e["Count"] == 3.0
+	})
+
+	clock.Advance(11 * time.Second)
+	waitForEstimate(c, ch, func(e map[string]interface{}) bool {
+		return e["Count"] == 0.0
+	})
+}
+
+func (s *SketchSuite) TestTopK(c *C) {
+	log.Println("testing topk")
+	ch := newSketchBlock(c, "testingTopK", "topk", map[string]interface{}{"Path": ".page", "K": 2.0})
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	for page, n := range map[string]int{"home": 50, "news": 30, "sports": 5, "weather": 3} {
+		for i := 0; i < n; i++ {
+			ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"page": page}, Route: "in"}
+		}
+	}
+
+	e := waitForEstimate(c, ch, func(e map[string]interface{}) bool {
+		top := e["TopK"].([]interface{})
+		return len(top) == 2 && top[1].(map[string]interface{})["Count"] == 30.0
+	})
+	c.Assert(e["TopK"], DeepEquals, []interface{}{
+		map[string]interface{}{"Value": "home", "Count": 50.0},
+		map[string]interface{}{"Value": "news", "Count": 30.0},
+	})
+}
+
+func (s *SketchSuite) TestTDigest(c *C) {
+	log.Println("testing tdigest")
+	ch := newSketchBlock(c, "testingTDigest", "tdigest", map[string]interface{}{
+		"Path":      ".v",
+		"Quantiles": []interface{}{0.5, 0.9},
+	})
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	// the numbers go in out of order.
+	for i := 0; i < 1000; i++ {
+		ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"v": float64((i * 7) % 1000)}, Route: "in"}
+	}
+
+	e := waitForEstimate(c, ch, func(e map[string]interface{}) bool {
+		return e["Count"] == 1000.0
+	})
+	quantiles := e["Quantiles"].([]interface{})
+	c.Assert(near(quantiles[0].(map[string]interface{})["Value"], 500, 10), Equals, true, Commentf("%v", quantiles))
+	c.Assert(near(quantiles[1].(map[string]interface{})["Value"], 900, 10), Equals, true, Commentf("%v", quantiles))
+}
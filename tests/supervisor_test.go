@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"log"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	. "launchpad.net/gocheck"
+)
+
+type SupervisorSuite struct{}
+
+var supervisorSuite = Suite(&SupervisorSuite{})
+
+// Panicky panics on any message that asks it to and echoes everything else.
+type Panicky struct {
+	blocks.Block
+	inrule    blocks.MsgChan
+	queryrule chan blocks.MsgChan
+	in        blocks.MsgChan
+	out       blocks.MsgChan
+	quit      blocks.MsgChan
+}
+
+func (b *Panicky) Setup() {
+	b.Kind = "panicky"
+	b.inrule = b.InRoute("rule")
+	b.queryrule = b.QueryRoute("rule")
+	b.in = b.InRoute("in")
+	b.out = b.Broadcast()
+	b.quit = b.Quit()
+}
+
+func (b *Panicky) Run() {
+	var rule interface{}
+	for {
+		select {
+		case rule = <-b.inrule:
+		case c := <-b.queryrule:
+			c <- rule
+		case msg := <-b.in:
+			if msg.(map[string]interface{})["panic"] == true {
+				panic("asked to panic")
+			}
+			b.out <- msg
+		case <-b.quit:
+			return
+		}
+	}
+}
+
+func newPanicky(id string) (blocks.BlockInterface, blocks.BlockChans) {
+	chans := blocks.BlockChans{
+		InChan:         make(chan *blocks.Msg),
+		QueryChan:      make(chan *blocks.QueryMsg),
+		QueryParamChan: make(chan *blocks.QueryParamMsg),
+		AddChan:        make(chan *blocks.AddChanMsg),
+		DelChan:        make(chan *blocks.Msg),
+		IdChan:         make(chan string),
+		ErrChan:        make(chan error),
+		QuitChan:       make(chan bool),
+	}
+
+	b := &Panicky{}
+	b.Build(chans)
+	b.SetId(id)
+	return b, chans
+}
+
+func (s *SupervisorSuite) TestRestart(c *C) {
+	log.Println("testing supervisor restart")
+	blocks.RestartBackoff = 10 * time.Millisecond
+	b, ch := newPanicky("testingRestart")
+	go blocks.BlockRoutine(b)
+
+	ruleMsg := map[string]interface{}{"Some": "rule"}
+	ch.InChan <- &blocks.Msg{Msg: ruleMsg, Route: "rule"}
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"panic": true}, Route: "in"}
+
+	queryOutChan := make(blocks.MsgChan)
+	time.AfterFunc(time.Duration(500)*time.Millisecond, func() {
+		ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"panic": false}, Route: "in"}
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: queryOutChan, Route: "rule"}
+	})
+
+	time.AfterFunc(time.Duration(2)*time.Second, func() {
+		ch.QuitChan <- true
+	})
+
+	echoed := false
+	for {
+		select {
+		case messageI := <-queryOutChan:
+			c.Assert(messageI, DeepEquals, ruleMsg)
+		case message := <-outChan:
+			c.Assert(message.Msg, DeepEquals, map[string]interface{}{"panic": false})
+			echoed = true
+		case err := <-ch.ErrChan:
+			if err != nil {
+				c.Errorf(err.Error())
+			} else {
+				c.Assert(echoed, Equals, true)
+				return
+			}
+		}
+	}
+}
+
+func (s *SupervisorSuite) TestFailed(c *C) {
+	log.Println("testing supervisor giving up")
+	blocks.RestartBackoff = 10 * time.Millisecond
+	maxRestarts := blocks.MaxRestarts
+	blocks.MaxRestarts = 1
+	defer func() {
+		blocks.MaxRestarts = maxRestarts
+	}()
+
+	b, ch := newPanicky("testingFailed")
+	go blocks.BlockRoutine(b)
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"panic": true}, Route: "in"}
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"panic": true}, Route: "in"}
+
+	pingChan := make(blocks.MsgChan)
+	time.AfterFunc(time.Duration(500)*time.Millisecond, func() {
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: pingChan, Route: "ping"}
+	})
+
+	time.AfterFunc(time.Duration(1)*time.Second, func() {
+		ch.QuitChan <- true
+	})
+
+	for {
+		select {
+		case messageI := <-pingChan:
+			c.Assert(messageI, Equals, blocks.FAILED)
+		case err := <-ch.ErrChan:
+			if err != nil {
+				c.Errorf(err.Error())
+			} else {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"log"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	"github.com/nytlabs/streamtools/st/engine"
+	"github.com/nytlabs/streamtools/st/library"
+	"github.com/nytlabs/streamtools/test_utils"
+	. "launchpad.net/gocheck"
+)
+
+type SwitchSuite struct{}
+
+var switchSuite = Suite(&SwitchSuite{})
+
+var switchCases = []interface{}{
+	map[string]interface{}{"Name": "big", "Condition": ".x > 10"},
+	map[string]interface{}{"Name": "even", "Condition": ".x % 2 == 0"},
+}
+
+func (s *SwitchSuite) TestSwitch(c *C) {
+	log.Println("testing switch")
+	b, ch := test_utils.NewBlock("testingSwitch", "switch")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	all := listenRoutes(ch, "big", "even", "default")
+	setRule(c, ch, map[string]interface{}{"Cases": switchCases, "Mode": "first"})
+
+	msgs := []map[string]interface{}{
+		{"x": 12.0},
+		{"x": 4.0},
+		{"x": 3.0},
+	}
+	for _, msg := range msgs {
+		ch.InChan <- &blocks.Msg{Msg: msg, Route: "in"}
+	}
+	c.Assert(routesOut(all), DeepEquals, map[string][]interface{}{
+		"big":     {msgs[0]},
+		"even":    {msgs[1]},
+		"default": {msgs[2]},
+	})
+}
+
+func (s *SwitchSuite) TestSwitchMulti(c *C) {
+	log.Println("testing switch in multi mode")
+	b, ch := test_utils.NewBlock("testingSwitchMulti", "switch")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	all := listenRoutes(ch, "big", "even", "default")
+	setRule(c, ch, map[string]interface{}{"Cases": switchCases, "Mode": "multi"})
+
+	msgs := []map[string]interface{}{
+		{"x": 12.0},
+		{"x": 13.0},
+		{"x": 3.0},
+	}
+	for _, msg := range msgs {
+		ch.InChan <- &blocks.Msg{Msg: msg, Route: "in"}
+	}
+	c.Assert(routesOut(all), DeepEquals, map[string][]interface{}{
+		"big":     {msgs[0], msgs[1]},
+		"even":    {msgs[0]},
+		"default": {msgs[2]},
+	})
+}
+
+func (s *SwitchSuite) TestSwitchInEngine(c *C) {
+	log.Println("testing switch in an engine")
+	library.Start()
+	e := engine.New()
+	defer e.Clear()
+
+	// the routes a switch's cases are named after can be connected to,
+	// though its type doesn't list them.
+	p := &engine.Pattern{
+		Blocks: []*engine.BlockInfo{
+			{Id: "s", Type: "switch", Rule: map[string]interface{}{"Cases": switchCases}},
+		},
+	}
+	_, _, err := e.Import(p)
+	c.Assert(err, IsNil)
+
+	out, _, err := e.Subscribe("s", "even")
+	c.Assert(err, IsNil)
+
+	msg := map[string]interface{}{"x": 4.0}
+	c.Assert(e.Send("s", "in", msg), IsNil)
+	select {
+	case m := <-out:
+		c.Assert(m.Msg, DeepEquals, msg)
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for the message")
+	}
+}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"log"
+	"time"
+
+	"github.com/nytlabs/streamtools/st/blocks"
+	. "launchpad.net/gocheck"
+)
+
+type ThrottleSuite struct{}
+
+var throttleSuite = Suite(&ThrottleSuite{})
+
+// waitForBuckets asks the block for its buckets until there are n of them.
+func waitForBuckets(c *C, ch blocks.BlockChans, n int) []interface{} {
+	deadline := time.Now().Add(time.Second)
+	var buckets []interface{}
+	for time.Now().Before(deadline) {
+		q := make(blocks.MsgChan)
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "buckets"}
+		buckets = (<-q).(map[string]interface{})["Buckets"].([]interface{})
+		if len(buckets) == n {
+			return buckets
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("expected %d buckets, not %v", n, buckets)
+	return nil
+}
+
+func (s *ThrottleSuite) TestRateLimit(c *C) {
+	log.Println("testing throttle rate limiting")
+	ch, clock, all := newClockedBlock(c, "testingThrottleRateLimit", "throttle", map[string]interface{}{
+		"Rate":  1.0,
+		"Burst": 2.0,
+	}, "out", "dropped")
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	msgs := []interface{}{
+		map[string]interface{}{"n": 1.0},
+		map[string]interface{}{"n": 2.0},
+		map[string]interface{}{"n": 3.0},
+		map[string]interface{}{"n": 4.0},
+	}
+	for _, msg := range msgs {
+		ch.InChan <- &blocks.Msg{Msg: msg, Route: "in"}
+	}
+	c.Assert(routesOut(all), DeepEquals, map[string][]interface{}{
+		"out": msgs[:2],
+	})
+	c.Assert(waitForBuckets(c, ch, 1), DeepEquals, []interface{}{
+		map[string]interface{}{"Key": nil, "Tokens": 0.0, "Queued": 2.0},
+	})
+
+	clock.Advance(time.Second)
+	c.Assert(routesOut(all), DeepEquals, map[string][]interface{}{
+		"out": msgs[2:3],
+	})
+	clock.Advance(time.Second)
+	c.Assert(routesOut(all), DeepEquals, map[string][]interface{}{
+		"out": msgs[3:],
+	})
+
+	// the bucket is forgotten once it is full again.
+	clock.Advance(2 * time.Second)
+	waitForBuckets(c, ch, 0)
+}
+
+func (s *ThrottleSuite) TestRateLimitDropByKey(c *C) {
+	log.Println("testing throttle dropping by key")
+	ch, _, all := newClockedBlock(c, "testingThrottleDrop", "throttle", map[string]interface{}{
+		"KeyPath": ".k",
+		"OnLimit": "drop",
+	}, "out", "dropped")
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	msgs := []interface{}{
+		map[string]interface{}{"k": "a", "n": 1.0},
+		map[string]interface{}{"k": "a", "n": 2.0},
+		map[string]interface{}{"k": "b", "n": 3.0},
+	}
+	for _, msg := range msgs {
+		ch.InChan <- &blocks.Msg{Msg: msg, Route: "in"}
+	}
+	c.Assert(routesOut(all), DeepEquals, map[string][]interface{}{
+		"out":     {msgs[0], msgs[2]},
+		"dropped": {msgs[1]},
+	})
+}
+
+func (s *ThrottleSuite) TestDebounce(c *C) {
+	log.Println("testing throttle debouncing")
+	ch, clock, all := newClockedBlock(c, "testingThrottleDebounce", "throttle", map[string]interface{}{
+		"Mode":     "debounce",
+		"Interval": "1s",
+	}, "out", "dropped")
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	msgs := []interface{}{
+		map[string]interface{}{"n": 1.0},
+		map[string]interface{}{"n": 2.0},
+		map[string]interface{}{"n": 3.0},
+	}
+	ch.InChan <- &blocks.Msg{Msg: msgs[0], Route: "in"}
+	waitForBuckets(c, ch, 1)
+
+	// the second message comes before it has been quiet for long enough.
+	clock.Advance(500 * time.Millisecond)
+	ch.InChan <- &blocks.Msg{Msg: msgs[1], Route: "in"}
+	c.Assert(routesOut(all), DeepEquals, map[string][]interface{}{
+		"dropped": msgs[:1],
+	})
+	clock.Advance(500 * time.Millisecond)
+	c.Assert(routesOut(all), DeepEquals, map[string][]interface{}{})
+	clock.Advance(500 * time.Millisecond)
+	c.Assert(routesOut(all), DeepEquals, map[string][]interface{}{
+		"out": msgs[1:2],
+	})
+
+	// a flush emits what is held back straight away.
+	ch.InChan <- &blocks.Msg{Msg: msgs[2], Route: "in"}
+	waitForBuckets(c, ch, 1)
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{}, Route: "flush"}
+	c.Assert(routesOut(all), DeepEquals, map[string][]interface{}{
+		"out": msgs[2:],
+	})
+}
+
+func (s *ThrottleSuite) TestFirstAndLast(c *C) {
+	log.Println("testing throttle first and last in an interval")
+	msgs := []interface{}{
+		map[string]interface{}{"n": 1.0},
+		map[string]interface{}{"n": 2.0},
+		map[string]interface{}{"n": 3.0},
+	}
+
+	for mode, want := range map[string]map[string][]interface{}{
+		"first": {"out": msgs[:1], "dropped": msgs[1:]},
+		"last":  {"out": msgs[2:], "dropped": msgs[:2]},
+	} {
+		ch, clock, all := newClockedBlock(c, "testingThrottle"+mode, "throttle", map[string]interface{}{
+			"Mode":     mode,
+			"Interval": "1s",
+		}, "out", "dropped")
+		for _, msg := range msgs {
+			ch.InChan <- &blocks.Msg{Msg: msg, Route: "in"}
+		}
+		waitForBuckets(c, ch, 1)
+		clock.Advance(time.Second)
+		c.Assert(routesOut(all), DeepEquals, want, Commentf("in %s mode", mode))
+		ch.QuitChan <- true
+	}
+}
+
+func (s *ThrottleSuite) TestThrottleBadMode(c *C) {
+	log.Println("testing throttle keeps its rule when the mode is bad")
+	ch, _, _ := newClockedBlock(c, "testingThrottleBadMode", "throttle", map[string]interface{}{
+		"Mode":     "debounce",
+		"Interval": "1s",
+	}, "out", "dropped")
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	ch.InChan <- &blocks.Msg{Msg: map[string]interface{}{"Mode": "sometimes"}, Route: "rule"}
+
+	// a rule that is turned down doesn't say so on any route, so give the
+	// block a moment to have read it.
+	time.Sleep(100 * time.Millisecond)
+
+	q := make(blocks.MsgChan)
+	ch.QueryChan <- &blocks.QueryMsg{MsgChan: q, Route: "rule"}
+	c.Assert((<-q).(map[string]interface{})["Mode"], Equals, "debounce")
+}
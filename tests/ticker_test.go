@@ -19,39 +19,43 @@ func (s *TickerSuite) TestTicker(c *C) {
 	loghub.Start()
 	log.Println("testing Ticker")
 	b, ch := test_utils.NewBlock("testingTicker", "ticker")
+	clock := blocks.NewManualClock(time.Unix(0, 0))
+	b.SetClock(clock)
 	go blocks.BlockRoutine(b)
-
-	time.AfterFunc(time.Duration(5)*time.Second, func() {
+	defer func() {
 		ch.QuitChan <- true
-	})
+	}()
 
 	outChan := make(chan *blocks.Msg)
 	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
 
-	queryOutChan := make(blocks.MsgChan)
-	ch.QueryChan <- &blocks.QueryMsg{MsgChan: queryOutChan, Route: "rule"}
-
 	ruleMsg := map[string]interface{}{"Interval": "1s"}
 	toRule := &blocks.Msg{Msg: ruleMsg, Route: "rule"}
 	ch.InChan <- toRule
 
+	// the rule and the query race each other, so ask until the rule is in
+	queryOutChan := make(blocks.MsgChan)
 	for {
-		select {
-		case err := <-ch.ErrChan:
-			if err != nil {
-				c.Errorf(err.Error())
-			} else {
-				return
-			}
-		case messageI := <-queryOutChan:
-			if !reflect.DeepEqual(messageI, ruleMsg) {
-				log.Println("Rule mismatch:", messageI, ruleMsg)
-				c.Fail()
-			}
-
-		case messageI := <-outChan:
-			message := messageI.Msg.(map[string]interface{})
-			c.Assert(message["tick"], NotNil)
+		ch.QueryChan <- &blocks.QueryMsg{MsgChan: queryOutChan, Route: "rule"}
+		if reflect.DeepEqual(<-queryOutChan, ruleMsg) {
+			break
 		}
 	}
+
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case messageI := <-outChan:
+		log.Println("ticked early:", messageI.Msg)
+		c.Fail()
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case messageI := <-outChan:
+		message := messageI.Msg.(map[string]interface{})
+		c.Assert(message["tick"], Equals, time.Unix(1, 0).String())
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for a tick")
+	}
 }
@@ -45,7 +45,7 @@ func (s *WebRequestSuite) TestWebRequestPost(c *C) {
 	defer ts.Close()
 
 	headers := map[string]interface{}{"Content-Type": "application/json"}
-	ruleMsg := map[string]interface{}{"Url": ts.URL, "UrlPath": "", "BodyPath": ".", "Method": "POST", "Headers": headers}
+	ruleMsg := map[string]interface{}{"Url": ts.URL, "UrlPath": "", "BodyPath": ".", "Method": "POST", "Headers": headers, "SplitByStatus": false}
 	toRule := &blocks.Msg{Msg: ruleMsg, Route: "rule"}
 	ch.InChan <- toRule
 
@@ -112,7 +112,7 @@ func (s *WebRequestSuite) TestWebRequestGet(c *C) {
 	defer ts.Close()
 
 	headers := map[string]interface{}{"Content-Type": "application/json"}
-	ruleMsg := map[string]interface{}{"Url": ts.URL, "UrlPath": "", "BodyPath": ".", "Method": "GET", "Headers": headers}
+	ruleMsg := map[string]interface{}{"Url": ts.URL, "UrlPath": "", "BodyPath": ".", "Method": "GET", "Headers": headers, "SplitByStatus": false}
 	toRule := &blocks.Msg{Msg: ruleMsg, Route: "rule"}
 	ch.InChan <- toRule
 
@@ -180,7 +180,7 @@ func (s *WebRequestSuite) TestWebRequestGetXML(c *C) {
 	defer ts.Close()
 
 	headers := map[string]interface{}{"Content-Type": "application/xml"}
-	ruleMsg := map[string]interface{}{"Url": ts.URL, "UrlPath": "", "BodyPath": ".", "Method": "GET", "Headers": headers}
+	ruleMsg := map[string]interface{}{"Url": ts.URL, "UrlPath": "", "BodyPath": ".", "Method": "GET", "Headers": headers, "SplitByStatus": false}
 	toRule := &blocks.Msg{Msg: ruleMsg, Route: "rule"}
 	ch.InChan <- toRule
 
@@ -247,7 +247,7 @@ func (s *WebRequestSuite) TestWebRequestGetUrlPath(c *C) {
 	defer ts.Close()
 
 	headers := map[string]interface{}{"Content-Type": "application/json"}
-	ruleMsg := map[string]interface{}{"Url": "", "UrlPath": ".url", "BodyPath": ".", "Method": "GET", "Headers": headers}
+	ruleMsg := map[string]interface{}{"Url": "", "UrlPath": ".url", "BodyPath": ".", "Method": "GET", "Headers": headers, "SplitByStatus": false}
 	toRule := &blocks.Msg{Msg: ruleMsg, Route: "rule"}
 	ch.InChan <- toRule
 
@@ -315,7 +315,7 @@ func (s *WebRequestSuite) TestWebRequestPostUrlPath(c *C) {
 	defer ts.Close()
 
 	headers := map[string]interface{}{"Content-Type": "application/json"}
-	ruleMsg := map[string]interface{}{"Url": "", "UrlPath": ".url", "BodyPath": ".foo", "Method": "POST", "Headers": headers}
+	ruleMsg := map[string]interface{}{"Url": "", "UrlPath": ".url", "BodyPath": ".foo", "Method": "POST", "Headers": headers, "SplitByStatus": false}
 	toRule := &blocks.Msg{Msg: ruleMsg, Route: "rule"}
 	ch.InChan <- toRule
 
@@ -356,3 +356,77 @@ func (s *WebRequestSuite) TestWebRequestPostUrlPath(c *C) {
 		}
 	}
 }
+
+func (s *WebRequestSuite) TestWebRequestSplitByStatus(c *C) {
+	log.Println("testing WebRequest: SplitByStatus")
+	b, ch := test_utils.NewBlock("testingWebRequestSplitByStatus", "webRequest")
+	go blocks.BlockRoutine(b)
+	defer func() {
+		ch.QuitChan <- true
+	}()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	outChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "1", Channel: outChan}
+	okChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "2", FromRoute: "ok", Channel: okChan}
+	failedChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "3", FromRoute: "failed", Channel: failedChan}
+	errChan := make(chan *blocks.Msg)
+	ch.AddChan <- &blocks.AddChanMsg{Route: "4", FromRoute: "error", Channel: errChan}
+
+	ruleMsg := map[string]interface{}{"Url": "", "UrlPath": ".url", "BodyPath": ".", "Method": "GET", "SplitByStatus": true}
+	ch.InChan <- &blocks.Msg{Msg: ruleMsg, Route: "rule"}
+
+	// a request that can't be made at all carries the message that made it.
+	msgs := []map[string]interface{}{
+		{"url": ts.URL},
+		{"url": ts.URL + "/missing"},
+		{"url": "http://127.0.0.1:0"},
+	}
+	for _, msg := range msgs {
+		ch.InChan <- &blocks.Msg{Msg: msg, Route: "in"}
+	}
+
+	var ok, failed []map[string]interface{}
+	for len(ok)+len(failed) < len(msgs) {
+		select {
+		case m := <-outChan:
+			c.Fatalf("unexpected message on out: %v", m.Msg)
+		case m := <-okChan:
+			ok = append(ok, m.Msg.(map[string]interface{}))
+		case m := <-failedChan:
+			failed = append(failed, m.Msg.(map[string]interface{}))
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for responses")
+		}
+	}
+
+	c.Assert(ok, HasLen, 1)
+	c.Assert(ok[0]["status"], Equals, "200 OK")
+	c.Assert(failed, HasLen, 2)
+	c.Assert(failed[0]["status"], Equals, "404 Not Found")
+	for i, f := range failed {
+		c.Assert(f["msg"], DeepEquals, msgs[i+1])
+		c.Assert(f["error"], Not(Equals), "")
+		for _, k := range []string{"body", "headers", "status"} {
+			_, ok := f[k]
+			c.Assert(ok, Equals, true, Commentf("failed message has no %s", k))
+		}
+	}
+
+	// failed requests aren't also reported on the error route.
+	select {
+	case m := <-errChan:
+		c.Fatalf("unexpected error: %v", m.Msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}